@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"option-manager/internal/config"
+	"option-manager/internal/database"
+	"option-manager/internal/repository"
+	"option-manager/internal/service"
+
+	"golang.org/x/term"
+)
+
+// withServices connects to the database and runs fn with the services. The
+// context marks audit events as coming from the command line.
+func withServices(cfg *config.Config, name string, fn func(ctx context.Context, services *service.Services) error) error {
+	db, err := database.Connect(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	services, err := newServices(cfg, db)
+	if err != nil {
+		return fmt.Errorf("failed to initialize services: %w", err)
+	}
+
+	ctx := service.WithRequestInfo(context.Background(), service.RequestInfo{
+		UserAgent: "cli/" + name,
+	})
+	return fn(ctx, services)
+}
+
+// resolveUser finds a user by ID or, if arg isn't a number, by email
+func resolveUser(ctx context.Context, services *service.Services, arg string) (*repository.User, error) {
+	if id, err := strconv.Atoi(arg); err == nil {
+		return services.User.GetUser(ctx, id)
+	}
+	return services.User.GetUserByEmail(ctx, arg)
+}
+
+// runUserCommand runs fn on the single user named by args
+func runUserCommand(cfg *config.Config, name string, args []string, fn func(ctx context.Context, services *service.Services, user *repository.User) error) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	return withServices(cfg, name, func(ctx context.Context, services *service.Services) error {
+		user, err := resolveUser(ctx, services, args[0])
+		if err != nil {
+			return err
+		}
+		return fn(ctx, services, user)
+	})
+}
+
+func runCreateUser(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("create-user", flag.ContinueOnError)
+	email := flags.String("email", "", "email address")
+	firstName := flags.String("first-name", "", "first name")
+	lastName := flags.String("last-name", "", "last name")
+	verified := flags.Bool("verified", false, "mark the email as verified")
+	role := flags.String("role", service.RoleUser, "role: user, support or admin")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errUsage
+	}
+
+	// The password is never taken as an argument, where it would end up in
+	// shell history and process listings
+	plaintext, err := readPassword()
+	if err != nil {
+		return err
+	}
+
+	return withServices(cfg, "create-user", func(ctx context.Context, services *service.Services) error {
+		user, err := services.User.RegisterUser(ctx, service.RegistrationInput{
+			Email:     strings.TrimSpace(*email),
+			Password:  plaintext,
+			FirstName: strings.TrimSpace(*firstName),
+			LastName:  strings.TrimSpace(*lastName),
+			Verified:  *verified,
+		})
+		if err != nil {
+			return err
+		}
+		if *role != service.RoleUser {
+			if err := services.Admin.SetRole(ctx, user.ID, *role); err != nil {
+				return fmt.Errorf("created user %d but failed to set its role: %w", user.ID, err)
+			}
+		}
+
+		fmt.Printf("created user %d (%s)\n", user.ID, user.Email)
+		return nil
+	})
+}
+
+// readPassword prompts for a password without echoing it when stdin is a
+// terminal, and otherwise reads the first line of stdin
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		plaintext, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password: %w", err)
+		}
+		return string(plaintext), nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func runVerifyUser(cfg *config.Config, args []string) error {
+	return runUserCommand(cfg, "verify-user", args, func(ctx context.Context, services *service.Services, user *repository.User) error {
+		if err := services.Admin.VerifyUser(ctx, user.ID); err != nil {
+			return err
+		}
+		fmt.Printf("verified %s\n", user.Email)
+		return nil
+	})
+}
+
+func runPromoteAdmin(cfg *config.Config, args []string) error {
+	return runUserCommand(cfg, "promote-admin", args, func(ctx context.Context, services *service.Services, user *repository.User) error {
+		if err := services.Admin.SetRole(ctx, user.ID, service.RoleAdmin); err != nil {
+			return err
+		}
+		fmt.Printf("%s is now an admin\n", user.Email)
+		return nil
+	})
+}
+
+func runRevokeSessions(cfg *config.Config, args []string) error {
+	return runUserCommand(cfg, "revoke-sessions", args, func(ctx context.Context, services *service.Services, user *repository.User) error {
+		if err := services.Admin.RevokeUserSessions(ctx, user.ID); err != nil {
+			return err
+		}
+		fmt.Printf("signed %s out everywhere\n", user.Email)
+		return nil
+	})
+}
+
+func runPurgeExpired(cfg *config.Config, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	return withServices(cfg, "purge-expired", func(ctx context.Context, services *service.Services) error {
+		n, err := services.Auth.PurgeExpiredSessions(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("deleted %d expired sessions\n", n)
+
+		deleted, err := services.AccountData.PurgeDeletedAccounts(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("deleted %d accounts past their deletion date\n", deleted)
+		return nil
+	})
+}
+
+func runSendTestEmail(cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	return withServices(cfg, "send-test-email", func(ctx context.Context, services *service.Services) error {
+		if err := services.Email.SendTestEmail(ctx, args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("sent a test email to %s\n", args[0])
+		return nil
+	})
+}
+
+func runUnsuppressEmail(cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	return withServices(cfg, "unsuppress-email", func(ctx context.Context, services *service.Services) error {
+		if err := services.Email.Unsuppress(ctx, args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("email to %s is no longer suppressed\n", args[0])
+		return nil
+	})
+}
@@ -1,174 +1,161 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"net/http"
+	"log/slog"
 	"os"
-	"text/template"
+	"strings"
 
-	"option-manager/internal/database"
+	"option-manager/internal/config"
 	"option-manager/internal/email"
-	"option-manager/internal/handlers"
-	"option-manager/internal/middleware"
+	"option-manager/internal/logging"
+	"option-manager/internal/password"
+	"option-manager/internal/repository"
 	"option-manager/internal/repository/postgres"
+	"option-manager/internal/repository/sqlite"
 	"option-manager/internal/service"
 
 	_ "github.com/lib/pq"
 )
 
+// command is a subcommand of the binary. run gets the arguments after the
+// command's name.
+type command struct {
+	name  string
+	usage string
+	run   func(cfg *config.Config, args []string) error
+}
+
+var commands = []command{
+	{"serve", "serve", runServe},
+	{"migrate", "migrate up | down N | status | force V", runMigrate},
+	{"create-user", "create-user -email E -first-name F -last-name L [-verified] [-role R]", runCreateUser},
+	{"verify-user", "verify-user USER", runVerifyUser},
+	{"promote-admin", "promote-admin USER", runPromoteAdmin},
+	{"revoke-sessions", "revoke-sessions USER", runRevokeSessions},
+	{"purge-expired", "purge-expired", runPurgeExpired},
+	{"send-test-email", "send-test-email ADDRESS", runSendTestEmail},
+	{"unsuppress-email", "unsuppress-email ADDRESS", runUnsuppressEmail},
+}
+
+// errUsage makes main print the command's usage
+var errUsage = errors.New("invalid arguments")
+
 func main() {
-	// Log all relevant environment variables
-	log.Printf("Environment variables:")
-	log.Printf("AWS_REGION: %s", os.Getenv("AWS_REGION"))
-	log.Printf("EMAIL_SENDER: %s", os.Getenv("EMAIL_SENDER"))
-	log.Printf("AWS_ACCESS_KEY_ID: %s", maskString(os.Getenv("AWS_ACCESS_KEY_ID")))
-	log.Printf("AWS_SECRET_ACCESS_KEY: %s", maskString(os.Getenv("AWS_SECRET_ACCESS_KEY")))
-
-	// Configure logging
-	logFile, err := os.OpenFile("app.log", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatal(err)
+	// With no arguments the binary serves, as it always has
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
 	}
-	defer logFile.Close()
 
-	// Use both file and stdout for logging
-	multiWriter := io.MultiWriter(os.Stdout, logFile)
-	log.SetOutput(multiWriter)
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage())
+		os.Exit(2)
+	}
 
-	// Initialize database
-	db, err := database.Connect()
+	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		fatal("Failed to load configuration", err)
 	}
-	defer db.Close()
 
-	// Intialize repositories
-	repo := postgres.NewRepository(db)
-
-	// Initialize email client
-	emailClient, err := email.NewClient(
-		os.Getenv("AWS_REGION"),
-		os.Getenv("EMAIL_SENDER"),
-	)
-	if err != nil {
-		log.Fatalf("Failed to initialize email client: %v", err)
+	// Configure logging. The server logs to stdout; other commands log to
+	// stderr so their output can be piped. Logs also go to the log file if
+	// set.
+	var logOutput io.Writer = os.Stdout
+	if cmd.name != "serve" {
+		logOutput = os.Stderr
+	}
+	if cfg.Log.File != "" {
+		logFile, err := os.OpenFile(cfg.Log.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			fatal("Failed to open log file", err)
+		}
+		defer logFile.Close()
+		logOutput = io.MultiWriter(logOutput, logFile)
 	}
 
-	// Initialize services with email client
-	services, err := service.NewServices(
-		repo,
-		emailClient,
-		os.Getenv("BASE_URL"),
-	)
+	logger, err := logging.New(logOutput, cfg.Log.Format, cfg.Log.Level)
 	if err != nil {
-		log.Fatalf("Failed to initialize services: %v", err)
+		fatal("Failed to configure logging", err)
+	}
+	// Route the standard library logger through slog too
+	slog.SetDefault(logger)
+
+	if err := cmd.run(cfg, args); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "usage: main %s\n", cmd.usage)
+			os.Exit(2)
+		}
+		fatal(cmd.name+" failed", err)
 	}
+}
 
-	// Initialize handlers
-	authHandler, err := handlers.NewAuthHandler(services)
-	if err != nil {
-		log.Fatalf("Failed to initialize auth handler: %v", err)
+func usage() string {
+	var b strings.Builder
+	b.WriteString("usage: main <command> [arguments]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "  %s\n", cmd.usage)
 	}
+	b.WriteString("\nUSER is a user ID or email address.\n")
+	return b.String()
+}
 
-	log.Printf("Creating registration handler with email service...")
-	registrationHandler, err := handlers.NewRegistrationHandler(services)
-	if err != nil {
-		log.Fatalf("Failed to initialize registration handler: %v", err)
+// newServices builds the services on top of db, shared by the server and
+// the admin commands so both apply the same rules
+func newServices(cfg *config.Config, db *sql.DB) (*service.Services, error) {
+	// Intialize repositories
+	var repo *repository.Repository
+	switch cfg.Database.Driver {
+	case config.DriverSQLite:
+		repo = sqlite.NewRepository(db)
+	default:
+		repo = postgres.NewRepository(db)
 	}
-	log.Printf("Registration handler created successfully")
 
-	verificationHandler, err := handlers.NewVerificationHandler(services)
+	// Initialize the configured email transport
+	emailClient, err := email.New(cfg.Email)
 	if err != nil {
-		log.Fatalf("Failed to initialize verification handler: %v", err)
+		return nil, fmt.Errorf("failed to initialize email transport: %w", err)
 	}
 
-	// Create base middleware chain
-	baseChain := []middleware.Middleware{
-		middleware.Logger,    // Add logging first to capture everything
-		middleware.Recoverer, // Recover from panics
+	passwordPolicy := password.DefaultPolicy()
+	if cfg.Password.BreachedListPath != "" {
+		breached, err := password.LoadBreachedList(cfg.Password.BreachedListPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load breached password list: %w", err)
+		}
+		passwordPolicy.Breached = breached
+		slog.Info("loaded breached password list", "passwords", breached.Len())
 	}
 
-	authChain := append(baseChain, middleware.RequireAuth(services))
-
-	// Routes
-	// Public routes with basic middleware
-	http.Handle("/", middleware.Chain(
-		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.URL.Path == "/" {
-				http.Redirect(w, r, "/login", http.StatusSeeOther)
-				return
-			}
-			http.NotFound(w, r)
-		}),
-		baseChain...,
-	))
-
-	// Base routes
-	http.Handle("/login", middleware.Chain(
-		http.HandlerFunc(authHandler.LoginPage),
-		baseChain...,
-	))
-
-	http.Handle("/register", middleware.Chain(
-		http.HandlerFunc(registrationHandler.RegisterPage),
-		baseChain...,
-	))
-
-	http.Handle("/verify", middleware.Chain(
-		http.HandlerFunc(verificationHandler.VerifyEmail),
-		baseChain...,
-	))
-
-	http.Handle("/verification-pending", middleware.Chain(
-		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			tmpl, err := template.ParseFiles("templates/verification-pending.html")
-			if err != nil {
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
-				return
-			}
-			tmpl.Execute(w, nil)
-		}),
-		baseChain...,
-	))
-
-	// Protected routes with full middleware stack
-	http.Handle("/dashboard", middleware.Chain(
-		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			userID, ok := middleware.GetUserID(r.Context())
-			if !ok {
-				http.Error(w, "User not found in context", http.StatusInternalServerError)
-				return
-			}
-			w.Write([]byte(fmt.Sprintf("Dashboard for user %d - Coming soon!", userID)))
-		}),
-		authChain...,
-	))
-
-	http.Handle("/logout", middleware.Chain(
-		http.HandlerFunc(authHandler.Logout),
-		baseChain...,
-	))
-
-	// Health check endpoint with minimal middleware
-	http.Handle("/health", middleware.Chain(
-		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if err := db.Ping(); err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte("Database connection failed"))
-				return
-			}
-			w.Write([]byte("OK"))
-		}),
-		middleware.Logger, // Only use logger for health checks
-	))
+	// Initialize services with email client
+	return service.NewServices(repo, emailClient, service.Options{
+		BaseURL: cfg.Server.BaseURL,
+		SessionPolicy: service.SessionPolicy{
+			IdleTimeout:           cfg.Session.IdleTimeout,
+			MaxLifetime:           cfg.Session.MaxLifetime,
+			RememberMeIdleTimeout: cfg.Session.RememberMeIdleTimeout,
+			RememberMeMaxLifetime: cfg.Session.RememberMeMaxLifetime,
+		},
+		PasswordPolicy: passwordPolicy,
+		EmailDelivery: service.EmailDeliveryPolicy{
+			MaxAttempts:  cfg.Email.MaxAttempts,
+			PollInterval: cfg.Email.PollInterval,
+		},
+	})
 }
 
-// Helper function to mask sensitive values
-func maskString(s string) string {
-	if len(s) == 0 {
-		return "not set"
-	}
-	return "set (length: " + fmt.Sprint(len(s)) + ")"
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"option-manager/internal/config"
+	"option-manager/internal/database"
+)
+
+// runMigrate runs the migrate subcommand against the embedded migrations:
+//
+//	up        apply all pending migrations
+//	down N    roll back the last N migrations
+//	status    list migrations and whether each has been applied
+//	force V   mark version V as applied and clear the dirty flag
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	migrator, err := database.NewMigrator(cfg.Database.Driver, cfg.Database.DSN())
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	switch args[0] {
+	case "up":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return migrator.Up()
+
+	case "down":
+		// Rolling everything back must be asked for explicitly
+		if len(args) != 2 {
+			return errUsage
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid number of migrations %q", args[1])
+		}
+		return migrator.Down(n)
+
+	case "force":
+		if len(args) != 2 {
+			return errUsage
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		return migrator.Force(version)
+
+	case "status":
+		status, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		printMigrationStatus(status)
+		return nil
+
+	default:
+		return errUsage
+	}
+}
+
+func printMigrationStatus(status *database.SchemaStatus) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATE")
+	for _, m := range status.Migrations {
+		state := "pending"
+		switch {
+		case m.Applied:
+			state = "applied"
+		case status.Dirty && int(m.Version) == status.Version:
+			state = "dirty"
+		}
+		fmt.Fprintf(tw, "%06d\t%s\t%s\n", m.Version, m.Name, state)
+	}
+	tw.Flush()
+
+	fmt.Printf("\n%d pending\n", status.Pending())
+	if status.Dirty {
+		fmt.Printf("version %d is dirty: fix the schema by hand, then run 'migrate force' with the last good version\n", status.Version)
+	}
+}
+
+// migrateUp applies pending migrations before the server starts. Replicas
+// starting together wait on the migration lock rather than racing.
+func migrateUp(cfg config.DatabaseConfig) error {
+	migrator, err := database.NewMigrator(cfg.Driver, cfg.DSN())
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+	return migrator.Up()
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/template"
+
+	"option-manager/internal/config"
+	"option-manager/internal/database"
+	"option-manager/internal/handlers"
+	"option-manager/internal/metrics"
+	"option-manager/internal/middleware"
+	"option-manager/internal/service"
+	"option-manager/internal/sns"
+	"option-manager/internal/tracing"
+)
+
+// runServe runs the web server until it receives SIGINT or SIGTERM
+func runServe(cfg *config.Config, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	// Secrets in the config print as [REDACTED]
+	slog.Info("starting", "config", *cfg)
+
+	// Configure tracing. Spans are only exported when an exporter is set.
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter, cfg.Tracing.ServiceName)
+	if err != nil {
+		return fmt.Errorf("failed to configure tracing: %w", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// Initialize database
+	db, err := database.Connect(cfg.Database)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	if cfg.Database.MigrateOnStartup {
+		if err := migrateUp(cfg.Database); err != nil {
+			return fmt.Errorf("failed to apply migrations: %w", err)
+		}
+	}
+
+	if err := metrics.RegisterDB(db, cfg.Database.Driver); err != nil {
+		return fmt.Errorf("failed to register database metrics: %w", err)
+	}
+
+	services, err := newServices(cfg, db)
+	if err != nil {
+		return fmt.Errorf("failed to initialize services: %w", err)
+	}
+
+	if err := metrics.RegisterActiveSessions(services.Auth.CountActiveSessions); err != nil {
+		return fmt.Errorf("failed to register session metrics: %w", err)
+	}
+
+	// Initialize handlers
+	authHandler, err := handlers.NewAuthHandler(services, cfg.Features.Registration)
+	if err != nil {
+		return fmt.Errorf("failed to initialize auth handler: %w", err)
+	}
+
+	registrationHandler, err := handlers.NewRegistrationHandler(services)
+	if err != nil {
+		return fmt.Errorf("failed to initialize registration handler: %w", err)
+	}
+
+	verificationHandler, err := handlers.NewVerificationHandler(services)
+	if err != nil {
+		return fmt.Errorf("failed to initialize verification handler: %w", err)
+	}
+
+	sessionsHandler, err := handlers.NewSessionsHandler(services)
+	if err != nil {
+		return fmt.Errorf("failed to initialize sessions handler: %w", err)
+	}
+
+	accountHandler, err := handlers.NewAccountHandler(services)
+	if err != nil {
+		return fmt.Errorf("failed to initialize account handler: %w", err)
+	}
+
+	adminHandler, err := handlers.NewAdminHandler(services)
+	if err != nil {
+		return fmt.Errorf("failed to initialize admin handler: %w", err)
+	}
+
+	tokensHandler, err := handlers.NewTokensHandler(services)
+	if err != nil {
+		return fmt.Errorf("failed to initialize tokens handler: %w", err)
+	}
+
+	auditHandler, err := handlers.NewAuditHandler(services)
+	if err != nil {
+		return fmt.Errorf("failed to initialize audit handler: %w", err)
+	}
+
+	emailHandler, err := handlers.NewEmailHandler(services)
+	if err != nil {
+		return fmt.Errorf("failed to initialize email handler: %w", err)
+	}
+
+	apiHandler, err := handlers.NewAPIHandler(services)
+	if err != nil {
+		return fmt.Errorf("failed to initialize API handler: %w", err)
+	}
+
+	csrf, err := middleware.CSRF(cfg.Server.BaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to initialize CSRF protection: %w", err)
+	}
+
+	// Create base middleware chain. Chain runs the last middleware first, so
+	// every request is traced, tagged, then logged, before anything can
+	// reject it.
+	baseChain := []middleware.Middleware{
+		csrf,                 // Reject cross-site form posts
+		middleware.Recoverer, // Recover from panics
+		middleware.Logger,    // Log every request, including rejected ones
+		middleware.RequestID, // Tag the request for logs and the audit log
+		middleware.Trace,     // Start the request's span
+	}
+
+	// withBase puts route-specific middleware inside the base chain
+	withBase := func(chain []middleware.Middleware, middlewares ...middleware.Middleware) []middleware.Middleware {
+		return append(append([]middleware.Middleware{}, middlewares...), chain...)
+	}
+
+	authChain := withBase(baseChain, middleware.RequireAuth(services))
+
+	// The API authenticates with bearer tokens rather than cookies, so it
+	// doesn't need CSRF protection
+	apiChain := []middleware.Middleware{
+		middleware.RequireToken(services),
+		middleware.Recoverer,
+		middleware.Logger,
+		middleware.RequestID,
+		middleware.Trace,
+	}
+
+	// permissionChain authenticates first, then checks the permission
+	permissionChain := func(permission string) []middleware.Middleware {
+		return withBase(baseChain,
+			middleware.RequirePermission(services, permission),
+			middleware.RequireAuth(services),
+		)
+	}
+
+	// Routes
+	// Public routes with basic middleware
+	http.Handle("/", middleware.Chain(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/" {
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				return
+			}
+			http.NotFound(w, r)
+		}),
+		baseChain...,
+	))
+
+	// Base routes
+	http.Handle("/login", middleware.Chain(
+		http.HandlerFunc(authHandler.LoginPage),
+		baseChain...,
+	))
+
+	if cfg.Features.Registration {
+		http.Handle("/register", middleware.Chain(
+			http.HandlerFunc(registrationHandler.RegisterPage),
+			baseChain...,
+		))
+	} else {
+		slog.Info("registration is disabled")
+	}
+
+	http.Handle("/verify", middleware.Chain(
+		http.HandlerFunc(verificationHandler.VerifyEmail),
+		baseChain...,
+	))
+
+	http.Handle("/verification-pending", middleware.Chain(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tmpl, err := template.ParseFiles("templates/verification-pending.html")
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			tmpl.Execute(w, nil)
+		}),
+		baseChain...,
+	))
+
+	// Protected routes with full middleware stack
+	http.Handle("/dashboard", middleware.Chain(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := middleware.GetUserID(r.Context())
+			if !ok {
+				http.Error(w, "User not found in context", http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte(fmt.Sprintf("Dashboard for user %d - Coming soon!", userID)))
+		}),
+		authChain...,
+	))
+
+	http.Handle("/sessions", middleware.Chain(
+		http.HandlerFunc(sessionsHandler.SessionsPage),
+		authChain...,
+	))
+
+	http.Handle("/sessions/revoke", middleware.Chain(
+		http.HandlerFunc(sessionsHandler.Revoke),
+		authChain...,
+	))
+
+	http.Handle("/sessions/revoke-others", middleware.Chain(
+		http.HandlerFunc(sessionsHandler.RevokeOthers),
+		authChain...,
+	))
+
+	http.Handle("/account/password", middleware.Chain(
+		http.HandlerFunc(accountHandler.ChangePasswordPage),
+		authChain...,
+	))
+
+	http.Handle("/account/email", middleware.Chain(
+		http.HandlerFunc(accountHandler.ChangeEmailPage),
+		authChain...,
+	))
+
+	// The links in email change emails work without signing in
+	http.Handle("/account/email/confirm", middleware.Chain(
+		http.HandlerFunc(accountHandler.ConfirmEmailChange),
+		baseChain...,
+	))
+
+	http.Handle("/account/email/cancel", middleware.Chain(
+		http.HandlerFunc(accountHandler.CancelEmailChange),
+		baseChain...,
+	))
+
+	http.Handle("/account/data", middleware.Chain(
+		http.HandlerFunc(accountHandler.AccountDataPage),
+		authChain...,
+	))
+
+	http.Handle("/account/data/export", middleware.Chain(
+		http.HandlerFunc(accountHandler.ExportAccountData),
+		authChain...,
+	))
+
+	http.Handle("/tokens", middleware.Chain(
+		http.HandlerFunc(tokensHandler.TokensPage),
+		authChain...,
+	))
+
+	http.Handle("/tokens/create", middleware.Chain(
+		http.HandlerFunc(tokensHandler.Create),
+		authChain...,
+	))
+
+	http.Handle("/tokens/revoke", middleware.Chain(
+		http.HandlerFunc(tokensHandler.Revoke),
+		authChain...,
+	))
+
+	http.Handle("/account/security", middleware.Chain(
+		http.HandlerFunc(auditHandler.SecurityHistory),
+		authChain...,
+	))
+
+	// Admin routes, each gated by its own permission
+	http.Handle("/admin", middleware.Chain(
+		http.HandlerFunc(adminHandler.Index),
+		permissionChain(service.PermissionAdminAccess)...,
+	))
+
+	http.Handle("/admin/users", middleware.Chain(
+		http.HandlerFunc(adminHandler.Users),
+		permissionChain(service.PermissionUsersRead)...,
+	))
+
+	http.Handle("/admin/users/resend-verification", middleware.Chain(
+		http.HandlerFunc(adminHandler.ResendVerification),
+		permissionChain(service.PermissionUsersResendVerification)...,
+	))
+
+	http.Handle("/admin/users/disable", middleware.Chain(
+		http.HandlerFunc(adminHandler.Disable),
+		permissionChain(service.PermissionUsersDisable)...,
+	))
+
+	http.Handle("/admin/users/enable", middleware.Chain(
+		http.HandlerFunc(adminHandler.Enable),
+		permissionChain(service.PermissionUsersDisable)...,
+	))
+
+	http.Handle("/admin/users/revoke-sessions", middleware.Chain(
+		http.HandlerFunc(adminHandler.RevokeSessions),
+		permissionChain(service.PermissionUsersRevokeSessions)...,
+	))
+
+	http.Handle("/admin/audit", middleware.Chain(
+		http.HandlerFunc(auditHandler.AdminSearch),
+		permissionChain(service.PermissionAuditRead)...,
+	))
+
+	http.Handle("/admin/email", middleware.Chain(
+		http.HandlerFunc(emailHandler.AdminOutbox),
+		permissionChain(service.PermissionEmailRead)...,
+	))
+
+	// JSON API
+	if cfg.Features.API {
+		http.Handle("/api/v1/", middleware.Chain(apiHandler, apiChain...))
+
+		http.Handle("/api/v1/openapi.json", middleware.Chain(
+			http.HandlerFunc(apiHandler.Spec),
+			middleware.Recoverer,
+			middleware.Logger,
+			middleware.RequestID,
+			middleware.Trace,
+		))
+	} else {
+		slog.Info("the API is disabled")
+	}
+
+	http.Handle("/logout", middleware.Chain(
+		http.HandlerFunc(authHandler.Logout),
+		baseChain...,
+	))
+
+	// SES bounce and complaint notifications, authenticated by SNS's
+	// signature rather than a session
+	if cfg.Email.SNSTopicARN != "" {
+		sesHandler, err := handlers.NewSESHandler(services, sns.NewVerifier(nil), cfg.Email.SNSTopicARN)
+		if err != nil {
+			return fmt.Errorf("failed to initialize SES handler: %w", err)
+		}
+		http.Handle("/webhooks/ses", middleware.Chain(
+			http.HandlerFunc(sesHandler.Notify),
+			middleware.Recoverer,
+			middleware.Logger,
+			middleware.RequestID,
+			middleware.Trace,
+		))
+	} else {
+		slog.Info("EMAIL_SNS_TOPIC_ARN is not set; bounces and complaints won't be recorded")
+	}
+
+	// Prometheus metrics, guarded by their own token rather than user auth.
+	// Without a metrics token the endpoint is not served at all.
+	if cfg.Metrics.Token != "" {
+		http.Handle("/metrics", middleware.Chain(
+			metrics.Handler(),
+			middleware.RequireMetricsToken(string(cfg.Metrics.Token)),
+			middleware.Recoverer,
+		))
+	} else {
+		slog.Info("METRICS_TOKEN is not set; /metrics is disabled")
+	}
+
+	// Health check endpoint with minimal middleware
+	http.Handle("/health", middleware.Chain(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := db.Ping(); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Database connection failed"))
+				return
+			}
+			w.Write([]byte("OK"))
+		}),
+		middleware.Logger, // Only use logger for health checks
+		middleware.RequestID,
+	))
+
+	// Serve until interrupted, then give in-flight requests time to finish
+	server := &http.Server{
+		Addr:              cfg.Server.Address,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Send queued email in the background until shutdown
+	deliveryDone := make(chan struct{})
+	go func() {
+		defer close(deliveryDone)
+		services.Email.RunDelivery(ctx)
+	}()
+
+	// Delete accounts whose grace period has ended
+	purgeDone := make(chan struct{})
+	go func() {
+		defer close(purgeDone)
+		services.AccountData.RunPurge(ctx)
+	}()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("listening", "address", server.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		return fmt.Errorf("server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down", "timeout", cfg.Server.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("graceful shutdown failed: %w", err)
+	}
+
+	// Let a send or purge in progress finish recording its outcome
+	for _, done := range []chan struct{}{deliveryDone, purgeDone} {
+		select {
+		case <-done:
+		case <-shutdownCtx.Done():
+		}
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+// Package emails embeds the email templates. Each directory is a locale
+// holding a layout.tmpl, shared by every message, and one NAME.tmpl per
+// message. A message defines "subject", "html" and "text"; the layout
+// defines "layout.html" and "layout.text", which wrap the message's parts.
+// The en locale is the fallback and must have every message.
+package emails
+
+import "embed"
+
+//go:embed */*.tmpl
+var FS embed.FS
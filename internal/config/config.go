@@ -0,0 +1,417 @@
+// internal/config/config.go
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the application's configuration. It is loaded from an optional
+// YAML file and then from environment variables, which win over the file.
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Database DatabaseConfig `yaml:"database"`
+	Email    EmailConfig    `yaml:"email"`
+	Session  SessionConfig  `yaml:"session"`
+	Password PasswordConfig `yaml:"password"`
+	Log      LogConfig      `yaml:"log"`
+	Tracing  TracingConfig  `yaml:"tracing"`
+	Metrics  MetricsConfig  `yaml:"metrics"`
+	Features FeatureFlags   `yaml:"features"`
+}
+
+type ServerConfig struct {
+	// Address is the host:port the HTTP server listens on
+	Address string `yaml:"address"`
+	// BaseURL is the public URL of the site, used in emails and CSRF checks
+	BaseURL           string        `yaml:"base_url"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	// ShutdownTimeout bounds how long in-flight requests get to finish
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// Database drivers
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+type DatabaseConfig struct {
+	// Driver is postgres or sqlite
+	Driver string `yaml:"driver"`
+	// Path is the SQLite database file. The Postgres settings below are
+	// ignored when using SQLite.
+	Path string `yaml:"path"`
+
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password Secret `yaml:"password"`
+	Name     string `yaml:"name"`
+	SSLMode  string `yaml:"sslmode"`
+
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+
+	// MigrateOnStartup applies pending migrations before serving
+	MigrateOnStartup bool `yaml:"migrate_on_startup"`
+}
+
+// DSN returns the connection string for the configured driver: lib/pq's
+// format for Postgres or a file URI for SQLite
+func (c DatabaseConfig) DSN() string {
+	if c.Driver == DriverSQLite {
+		return sqliteDSN(c.Path)
+	}
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		quoteDSN(c.Host),
+		c.Port,
+		quoteDSN(c.User),
+		quoteDSN(string(c.Password)),
+		quoteDSN(c.Name),
+		quoteDSN(c.SSLMode),
+	)
+}
+
+// sqliteDSN turns on foreign keys, which SQLite leaves off by default, and
+// waits for locks rather than failing at once. Immediate transactions take
+// the write lock up front, so they can't deadlock upgrading a read lock.
+func sqliteDSN(path string) string {
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Set("_time_format", "sqlite")
+	params.Set("_txlock", "immediate")
+	return "file:" + path + "?" + params.Encode()
+}
+
+// quoteDSN quotes a connection string value so spaces and quotes in it
+// survive parsing
+func quoteDSN(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
+// Email transports
+const (
+	EmailTransportSES  = "ses"
+	EmailTransportSMTP = "smtp"
+	EmailTransportFile = "file"
+	EmailTransportLog  = "log"
+)
+
+// SMTP TLS modes
+const (
+	// SMTPStartTLS upgrades a plain connection, usually on port 587
+	SMTPStartTLS = "starttls"
+	// SMTPImplicitTLS connects over TLS from the start, usually on port 465
+	SMTPImplicitTLS = "tls"
+	// SMTPNoTLS never encrypts; only for servers on localhost
+	SMTPNoTLS = "none"
+)
+
+type EmailConfig struct {
+	// Transport is ses, smtp, file (write .eml files to Dir) or log
+	Transport string `yaml:"transport"`
+	Sender    string `yaml:"sender"`
+	AWSRegion string `yaml:"aws_region"`
+	// Dir is where the file transport saves emails
+	Dir  string     `yaml:"dir"`
+	SMTP SMTPConfig `yaml:"smtp"`
+	// SNSTopicARN is the SNS topic SES publishes bounces and complaints to.
+	// Setting it serves /webhooks/ses to receive them.
+	SNSTopicARN string `yaml:"sns_topic_arn"`
+	// MaxAttempts is how many times a queued email is tried before it is
+	// given up on
+	MaxAttempts int `yaml:"max_attempts"`
+	// PollInterval is how often the server checks for queued email
+	PollInterval time.Duration `yaml:"poll_interval"`
+}
+
+type SMTPConfig struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// Username enables authentication with PLAIN, or LOGIN if the server
+	// doesn't offer PLAIN
+	Username string `yaml:"username"`
+	Password Secret `yaml:"password"`
+	// TLS is starttls, tls or none
+	TLS string `yaml:"tls"`
+}
+
+// IsLoopback reports whether Host is this machine, the only place mail may
+// be sent unencrypted
+func (c SMTPConfig) IsLoopback() bool {
+	if strings.EqualFold(c.Host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(c.Host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// SessionConfig mirrors service.SessionPolicy
+type SessionConfig struct {
+	IdleTimeout           time.Duration `yaml:"idle_timeout"`
+	MaxLifetime           time.Duration `yaml:"max_lifetime"`
+	RememberMeIdleTimeout time.Duration `yaml:"remember_me_idle_timeout"`
+	RememberMeMaxLifetime time.Duration `yaml:"remember_me_max_lifetime"`
+}
+
+type PasswordConfig struct {
+	// BreachedListPath replaces the bundled breached password list with one
+	// built by gen_breached.go
+	BreachedListPath string `yaml:"breached_list_path"`
+}
+
+type LogConfig struct {
+	Format string `yaml:"format"`
+	Level  string `yaml:"level"`
+	// File, if set, receives a copy of everything logged to stdout
+	File string `yaml:"file"`
+}
+
+type TracingConfig struct {
+	Exporter    string `yaml:"exporter"`
+	ServiceName string `yaml:"service_name"`
+}
+
+type MetricsConfig struct {
+	// Token is the bearer token Prometheus must send; empty disables /metrics
+	Token Secret `yaml:"token"`
+}
+
+// FeatureFlags switch optional parts of the site on and off
+type FeatureFlags struct {
+	// Registration allows new users to sign up
+	Registration bool `yaml:"registration"`
+	// API serves the JSON API under /api/v1
+	API bool `yaml:"api"`
+}
+
+// Default returns the configuration used for anything not set in the file
+// or environment
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Address:           ":8080",
+			ReadHeaderTimeout: 10 * time.Second,
+			ShutdownTimeout:   20 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Driver:          DriverPostgres,
+			Host:            "localhost",
+			Port:            5432,
+			SSLMode:         "disable",
+			MaxOpenConns:    25,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+		},
+		Email: EmailConfig{
+			Transport: EmailTransportSES,
+			SMTP: SMTPConfig{
+				Port: 587,
+				TLS:  SMTPStartTLS,
+			},
+			MaxAttempts:  8,
+			PollInterval: 5 * time.Second,
+		},
+		Session: SessionConfig{
+			IdleTimeout:           24 * time.Hour,
+			MaxLifetime:           7 * 24 * time.Hour,
+			RememberMeIdleTimeout: 30 * 24 * time.Hour,
+			RememberMeMaxLifetime: 90 * 24 * time.Hour,
+		},
+		Log: LogConfig{
+			Format: "text",
+			Level:  "info",
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			ServiceName: "option-manager",
+		},
+		Features: FeatureFlags{
+			Registration: true,
+			API:          true,
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, the YAML file named by
+// CONFIG_FILE if it is set, and the environment, in that order. Every problem
+// found is reported in the returned error, not just the first.
+func Load() (*Config, error) {
+	return load(os.Getenv("CONFIG_FILE"), os.LookupEnv)
+}
+
+func load(path string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	env := &envLoader{lookup: lookupEnv}
+	env.string("LISTEN_ADDR", &cfg.Server.Address)
+	env.string("BASE_URL", &cfg.Server.BaseURL)
+	env.duration("READ_HEADER_TIMEOUT", &cfg.Server.ReadHeaderTimeout)
+	env.duration("SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout)
+
+	env.string("DB_DRIVER", &cfg.Database.Driver)
+	env.string("DB_PATH", &cfg.Database.Path)
+	env.string("DB_HOST", &cfg.Database.Host)
+	env.int("DB_PORT", &cfg.Database.Port)
+	env.string("DB_USER", &cfg.Database.User)
+	env.secret("DB_PASSWORD", &cfg.Database.Password)
+	env.string("DB_NAME", &cfg.Database.Name)
+	env.string("DB_SSLMODE", &cfg.Database.SSLMode)
+	env.int("DB_MAX_OPEN_CONNS", &cfg.Database.MaxOpenConns)
+	env.int("DB_MAX_IDLE_CONNS", &cfg.Database.MaxIdleConns)
+	env.duration("DB_CONN_MAX_LIFETIME", &cfg.Database.ConnMaxLifetime)
+	env.duration("DB_CONN_MAX_IDLE_TIME", &cfg.Database.ConnMaxIdleTime)
+	env.bool("DB_MIGRATE_ON_STARTUP", &cfg.Database.MigrateOnStartup)
+
+	env.string("EMAIL_TRANSPORT", &cfg.Email.Transport)
+	env.string("EMAIL_SENDER", &cfg.Email.Sender)
+	env.string("AWS_REGION", &cfg.Email.AWSRegion)
+	env.string("EMAIL_DIR", &cfg.Email.Dir)
+	env.string("SMTP_HOST", &cfg.Email.SMTP.Host)
+	env.int("SMTP_PORT", &cfg.Email.SMTP.Port)
+	env.string("SMTP_USERNAME", &cfg.Email.SMTP.Username)
+	env.secret("SMTP_PASSWORD", &cfg.Email.SMTP.Password)
+	env.string("SMTP_TLS", &cfg.Email.SMTP.TLS)
+	env.string("EMAIL_SNS_TOPIC_ARN", &cfg.Email.SNSTopicARN)
+	env.int("EMAIL_MAX_ATTEMPTS", &cfg.Email.MaxAttempts)
+	env.duration("EMAIL_POLL_INTERVAL", &cfg.Email.PollInterval)
+
+	env.duration("SESSION_IDLE_TIMEOUT", &cfg.Session.IdleTimeout)
+	env.duration("SESSION_MAX_LIFETIME", &cfg.Session.MaxLifetime)
+	env.duration("SESSION_REMEMBER_ME_IDLE_TIMEOUT", &cfg.Session.RememberMeIdleTimeout)
+	env.duration("SESSION_REMEMBER_ME_MAX_LIFETIME", &cfg.Session.RememberMeMaxLifetime)
+
+	env.string("BREACHED_PASSWORDS_FILE", &cfg.Password.BreachedListPath)
+
+	env.string("LOG_FORMAT", &cfg.Log.Format)
+	env.string("LOG_LEVEL", &cfg.Log.Level)
+	env.string("LOG_FILE", &cfg.Log.File)
+
+	env.string("TRACE_EXPORTER", &cfg.Tracing.Exporter)
+	env.string("TRACE_SERVICE_NAME", &cfg.Tracing.ServiceName)
+
+	env.secret("METRICS_TOKEN", &cfg.Metrics.Token)
+
+	env.bool("FEATURE_REGISTRATION", &cfg.Features.Registration)
+	env.bool("FEATURE_API", &cfg.Features.API)
+
+	problems := append(env.problems, cfg.Validate()...)
+	if len(problems) > 0 {
+		return nil, &Error{Problems: problems}
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	// Unknown keys are rejected so typos don't silently fall back to defaults
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Error lists everything wrong with a configuration
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// envLoader overrides config values from environment variables, collecting
+// values that fail to parse rather than stopping at the first
+type envLoader struct {
+	lookup   func(string) (string, bool)
+	problems []string
+}
+
+func (l *envLoader) value(name string) (string, bool) {
+	value, ok := l.lookup(name)
+	if !ok || strings.TrimSpace(value) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(value), true
+}
+
+func (l *envLoader) string(name string, dst *string) {
+	if value, ok := l.value(name); ok {
+		*dst = value
+	}
+}
+
+func (l *envLoader) secret(name string, dst *Secret) {
+	if value, ok := l.value(name); ok {
+		*dst = Secret(value)
+	}
+}
+
+func (l *envLoader) int(name string, dst *int) {
+	value, ok := l.value(name)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		l.problems = append(l.problems, fmt.Sprintf("%s must be a whole number, got %q", name, value))
+		return
+	}
+	*dst = n
+}
+
+func (l *envLoader) bool(name string, dst *bool) {
+	value, ok := l.value(name)
+	if !ok {
+		return
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		l.problems = append(l.problems, fmt.Sprintf("%s must be true or false, got %q", name, value))
+		return
+	}
+	*dst = b
+}
+
+func (l *envLoader) duration(name string, dst *time.Duration) {
+	value, ok := l.value(name)
+	if !ok {
+		return
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		l.problems = append(l.problems, fmt.Sprintf("%s must be a duration such as 30s or 24h, got %q", name, value))
+		return
+	}
+	*dst = d
+}
@@ -0,0 +1,245 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// requiredEnv sets the settings that have no default
+var requiredEnv = map[string]string{
+	"BASE_URL":     "https://example.com",
+	"DB_USER":      "app",
+	"DB_NAME":      "options",
+	"AWS_REGION":   "eu-west-1",
+	"EMAIL_SENDER": "noreply@example.com",
+}
+
+// env returns a lookup over requiredEnv with vars layered on top. An empty
+// value in vars unsets the variable.
+func env(vars map[string]string) func(string) (string, bool) {
+	merged := make(map[string]string)
+	for name, value := range requiredEnv {
+		merged[name] = value
+	}
+	for name, value := range vars {
+		if value == "" {
+			delete(merged, name)
+			continue
+		}
+		merged[name] = value
+	}
+	return func(name string) (string, bool) {
+		value, ok := merged[name]
+		return value, ok
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFromEnv(t *testing.T) {
+	cfg, err := load("", env(map[string]string{
+		"DB_PORT":              "6543",
+		"DB_PASSWORD":          "hunter2",
+		"SESSION_IDLE_TIMEOUT": "2h",
+		"FEATURE_REGISTRATION": "false",
+		"LOG_LEVEL":            "  debug  ",
+		"LOG_FORMAT":           "   ", // blank values are ignored
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Database.Port != 6543 {
+		t.Errorf("Database.Port = %d, want 6543", cfg.Database.Port)
+	}
+	if string(cfg.Database.Password) != "hunter2" {
+		t.Errorf("Database.Password wasn't read from DB_PASSWORD")
+	}
+	if cfg.Session.IdleTimeout != 2*time.Hour {
+		t.Errorf("Session.IdleTimeout = %s, want 2h", cfg.Session.IdleTimeout)
+	}
+	if cfg.Features.Registration {
+		t.Error("Features.Registration = true, want false")
+	}
+	if cfg.Log.Level != "debug" {
+		t.Errorf("Log.Level = %q, want debug", cfg.Log.Level)
+	}
+	if cfg.Log.Format != "text" {
+		t.Errorf("Log.Format = %q, want the default", cfg.Log.Format)
+	}
+	if cfg.Server.Address != ":8080" {
+		t.Errorf("Server.Address = %q, want the default", cfg.Server.Address)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	_, err := load("", env(map[string]string{
+		"BASE_URL":             "",
+		"EMAIL_SENDER":         "",
+		"DB_PORT":              "five",
+		"FEATURE_API":          "maybe",
+		"SESSION_IDLE_TIMEOUT": "soon",
+		"DB_SSLMODE":           "sometimes",
+		"LOG_LEVEL":            "loud",
+	}))
+
+	var cfgErr *Error
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("load = %v, want a *config.Error", err)
+	}
+	want := []string{
+		`DB_PORT must be a whole number, got "five"`,
+		`FEATURE_API must be true or false, got "maybe"`,
+		"SESSION_IDLE_TIMEOUT",
+		"base URL is required",
+		"email sender is required",
+		`database sslmode "sometimes"`,
+		`log level "loud"`,
+	}
+	for _, w := range want {
+		found := false
+		for _, problem := range cfgErr.Problems {
+			if strings.Contains(problem, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("problems %q don't mention %q", cfgErr.Problems, w)
+		}
+		if !strings.Contains(err.Error(), w) {
+			t.Errorf("error text doesn't mention %q:\n%s", w, err)
+		}
+	}
+	if len(cfgErr.Problems) != len(want) {
+		t.Errorf("got %d problems, want %d: %q", len(cfgErr.Problems), len(want), cfgErr.Problems)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+server:
+  address: ":9000"
+  base_url: https://file.example.com
+database:
+  name: from_file
+  max_open_conns: 40
+log:
+  level: warn
+`)
+	cfg, err := load(path, env(map[string]string{
+		"BASE_URL": "",
+		"DB_NAME":  "from_env",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		{"default, not in file or env", cfg.Database.Port, 5432},
+		{"file over default", cfg.Server.Address, ":9000"},
+		{"file over default", cfg.Database.MaxOpenConns, 40},
+		{"file, not in env", cfg.Server.BaseURL, "https://file.example.com"},
+		{"env over file", cfg.Database.Name, "from_env"},
+		{"env over default", cfg.Database.User, "app"},
+		{"file over default", cfg.Log.Level, "warn"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadRejectsBadFile(t *testing.T) {
+	tests := map[string]string{
+		"unknown key":  "server:\n  adress: \":9000\"\n",
+		"wrong type":   "database:\n  port: lots\n",
+		"invalid YAML": "server: [\n",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := load(writeConfigFile(t, content), env(nil)); err == nil {
+				t.Error("load succeeded")
+			}
+		})
+	}
+
+	if _, err := load(filepath.Join(t.TempDir(), "missing.yaml"), env(nil)); err == nil {
+		t.Error("load succeeded with a missing file")
+	}
+	// An empty file leaves the defaults alone
+	if _, err := load(writeConfigFile(t, ""), env(nil)); err != nil {
+		t.Errorf("load with an empty file: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *Config {
+		cfg, err := load("", env(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+	if problems := valid().Validate(); problems != nil {
+		t.Fatalf("Validate = %q for a valid config", problems)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   string
+	}{
+		{"address", func(c *Config) { c.Server.Address = "8080" }, "must be host:port"},
+		{"relative base URL", func(c *Config) { c.Server.BaseURL = "/app" }, "must be an absolute http or https URL"},
+		{"unknown driver", func(c *Config) { c.Database.Driver = "mysql" }, "must be postgres or sqlite"},
+		{"sqlite without path", func(c *Config) { c.Database.Driver = DriverSQLite }, "database path is required"},
+		{"sqlite path with query", func(c *Config) { c.Database.Driver, c.Database.Path = DriverSQLite, "app.db?mode=ro" }, "can't contain ? or #"},
+		{"idle over open", func(c *Config) { c.Database.MaxIdleConns = 30 }, "can't exceed max open connections"},
+		{"smtp without host", func(c *Config) { c.Email.Transport = EmailTransportSMTP }, "SMTP host is required"},
+		{"smtp username without password", func(c *Config) {
+			c.Email.Transport, c.Email.SMTP.Host, c.Email.SMTP.Username = EmailTransportSMTP, "smtp.example.com", "app"
+		}, "SMTP password is required"},
+		{"smtp unencrypted to a remote host", func(c *Config) {
+			c.Email.Transport, c.Email.SMTP.Host, c.Email.SMTP.TLS = EmailTransportSMTP, "smtp.example.com", SMTPNoTLS
+		}, "only allowed for a server on localhost"},
+		{"sender", func(c *Config) { c.Email.Sender = "noreply" }, "is not an email address"},
+		{"topic ARN", func(c *Config) { c.Email.SNSTopicARN = "topic" }, "must start with arn:aws"},
+		{"session idle over lifetime", func(c *Config) { c.Session.IdleTimeout = 8 * 24 * time.Hour }, "can't exceed max lifetime"},
+		{"trace exporter", func(c *Config) { c.Tracing.Exporter = "jaeger" }, "must be none, stdout or otlp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.modify(cfg)
+			problems := cfg.Validate()
+			if len(problems) != 1 || !strings.Contains(problems[0], tt.want) {
+				t.Errorf("Validate = %q, want one problem mentioning %q", problems, tt.want)
+			}
+		})
+	}
+
+	// Unencrypted SMTP is fine to a server on this machine
+	for _, host := range []string{"localhost", "127.0.0.1", "::1"} {
+		cfg := valid()
+		cfg.Email.Transport, cfg.Email.SMTP.Host, cfg.Email.SMTP.TLS = EmailTransportSMTP, host, SMTPNoTLS
+		if problems := cfg.Validate(); problems != nil {
+			t.Errorf("Validate with unencrypted SMTP to %s = %q, want nil", host, problems)
+		}
+	}
+}
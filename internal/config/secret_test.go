@@ -0,0 +1,93 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const secretValue = "hunter2-s3cr3t"
+
+func TestSecretMethods(t *testing.T) {
+	secret := Secret(secretValue)
+
+	text, err := secret.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonValue, err := secret.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"String", secret.String(), redacted},
+		{"GoString", secret.GoString(), `"` + redacted + `"`},
+		{"LogValue", secret.LogValue().String(), redacted},
+		{"MarshalJSON", string(jsonValue), `"` + redacted + `"`},
+		{"MarshalText", string(text), redacted},
+		{"converted to string", string(secret), secretValue},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+
+	// An unset secret shows as empty, so it's clear it isn't set
+	var empty Secret
+	if empty.String() != "" || empty.LogValue().String() != "" {
+		t.Errorf("empty secret = %q, want empty", empty.String())
+	}
+}
+
+// A whole Config must be safe to print, log or dump, however it's done
+func TestSecretsDontLeak(t *testing.T) {
+	cfg := Default()
+	cfg.Database.Password = secretValue
+	cfg.Email.SMTP.Password = secretValue
+	cfg.Metrics.Token = secretValue
+
+	var logged bytes.Buffer
+	slog.New(slog.NewJSONHandler(&logged, nil)).Info("config", "config", cfg, "password", cfg.Database.Password)
+	var loggedText bytes.Buffer
+	slog.New(slog.NewTextHandler(&loggedText, nil)).Info("config", "password", cfg.Database.Password, "smtp", cfg.Email.SMTP)
+
+	jsonDump, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	yamlDump, err := yaml.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputs := map[string]string{
+		"fmt %v":       fmt.Sprintf("%v", cfg),
+		"fmt %+v":      fmt.Sprintf("%+v", cfg),
+		"fmt %#v":      fmt.Sprintf("%#v", cfg),
+		"fmt %s":       fmt.Sprintf("%s", cfg.Database.Password),
+		"slog JSON":    logged.String(),
+		"slog text":    loggedText.String(),
+		"json.Marshal": string(jsonDump),
+		"yaml.Marshal": string(yamlDump),
+		"fmt.Sprint":   fmt.Sprint(cfg.Database.Password),
+	}
+	for name, out := range outputs {
+		if strings.Contains(out, secretValue) {
+			t.Errorf("%s leaked the secret:\n%s", name, out)
+		}
+	}
+	if !strings.Contains(string(jsonDump), redacted) {
+		t.Errorf("json.Marshal = %s, want the secrets shown as %s", jsonDump, redacted)
+	}
+}
@@ -0,0 +1,171 @@
+// internal/config/validate.go
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+
+	"option-manager/internal/logging"
+	"option-manager/internal/tracing"
+)
+
+var sslModes = map[string]bool{
+	"disable":     true,
+	"allow":       true,
+	"prefer":      true,
+	"require":     true,
+	"verify-ca":   true,
+	"verify-full": true,
+}
+
+// Validate returns a description of every invalid setting, or nil if the
+// configuration is usable
+func (c *Config) Validate() []string {
+	var problems []string
+	problem := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// Server
+	if _, _, err := net.SplitHostPort(c.Server.Address); err != nil {
+		problem("server address %q must be host:port, e.g. :8080", c.Server.Address)
+	}
+	if c.Server.BaseURL == "" {
+		problem("base URL is required")
+	} else if u, err := url.Parse(c.Server.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problem("base URL %q must be an absolute http or https URL", c.Server.BaseURL)
+	}
+	if c.Server.ReadHeaderTimeout <= 0 {
+		problem("read header timeout must be positive")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		problem("shutdown timeout must be positive")
+	}
+
+	// Database
+	switch c.Database.Driver {
+	case DriverPostgres:
+		if c.Database.Host == "" {
+			problem("database host is required")
+		}
+		if c.Database.Port < 1 || c.Database.Port > 65535 {
+			problem("database port %d is out of range", c.Database.Port)
+		}
+		if c.Database.User == "" {
+			problem("database user is required")
+		}
+		if c.Database.Name == "" {
+			problem("database name is required")
+		}
+		if !sslModes[c.Database.SSLMode] {
+			problem("database sslmode %q must be one of disable, allow, prefer, require, verify-ca or verify-full", c.Database.SSLMode)
+		}
+	case DriverSQLite:
+		if c.Database.Path == "" {
+			problem("database path is required for sqlite")
+		} else if strings.ContainsAny(c.Database.Path, "?#") {
+			problem("database path %q can't contain ? or #", c.Database.Path)
+		}
+	default:
+		problem("database driver %q must be postgres or sqlite", c.Database.Driver)
+	}
+	if c.Database.MaxOpenConns < 0 {
+		problem("database max open connections can't be negative")
+	}
+	if c.Database.MaxIdleConns < 0 {
+		problem("database max idle connections can't be negative")
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		problem("database max idle connections (%d) can't exceed max open connections (%d)", c.Database.MaxIdleConns, c.Database.MaxOpenConns)
+	}
+	if c.Database.ConnMaxLifetime < 0 || c.Database.ConnMaxIdleTime < 0 {
+		problem("database connection lifetimes can't be negative")
+	}
+
+	// Email
+	switch c.Email.Transport {
+	case EmailTransportSES:
+		if c.Email.AWSRegion == "" {
+			problem("AWS region is required for the ses email transport")
+		}
+	case EmailTransportSMTP:
+		smtp := c.Email.SMTP
+		if smtp.Host == "" {
+			problem("SMTP host is required for the smtp email transport")
+		}
+		if smtp.Port < 1 || smtp.Port > 65535 {
+			problem("SMTP port %d is out of range", smtp.Port)
+		}
+		switch smtp.TLS {
+		case SMTPStartTLS, SMTPImplicitTLS, SMTPNoTLS:
+		default:
+			problem("SMTP tls %q must be starttls, tls or none", smtp.TLS)
+		}
+		if smtp.TLS == SMTPNoTLS && smtp.Host != "" && !smtp.IsLoopback() {
+			problem("SMTP tls none is only allowed for a server on localhost, not %q", smtp.Host)
+		}
+		if smtp.Username != "" && smtp.Password == "" {
+			problem("SMTP password is required with an SMTP username")
+		}
+	case EmailTransportFile:
+		if c.Email.Dir == "" {
+			problem("email directory is required for the file email transport")
+		}
+	case EmailTransportLog:
+	default:
+		problem("email transport %q must be ses, smtp, file or log", c.Email.Transport)
+	}
+	if c.Email.Sender == "" {
+		problem("email sender is required")
+	} else if !strings.Contains(c.Email.Sender, "@") {
+		problem("email sender %q is not an email address", c.Email.Sender)
+	}
+	if c.Email.SNSTopicARN != "" && !strings.HasPrefix(c.Email.SNSTopicARN, "arn:aws") {
+		problem("SNS topic ARN %q must start with arn:aws", c.Email.SNSTopicARN)
+	}
+	if c.Email.MaxAttempts <= 0 {
+		problem("email max attempts must be positive")
+	}
+	if c.Email.PollInterval <= 0 {
+		problem("email poll interval must be positive")
+	}
+
+	// Sessions
+	s := c.Session
+	if s.IdleTimeout <= 0 || s.MaxLifetime <= 0 || s.RememberMeIdleTimeout <= 0 || s.RememberMeMaxLifetime <= 0 {
+		problem("session lifetimes must be positive")
+	} else {
+		if s.IdleTimeout > s.MaxLifetime {
+			problem("session idle timeout (%s) can't exceed max lifetime (%s)", s.IdleTimeout, s.MaxLifetime)
+		}
+		if s.RememberMeIdleTimeout > s.RememberMeMaxLifetime {
+			problem("remember-me idle timeout (%s) can't exceed remember-me max lifetime (%s)", s.RememberMeIdleTimeout, s.RememberMeMaxLifetime)
+		}
+	}
+
+	// Logging
+	switch strings.ToLower(c.Log.Format) {
+	case logging.FormatText, logging.FormatJSON:
+	default:
+		problem("log format %q must be text or json", c.Log.Format)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		problem("log level %q must be debug, info, warn or error", c.Log.Level)
+	}
+
+	// Tracing
+	switch strings.ToLower(c.Tracing.Exporter) {
+	case tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP:
+	default:
+		problem("trace exporter %q must be none, stdout or otlp", c.Tracing.Exporter)
+	}
+	if c.Tracing.ServiceName == "" {
+		problem("trace service name is required")
+	}
+
+	return problems
+}
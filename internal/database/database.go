@@ -3,37 +3,42 @@ package database
 import (
 	"database/sql"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
 	"time"
 
+	"option-manager/internal/config"
+
 	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
-// Connect establishes a connection to the database with retry logic
-func Connect() (*sql.DB, error) {
-	dbURL := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_NAME"),
-	)
-
+// Connect establishes a connection to the database with retry logic and
+// applies the configured pool limits
+func Connect(cfg config.DatabaseConfig) (*sql.DB, error) {
 	var db *sql.DB
 	var err error
 
 	// Retry logic for database connection
 	for i := 0; i < 5; i++ {
-		db, err = sql.Open("postgres", dbURL)
+		db, err = sql.Open(cfg.Driver, cfg.DSN())
 		if err == nil {
 			err = db.Ping()
 			if err == nil {
-				log.Println("Successfully connected to database")
+				db.SetMaxOpenConns(cfg.MaxOpenConns)
+				db.SetMaxIdleConns(cfg.MaxIdleConns)
+				db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+				db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+				if cfg.Driver == config.DriverSQLite {
+					slog.Info("connected to database", "driver", cfg.Driver, "path", cfg.Path)
+				} else {
+					slog.Info("connected to database", "driver", cfg.Driver, "host", cfg.Host, "sslmode", cfg.SSLMode)
+				}
 				return db, nil
 			}
+			db.Close()
 		}
-		log.Printf("Failed to connect to database, attempt %d/5: %v", i+1, err)
+		slog.Warn("failed to connect to database", "attempt", i+1, "max_attempts", 5, "error", err)
 		time.Sleep(5 * time.Second)
 	}
 
@@ -0,0 +1,193 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"strings"
+	"time"
+
+	"option-manager/internal/config"
+	"option-manager/migrations"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// migrationLockTimeout is how long a migration waits for another process
+// holding the migration lock, e.g. a replica that started at the same time
+const migrationLockTimeout = 5 * time.Minute
+
+// Migrator applies the migrations embedded in the binary. Postgres and SQLite
+// have separate migration sets. On Postgres every change runs under an
+// advisory lock, so replicas that start together apply each migration once
+// and the rest wait for it to finish.
+type Migrator struct {
+	source  source.Driver
+	migrate *migrate.Migrate
+}
+
+// MigrationStatus describes one embedded migration
+type MigrationStatus struct {
+	Version uint
+	Name    string
+	Applied bool
+}
+
+// SchemaStatus is the database's schema version against the embedded
+// migrations
+type SchemaStatus struct {
+	// Version is the last applied migration, or -1 if none have run. The
+	// first migration is version 0.
+	Version int
+	// Dirty means a migration failed part-way and must be fixed by hand,
+	// then recorded with Force
+	Dirty      bool
+	Migrations []MigrationStatus
+}
+
+// Pending returns the number of migrations not yet applied
+func (s *SchemaStatus) Pending() int {
+	n := 0
+	for _, m := range s.Migrations {
+		if !m.Applied {
+			n++
+		}
+	}
+	return n
+}
+
+// NewMigrator opens its own connection to dsn, separate from the
+// application's pool, since closing the migrator closes it. driver is
+// config.DriverPostgres or config.DriverSQLite.
+func NewMigrator(driver, dsn string) (*Migrator, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	var (
+		dir      string
+		instance database.Driver
+	)
+	switch driver {
+	case config.DriverPostgres:
+		dir = "."
+		instance, err = postgres.WithInstance(db, &postgres.Config{})
+	case config.DriverSQLite:
+		dir = "sqlite"
+		instance, err = sqlite.WithInstance(db, &sqlite.Config{})
+	default:
+		err = fmt.Errorf("unknown database driver %q", driver)
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare migrations: %w", err)
+	}
+
+	src, err := iofs.New(migrations.FS, dir)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read embedded migrations: %w", err)
+	}
+
+	m, err := migrate.NewWithInstance("iofs", src, driver, instance)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare migrations: %w", err)
+	}
+	m.LockTimeout = migrationLockTimeout
+	m.Log = migrateLogger{}
+
+	return &Migrator{source: src, migrate: m}, nil
+}
+
+// Up applies every pending migration
+func (m *Migrator) Up() error {
+	if err := m.migrate.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("failed to migrate up: %w", err)
+	}
+	return nil
+}
+
+// Down rolls back the last n applied migrations
+func (m *Migrator) Down(n int) error {
+	if n <= 0 {
+		return errors.New("number of migrations to roll back must be positive")
+	}
+	if err := m.migrate.Steps(-n); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("failed to migrate down: %w", err)
+	}
+	return nil
+}
+
+// Force records version as applied and clears the dirty flag without running
+// anything. Use it after repairing a failed migration by hand.
+func (m *Migrator) Force(version int) error {
+	if err := m.migrate.Force(version); err != nil {
+		return fmt.Errorf("failed to force version %d: %w", version, err)
+	}
+	return nil
+}
+
+// Status reports the applied version and which embedded migrations have run
+func (m *Migrator) Status() (*SchemaStatus, error) {
+	status := &SchemaStatus{Version: -1}
+
+	version, dirty, err := m.migrate.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return nil, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if err == nil {
+		status.Version = int(version)
+		status.Dirty = dirty
+	}
+
+	// A dirty version didn't finish, so it doesn't count as applied
+	applied := func(v uint) bool {
+		return err == nil && (v < version || (v == version && !dirty))
+	}
+
+	v, srcErr := m.source.First()
+	for srcErr == nil {
+		name := ""
+		if r, identifier, err := m.source.ReadUp(v); err == nil {
+			r.Close()
+			name = identifier
+		}
+		status.Migrations = append(status.Migrations, MigrationStatus{
+			Version: v,
+			Name:    name,
+			Applied: applied(v),
+		})
+		v, srcErr = m.source.Next(v)
+	}
+	if !errors.Is(srcErr, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to list embedded migrations: %w", srcErr)
+	}
+
+	return status, nil
+}
+
+// Close closes the migrator's connection
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.migrate.Close()
+	return errors.Join(srcErr, dbErr)
+}
+
+// migrateLogger sends golang-migrate's progress messages to slog
+type migrateLogger struct{}
+
+func (migrateLogger) Printf(format string, v ...any) {
+	slog.Info("migrate: " + strings.TrimSpace(fmt.Sprintf(format, v...)))
+}
+
+func (migrateLogger) Verbose() bool {
+	return false
+}
@@ -0,0 +1,82 @@
+// internal/email/dev.go
+package email
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"option-manager/internal/logging"
+)
+
+// FileTransport saves each email as an .eml file in a directory instead of
+// sending it, for development. The files open in most mail clients.
+type FileTransport struct {
+	dir    string
+	sender string
+}
+
+// NewFileTransport creates a transport that writes to dir, creating it if
+// needed
+func NewFileTransport(dir, sender string) (*FileTransport, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("email directory is required")
+	}
+	if sender == "" {
+		return nil, fmt.Errorf("sender email is required")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create email directory: %w", err)
+	}
+
+	return &FileTransport{dir: dir, sender: sender}, nil
+}
+
+func (t *FileTransport) Send(ctx context.Context, content *EmailContent) error {
+	now := time.Now()
+	msg, err := buildMessage(t.sender, content, now)
+	if err != nil {
+		return err
+	}
+
+	// Names sort in the order the emails were sent
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Errorf("failed to name email file: %w", err)
+	}
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000"), hex.EncodeToString(suffix))
+	path := filepath.Join(t.dir, name)
+
+	// The emails hold working verification links, so keep them private
+	if err := os.WriteFile(path, msg.data, 0o600); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+
+	logging.FromContext(ctx).Info("email saved", "to", content.To, "subject", content.Subject, "path", path)
+	return nil
+}
+
+// LogTransport logs each email instead of sending it, for development. Only
+// the text body is logged.
+type LogTransport struct {
+	sender string
+}
+
+// NewLogTransport creates a transport that writes emails to the log
+func NewLogTransport(sender string) *LogTransport {
+	return &LogTransport{sender: sender}
+}
+
+func (t *LogTransport) Send(ctx context.Context, content *EmailContent) error {
+	logging.FromContext(ctx).Info("email not sent; logging it instead",
+		"from", t.sender,
+		"to", content.To,
+		"subject", content.Subject,
+		"text", content.TextBody,
+	)
+	return nil
+}
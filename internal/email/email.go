@@ -1,20 +1,23 @@
 // internal/email/email.go
+
+// Package email delivers email through a configurable transport: Amazon
+// SES, an SMTP server, or, for development, files or the log.
 package email
 
 import (
 	"context"
 	"fmt"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/ses"
-	"github.com/aws/aws-sdk-go-v2/service/ses/types"
+	"option-manager/internal/config"
+	"option-manager/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Client handles the low-level email sending functionality
-type Client struct {
-	sesClient *ses.Client
-	sender    string
+// Transport delivers email
+type Transport interface {
+	Send(ctx context.Context, content *EmailContent) error
 }
 
 // EmailContent represents the content of an email
@@ -25,30 +28,51 @@ type EmailContent struct {
 	TextBody string
 }
 
-// NewClient creates a new email client
-func NewClient(awsRegion, sender string) (*Client, error) {
-	if awsRegion == "" {
-		return nil, fmt.Errorf("AWS region is required")
-	}
-	if sender == "" {
+// New returns the transport chosen by cfg.Transport, sending from
+// cfg.Sender. Every send is checked and traced.
+func New(cfg config.EmailConfig) (Transport, error) {
+	if cfg.Sender == "" {
 		return nil, fmt.Errorf("sender email is required")
 	}
 
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(awsRegion),
-	)
+	var transport Transport
+	var err error
+	switch cfg.Transport {
+	case config.EmailTransportSES:
+		transport, err = NewSESTransport(cfg.AWSRegion, cfg.Sender)
+	case config.EmailTransportSMTP:
+		transport, err = NewSMTPTransport(cfg.SMTP, cfg.Sender)
+	case config.EmailTransportFile:
+		transport, err = NewFileTransport(cfg.Dir, cfg.Sender)
+	case config.EmailTransportLog:
+		transport = NewLogTransport(cfg.Sender)
+	default:
+		return nil, fmt.Errorf("unknown email transport %q", cfg.Transport)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("unable to load AWS SDK config: %w", err)
+		return nil, err
 	}
 
-	return &Client{
-		sesClient: ses.NewFromConfig(cfg),
-		sender:    sender,
-	}, nil
+	return &checkedTransport{name: cfg.Transport, next: transport}, nil
 }
 
-// Send sends an email using AWS SES
-func (c *Client) Send(ctx context.Context, content *EmailContent) error {
+// checkedTransport rejects incomplete emails before they reach the
+// transport and traces each send
+type checkedTransport struct {
+	name string
+	next Transport
+}
+
+func (t *checkedTransport) Send(ctx context.Context, content *EmailContent) (err error) {
+	ctx, span := tracing.Start(ctx, "email.Send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("email.transport", t.name)),
+	)
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
 	if content.To == "" {
 		return fmt.Errorf("recipient email is required")
 	}
@@ -59,37 +83,5 @@ func (c *Client) Send(ctx context.Context, content *EmailContent) error {
 		return fmt.Errorf("email body (HTML or text) is required")
 	}
 
-	input := &ses.SendEmailInput{
-		Destination: &types.Destination{
-			ToAddresses: []string{content.To},
-		},
-		Message: &types.Message{
-			Subject: &types.Content{
-				Data: aws.String(content.Subject),
-			},
-			Body: &types.Body{},
-		},
-		Source: aws.String(c.sender),
-	}
-
-	// Add HTML body if provided
-	if content.HTMLBody != "" {
-		input.Message.Body.Html = &types.Content{
-			Data: aws.String(content.HTMLBody),
-		}
-	}
-
-	// Add text body if provided
-	if content.TextBody != "" {
-		input.Message.Body.Text = &types.Content{
-			Data: aws.String(content.TextBody),
-		}
-	}
-
-	_, err := c.sesClient.SendEmail(ctx, input)
-	if err != nil {
-		return fmt.Errorf("failed to send email: %w", err)
-	}
-
-	return nil
+	return t.next.Send(ctx, content)
 }
@@ -0,0 +1,107 @@
+// internal/email/message.go
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// message is an email rendered in the RFC 5322 format, as sent over SMTP
+// and saved in .eml files
+type message struct {
+	from string // envelope sender
+	to   string // envelope recipient
+	data []byte
+}
+
+// buildMessage renders content from sender. When it has both an HTML and a
+// text body, they are sent as alternatives.
+func buildMessage(sender string, content *EmailContent, now time.Time) (*message, error) {
+	from, err := mail.ParseAddress(sender)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %w", sender, err)
+	}
+	to, err := mail.ParseAddress(content.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %w", content.To, err)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate message ID: %w", err)
+	}
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	var buf bytes.Buffer
+	header := func(key, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+	header("From", from.String())
+	header("To", to.String())
+	// Q-encoding also encodes CR and LF, so the subject can't add headers
+	header("Subject", mime.QEncoding.Encode("utf-8", content.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain))
+	header("MIME-Version", "1.0")
+
+	switch {
+	case content.HTMLBody != "" && content.TextBody != "":
+		body := multipart.NewWriter(&buf)
+		header("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": body.Boundary()}))
+		buf.WriteString("\r\n")
+		// Clients show the last alternative they understand, so HTML goes last
+		if err := writePart(body, "text/plain", content.TextBody); err != nil {
+			return nil, err
+		}
+		if err := writePart(body, "text/html", content.HTMLBody); err != nil {
+			return nil, err
+		}
+		if err := body.Close(); err != nil {
+			return nil, err
+		}
+	case content.HTMLBody != "":
+		writeSinglePart(&buf, "text/html", content.HTMLBody)
+	default:
+		writeSinglePart(&buf, "text/plain", content.TextBody)
+	}
+
+	return &message{from: from.Address, to: to.Address, data: buf.Bytes()}, nil
+}
+
+func writePart(w *multipart.Writer, contentType, body string) error {
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType + "; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	return writeQuotedPrintable(part, body)
+}
+
+func writeSinglePart(buf *bytes.Buffer, contentType, body string) {
+	fmt.Fprintf(buf, "Content-Type: %s; charset=UTF-8\r\n", contentType)
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	// Writes to a bytes.Buffer can't fail
+	writeQuotedPrintable(buf, body)
+}
+
+// writeQuotedPrintable keeps lines short enough for SMTP and 8-bit text
+// intact through servers that only accept 7-bit
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
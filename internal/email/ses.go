@@ -0,0 +1,77 @@
+// internal/email/ses.go
+package email
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+	"github.com/aws/aws-sdk-go-v2/service/ses/types"
+)
+
+// SESTransport sends email through Amazon SES, with credentials from the
+// default AWS chain
+type SESTransport struct {
+	sesClient *ses.Client
+	sender    string
+}
+
+// NewSESTransport creates a transport that sends through SES in awsRegion
+func NewSESTransport(awsRegion, sender string) (*SESTransport, error) {
+	if awsRegion == "" {
+		return nil, fmt.Errorf("AWS region is required")
+	}
+	if sender == "" {
+		return nil, fmt.Errorf("sender email is required")
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(context.TODO(),
+		awsconfig.WithRegion(awsRegion),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load AWS SDK config: %w", err)
+	}
+
+	return &SESTransport{
+		sesClient: ses.NewFromConfig(cfg),
+		sender:    sender,
+	}, nil
+}
+
+// Send sends an email using AWS SES
+func (t *SESTransport) Send(ctx context.Context, content *EmailContent) error {
+	input := &ses.SendEmailInput{
+		Destination: &types.Destination{
+			ToAddresses: []string{content.To},
+		},
+		Message: &types.Message{
+			Subject: &types.Content{
+				Data: aws.String(content.Subject),
+			},
+			Body: &types.Body{},
+		},
+		Source: aws.String(t.sender),
+	}
+
+	// Add HTML body if provided
+	if content.HTMLBody != "" {
+		input.Message.Body.Html = &types.Content{
+			Data: aws.String(content.HTMLBody),
+		}
+	}
+
+	// Add text body if provided
+	if content.TextBody != "" {
+		input.Message.Body.Text = &types.Content{
+			Data: aws.String(content.TextBody),
+		}
+	}
+
+	if _, err := t.sesClient.SendEmail(ctx, input); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+
+	return nil
+}
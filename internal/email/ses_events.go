@@ -0,0 +1,97 @@
+// internal/email/ses_events.go
+package email
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SESNotification is a bounce, complaint or delivery notification from
+// SES, as carried in the Message of an SNS notification. Only the fields
+// we act on are decoded.
+type SESNotification struct {
+	// NotificationType is set by identity notifications and EventType by
+	// configuration set event publishing; both use the same payload
+	NotificationType string        `json:"notificationType"`
+	EventType        string        `json:"eventType"`
+	Bounce           *SESBounce    `json:"bounce"`
+	Complaint        *SESComplaint `json:"complaint"`
+}
+
+type SESBounce struct {
+	BounceType        string `json:"bounceType"` // Permanent, Transient or Undetermined
+	BounceSubType     string `json:"bounceSubType"`
+	BouncedRecipients []struct {
+		EmailAddress   string `json:"emailAddress"`
+		DiagnosticCode string `json:"diagnosticCode"`
+	} `json:"bouncedRecipients"`
+}
+
+type SESComplaint struct {
+	ComplaintFeedbackType string `json:"complaintFeedbackType"`
+	ComplainedRecipients  []struct {
+		EmailAddress string `json:"emailAddress"`
+	} `json:"complainedRecipients"`
+}
+
+// SESRecipient is an address SES reported a problem with
+type SESRecipient struct {
+	Email  string
+	Detail string
+}
+
+// ParseSESNotification decodes an SES notification
+func ParseSESNotification(message string) (*SESNotification, error) {
+	var n SESNotification
+	if err := json.Unmarshal([]byte(message), &n); err != nil {
+		return nil, fmt.Errorf("invalid SES notification: %w", err)
+	}
+	if n.Type() == "" {
+		return nil, fmt.Errorf("invalid SES notification: no notification type")
+	}
+	return &n, nil
+}
+
+// Type is Bounce, Complaint, Delivery, or another SES event type
+func (n *SESNotification) Type() string {
+	if n.NotificationType != "" {
+		return n.NotificationType
+	}
+	return n.EventType
+}
+
+// PermanentBounces returns the recipients of a permanent bounce, which
+// will never accept mail. Transient bounces, such as full mailboxes,
+// return none.
+func (n *SESNotification) PermanentBounces() []SESRecipient {
+	if n.Type() != "Bounce" || n.Bounce == nil || n.Bounce.BounceType != "Permanent" {
+		return nil
+	}
+
+	var recipients []SESRecipient
+	for _, r := range n.Bounce.BouncedRecipients {
+		detail := n.Bounce.BounceType + "/" + n.Bounce.BounceSubType
+		if r.DiagnosticCode != "" {
+			detail += ": " + r.DiagnosticCode
+		}
+		recipients = append(recipients, SESRecipient{Email: strings.TrimSpace(r.EmailAddress), Detail: detail})
+	}
+	return recipients
+}
+
+// Complaints returns the recipients who reported the email as spam
+func (n *SESNotification) Complaints() []SESRecipient {
+	if n.Type() != "Complaint" || n.Complaint == nil {
+		return nil
+	}
+
+	var recipients []SESRecipient
+	for _, r := range n.Complaint.ComplainedRecipients {
+		recipients = append(recipients, SESRecipient{
+			Email:  strings.TrimSpace(r.EmailAddress),
+			Detail: n.Complaint.ComplaintFeedbackType,
+		})
+	}
+	return recipients
+}
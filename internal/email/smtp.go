@@ -0,0 +1,173 @@
+// internal/email/smtp.go
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"option-manager/internal/config"
+)
+
+// SMTPTransport sends email through an SMTP server, opening a connection
+// for each message
+type SMTPTransport struct {
+	cfg    config.SMTPConfig
+	sender string
+}
+
+// NewSMTPTransport creates a transport that sends through the server in cfg
+func NewSMTPTransport(cfg config.SMTPConfig, sender string) (*SMTPTransport, error) {
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("SMTP host is required")
+	}
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		return nil, fmt.Errorf("SMTP port %d is out of range", cfg.Port)
+	}
+	switch cfg.TLS {
+	case config.SMTPStartTLS, config.SMTPImplicitTLS, config.SMTPNoTLS:
+	default:
+		return nil, fmt.Errorf("unknown SMTP TLS mode %q", cfg.TLS)
+	}
+	// Credentials and single-use links would cross the network in the clear
+	if cfg.TLS == config.SMTPNoTLS && !cfg.IsLoopback() {
+		return nil, fmt.Errorf("SMTP TLS mode none is only allowed for a server on localhost, not %q", cfg.Host)
+	}
+	if sender == "" {
+		return nil, fmt.Errorf("sender email is required")
+	}
+
+	return &SMTPTransport{cfg: cfg, sender: sender}, nil
+}
+
+// Send delivers one email. Cancelling ctx aborts the conversation with the
+// server.
+func (t *SMTPTransport) Send(ctx context.Context, content *EmailContent) error {
+	msg, err := buildMessage(t.sender, content, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(t.cfg.Host, strconv.Itoa(t.cfg.Port)))
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	// net/smtp doesn't take a context, so expire the connection instead
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	tlsConfig := &tls.Config{ServerName: t.cfg.Host}
+	if t.cfg.TLS == config.SMTPImplicitTLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, t.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	if t.cfg.TLS == config.SMTPStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("SMTP server doesn't support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+
+	if t.cfg.Username != "" {
+		auth, err := t.auth(client)
+		if err != nil {
+			return err
+		}
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(msg.from); err != nil {
+		return fmt.Errorf("SMTP server rejected sender: %w", err)
+	}
+	if err := client.Rcpt(msg.to); err != nil {
+		return fmt.Errorf("SMTP server rejected recipient: %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP server refused message: %w", err)
+	}
+	if _, err := w.Write(msg.data); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("SMTP server rejected message: %w", err)
+	}
+
+	// The message is accepted once DATA completes; a failed QUIT doesn't
+	// change that
+	client.Quit()
+	return nil
+}
+
+// auth picks PLAIN, or LOGIN for servers that only offer that
+func (t *SMTPTransport) auth(client *smtp.Client) (smtp.Auth, error) {
+	_, params := client.Extension("AUTH")
+	mechanisms := strings.Fields(strings.ToUpper(params))
+	switch {
+	case slices.Contains(mechanisms, "PLAIN"):
+		return smtp.PlainAuth("", t.cfg.Username, string(t.cfg.Password), t.cfg.Host), nil
+	case slices.Contains(mechanisms, "LOGIN"):
+		return &loginAuth{username: t.cfg.Username, password: string(t.cfg.Password), host: t.cfg.Host}, nil
+	default:
+		return nil, fmt.Errorf("SMTP server offers no supported authentication mechanism (have %q)", params)
+	}
+}
+
+// loginAuth implements the LOGIN mechanism, which net/smtp lacks. Like
+// smtp.PlainAuth, it refuses to send credentials in the clear except to
+// localhost.
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+	}
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
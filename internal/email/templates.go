@@ -0,0 +1,163 @@
+// internal/email/templates.go
+package email
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+)
+
+// DefaultLocale is used when a message has no variant in the requested
+// locale. Every message must exist in it.
+const DefaultLocale = "en"
+
+// Templates renders the emails the app sends. See the emails package for
+// the file layout.
+type Templates struct {
+	// messages maps a message name and then a locale to its templates
+	messages map[string]map[string]*messageTemplate
+}
+
+type messageTemplate struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// ParseTemplates parses every template in fsys. messages maps each message
+// name to a sample of the data it is rendered with; every variant is
+// rendered with it, so a misspelled field or missing part fails here rather
+// than when the email is sent. Files for messages not in the map are an
+// error too.
+func ParseTemplates(fsys fs.FS, messages map[string]any) (*Templates, error) {
+	locales, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read email templates: %w", err)
+	}
+
+	t := &Templates{messages: make(map[string]map[string]*messageTemplate)}
+	for _, entry := range locales {
+		if !entry.IsDir() {
+			continue
+		}
+		locale := entry.Name()
+		if err := t.parseLocale(fsys, locale, messages); err != nil {
+			return nil, err
+		}
+	}
+
+	var missing []string
+	for name := range messages {
+		if _, ok := t.messages[name][DefaultLocale]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("missing %s email templates: %s", DefaultLocale, strings.Join(missing, ", "))
+	}
+
+	return t, nil
+}
+
+func (t *Templates) parseLocale(fsys fs.FS, locale string, messages map[string]any) error {
+	files, err := fs.Glob(fsys, path.Join(locale, "*.tmpl"))
+	if err != nil {
+		return err
+	}
+
+	layout := path.Join(locale, "layout.tmpl")
+	for _, file := range files {
+		if file == layout {
+			continue
+		}
+
+		name := strings.TrimSuffix(path.Base(file), ".tmpl")
+		sample, ok := messages[name]
+		if !ok {
+			return fmt.Errorf("email template %s is for unknown message %q", file, name)
+		}
+
+		tmpl, err := parseMessage(fsys, layout, file)
+		if err != nil {
+			return err
+		}
+		if _, err := tmpl.render(sample); err != nil {
+			return fmt.Errorf("email template %s: %w", file, err)
+		}
+
+		if t.messages[name] == nil {
+			t.messages[name] = make(map[string]*messageTemplate)
+		}
+		t.messages[name][strings.ToLower(locale)] = tmpl
+	}
+	return nil
+}
+
+// parseMessage parses a message with its locale's layout twice: as HTML,
+// which escapes what it interpolates, and as plain text
+func parseMessage(fsys fs.FS, layout, file string) (*messageTemplate, error) {
+	html, err := htmltemplate.New(path.Base(file)).Option("missingkey=error").ParseFS(fsys, layout, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse email template %s: %w", file, err)
+	}
+	text, err := texttemplate.New(path.Base(file)).Option("missingkey=error").ParseFS(fsys, layout, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse email template %s: %w", file, err)
+	}
+
+	for _, part := range []string{"subject", "html", "text", "layout.html", "layout.text"} {
+		if text.Lookup(part) == nil {
+			return nil, fmt.Errorf("email template %s doesn't define %q", file, part)
+		}
+	}
+
+	return &messageTemplate{html: html, text: text}, nil
+}
+
+// Render renders the named message in locale, falling back to the
+// locale's language and then to DefaultLocale. The recipient is left for
+// the caller to fill in.
+func (t *Templates) Render(name, locale string, data any) (*EmailContent, error) {
+	variants, ok := t.messages[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown email template %q", name)
+	}
+
+	locale = strings.ToLower(locale)
+	language, _, _ := strings.Cut(locale, "-")
+	for _, candidate := range []string{locale, language, DefaultLocale} {
+		if tmpl, ok := variants[candidate]; ok {
+			return tmpl.render(data)
+		}
+	}
+	// ParseTemplates guarantees a default
+	return nil, fmt.Errorf("no %s variant of email template %q", DefaultLocale, name)
+}
+
+func (m *messageTemplate) render(data any) (*EmailContent, error) {
+	var subject, html, text bytes.Buffer
+	if err := m.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := m.html.ExecuteTemplate(&html, "layout.html", data); err != nil {
+		return nil, err
+	}
+	if err := m.text.ExecuteTemplate(&text, "layout.text", data); err != nil {
+		return nil, err
+	}
+
+	content := &EmailContent{
+		Subject:  strings.TrimSpace(subject.String()),
+		HTMLBody: strings.TrimSpace(html.String()),
+		TextBody: strings.TrimSpace(text.String()) + "\n",
+	}
+	if content.Subject == "" || strings.ContainsAny(content.Subject, "\r\n") {
+		return nil, fmt.Errorf("subject must be a single non-empty line, got %q", content.Subject)
+	}
+	return content, nil
+}
@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"option-manager/internal/logging"
+	"option-manager/internal/middleware"
+	"option-manager/internal/password"
+	"option-manager/internal/repository"
+	"option-manager/internal/service"
+	"strings"
+	"time"
+)
+
+type ChangePasswordPageData struct {
+	Error               string
+	Success             string
+	PasswordSuggestions []string
+	CSRFField           template.HTML
+}
+
+type ChangeEmailPageData struct {
+	Error        string
+	Success      string
+	CurrentEmail string
+	PendingEmail string
+	CSRFField    template.HTML
+}
+
+// EmailChangeLinkData is for the pages the links in email change emails
+// open. Action is "confirm" or "cancel".
+type EmailChangeLinkData struct {
+	Action       string
+	Token        string
+	CurrentEmail string
+	NewEmail     string
+	Error        string
+	Success      string
+	CSRFField    template.HTML
+}
+
+type AccountDataPageData struct {
+	Error     string
+	Success   string
+	DeletesOn string // when the account will be deleted, if it will be
+	CSRFField template.HTML
+}
+
+type AccountHandler struct {
+	services               *service.Services
+	changePasswordTemplate *template.Template
+	changeEmailTemplate    *template.Template
+	emailChangeTemplate    *template.Template
+	accountDataTemplate    *template.Template
+}
+
+func NewAccountHandler(services *service.Services) (*AccountHandler, error) {
+	changePasswordTmpl, err := template.ParseFiles("templates/change-password.html")
+	if err != nil {
+		return nil, err
+	}
+
+	changeEmailTmpl, err := template.ParseFiles("templates/change-email.html")
+	if err != nil {
+		return nil, err
+	}
+
+	emailChangeTmpl, err := template.ParseFiles("templates/email-change.html")
+	if err != nil {
+		return nil, err
+	}
+
+	accountDataTmpl, err := template.ParseFiles("templates/account-data.html")
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccountHandler{
+		services:               services,
+		changePasswordTemplate: changePasswordTmpl,
+		changeEmailTemplate:    changeEmailTmpl,
+		emailChangeTemplate:    emailChangeTmpl,
+		accountDataTemplate:    accountDataTmpl,
+	}, nil
+}
+
+// ChangePasswordPage lets a signed-in user change their password. On success
+// every other session is signed out and the current session ID is rotated.
+func (h *AccountHandler) ChangePasswordPage(w http.ResponseWriter, r *http.Request) {
+	data := ChangePasswordPageData{
+		CSRFField: middleware.CSRFField(r),
+	}
+
+	if r.Method == http.MethodGet {
+		if r.URL.Query().Get("status") == "changed" {
+			data.Success = "Your password has been changed and your other sessions have been signed out."
+		}
+		h.changePasswordTemplate.Execute(w, data)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		session, ok := middleware.GetSession(r.Context())
+		if !ok {
+			http.Error(w, "Session not found in context", http.StatusInternalServerError)
+			return
+		}
+
+		newPassword := r.FormValue("new_password")
+		if newPassword != r.FormValue("new_password_confirm") {
+			data.Error = "Passwords do not match"
+			h.changePasswordTemplate.Execute(w, data)
+			return
+		}
+
+		err := h.services.User.ChangePassword(r.Context(), session.UserID, r.FormValue("current_password"), newPassword)
+		if err != nil {
+			data.Error = err.Error()
+			var policyErr *password.PolicyError
+			if errors.As(err, &policyErr) {
+				data.PasswordSuggestions = policyErr.Suggestions
+			}
+			h.changePasswordTemplate.Execute(w, data)
+			return
+		}
+
+		if err := h.services.Auth.RevokeOtherSessions(r.Context(), session.UserID, session.ID); err != nil {
+			logging.FromContext(r.Context()).Error("failed to revoke other sessions", "error", err)
+		}
+
+		rotated, err := h.services.Auth.RotateSession(r.Context(), session)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed to rotate session", "error", err)
+		} else {
+			middleware.SetSessionCookie(w, r, rotated)
+		}
+
+		http.Redirect(w, r, "/account/password?status=changed", http.StatusSeeOther)
+		return
+	}
+
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+// ChangeEmailPage lets a signed-in user ask to change their email, or cancel
+// a change they asked for. The change waits for the link sent to the new
+// address.
+func (h *AccountHandler) ChangeEmailPage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var err error
+		status := "requested"
+		if r.FormValue("action") == "cancel" {
+			err = h.services.User.CancelEmailChange(r.Context(), userID)
+			status = "cancelled"
+		} else {
+			err = h.services.User.RequestEmailChange(r.Context(), userID, r.FormValue("current_password"), r.FormValue("new_email"))
+		}
+		if err != nil {
+			h.renderChangeEmail(w, r, userID, userMessage(r, err), "")
+			return
+		}
+		http.Redirect(w, r, "/account/email?status="+status, http.StatusSeeOther)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var success string
+	switch r.URL.Query().Get("status") {
+	case "requested":
+		success = "Check your new address for a link to confirm the change."
+	case "cancelled":
+		success = "Your email change has been cancelled."
+	}
+	h.renderChangeEmail(w, r, userID, "", success)
+}
+
+func (h *AccountHandler) renderChangeEmail(w http.ResponseWriter, r *http.Request, userID int, errMsg, success string) {
+	user, err := h.services.User.GetUser(r.Context(), userID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to load user", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := ChangeEmailPageData{
+		Error:        errMsg,
+		Success:      success,
+		CurrentEmail: user.Email,
+		CSRFField:    middleware.CSRFField(r),
+	}
+	if user.PendingEmail != nil {
+		data.PendingEmail = *user.PendingEmail
+	}
+	h.changeEmailTemplate.Execute(w, data)
+}
+
+// ConfirmEmailChange is where the link sent to the new address leads. It
+// works whether or not the user is signed in in this browser.
+func (h *AccountHandler) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	data := EmailChangeLinkData{
+		Action:    "confirm",
+		Token:     r.FormValue("token"),
+		CSRFField: middleware.CSRFField(r),
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		user, err := h.services.User.FindEmailChange(r.Context(), data.Token)
+		if err != nil {
+			data.Error = userMessage(r, err)
+		} else {
+			data.CurrentEmail = user.Email
+			data.NewEmail = *user.PendingEmail
+		}
+
+	case http.MethodPost:
+		user, err := h.services.User.ConfirmEmailChange(r.Context(), data.Token, r.FormValue("sign_out") != "", h.currentSession(r))
+		if err != nil {
+			data.Error = userMessage(r, err)
+		} else {
+			data.Success = "Your email is now " + user.Email + ". Use it to sign in from now on."
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.emailChangeTemplate.Execute(w, data)
+}
+
+// CancelEmailChange is where the link in the notice sent to the old address
+// leads
+func (h *AccountHandler) CancelEmailChange(w http.ResponseWriter, r *http.Request) {
+	data := EmailChangeLinkData{
+		Action:    "cancel",
+		Token:     r.FormValue("token"),
+		CSRFField: middleware.CSRFField(r),
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if data.Token == "" {
+			data.Error = "Invalid email change link"
+		}
+
+	case http.MethodPost:
+		if err := h.services.User.CancelEmailChangeByToken(r.Context(), data.Token); err != nil {
+			data.Error = userMessage(r, err)
+		} else {
+			data.Success = "The email change has been cancelled. If you didn't ask for it, change your password now."
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.emailChangeTemplate.Execute(w, data)
+}
+
+// AccountDataPage lets a signed-in user download their data, ask for their
+// account to be deleted, or keep it while the deletion is still to come
+func (h *AccountHandler) AccountDataPage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var err error
+		status := "scheduled"
+		switch r.FormValue("action") {
+		case "delete":
+			_, err = h.services.AccountData.RequestDeletion(r.Context(), userID, r.FormValue("current_password"))
+		case "cancel":
+			err = h.services.AccountData.CancelDeletion(r.Context(), userID)
+			status = "kept"
+		default:
+			http.Error(w, "Unknown action", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			h.renderAccountData(w, r, userID, userMessage(r, err), "")
+			return
+		}
+		http.Redirect(w, r, "/account/data?status="+status, http.StatusSeeOther)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var success string
+	switch r.URL.Query().Get("status") {
+	case "scheduled":
+		success = "Your account will be deleted. We've emailed you the date."
+	case "kept":
+		success = "Your account will not be deleted."
+	}
+	h.renderAccountData(w, r, userID, "", success)
+}
+
+func (h *AccountHandler) renderAccountData(w http.ResponseWriter, r *http.Request, userID int, errMsg, success string) {
+	user, err := h.services.User.GetUser(r.Context(), userID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to load user", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := AccountDataPageData{
+		Error:     errMsg,
+		Success:   success,
+		CSRFField: middleware.CSRFField(r),
+	}
+	if user.DeletionScheduledAt != nil {
+		data.DeletesOn = user.DeletionScheduledAt.UTC().Format("2 January 2006")
+	}
+	h.accountDataTemplate.Execute(w, data)
+}
+
+// ExportAccountData downloads a ZIP of everything held about the signed-in
+// user
+func (h *AccountHandler) ExportAccountData(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	// Built in memory so a failure part way through is still an error page
+	// rather than a truncated download
+	var buf bytes.Buffer
+	if err := h.services.AccountData.ExportUserData(r.Context(), userID, &buf); err != nil {
+		logging.FromContext(r.Context()).Error("failed to export account data", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("options-manager-data-%s.zip", time.Now().UTC().Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+}
+
+// currentSession returns the session the request is signed in with, if
+// any, on routes that don't require one
+func (h *AccountHandler) currentSession(r *http.Request) *repository.Session {
+	cookie, err := r.Cookie(middleware.SessionCookieName)
+	if err != nil {
+		return nil
+	}
+	session, err := h.services.Auth.GetSession(r.Context(), cookie.Value)
+	if err != nil {
+		return nil
+	}
+	return session
+}
+
+// userMessage returns the message to show for err. Only errors caused by
+// the user's input are shown as they are; anything else is logged.
+func userMessage(r *http.Request, err error) string {
+	if errors.Is(err, service.ErrInvalidInput) {
+		return strings.TrimPrefix(err.Error(), service.ErrInvalidInput.Error()+": ")
+	}
+	logging.FromContext(r.Context()).Error("request failed", "error", err)
+	return "Something went wrong. Please try again."
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"option-manager/internal/logging"
+	"option-manager/internal/middleware"
+	"option-manager/internal/repository"
+	"option-manager/internal/service"
+	"strconv"
+)
+
+type AdminUsersPageData struct {
+	Users       []*repository.User
+	Query       string
+	NextAfterID int
+	Can         map[string]bool
+	Error       string
+	Success     string
+	CSRFField   template.HTML
+}
+
+// adminErrors are the messages the user list shows for each ?error= code.
+// Only these are shown, so a crafted link can't put its own text on the page.
+var adminErrors = map[string]string{
+	"invalid-user":     "Invalid user ID.",
+	"not-found":        "User not found.",
+	"already-verified": "The user's email is already verified.",
+	"disable-self":     "You can't disable your own account.",
+	"failed":           "Something went wrong. Please try again.",
+}
+
+type AdminHandler struct {
+	services      *service.Services
+	usersTemplate *template.Template
+}
+
+func NewAdminHandler(services *service.Services) (*AdminHandler, error) {
+	usersTmpl, err := template.ParseFiles("templates/admin-users.html")
+	if err != nil {
+		return nil, err
+	}
+
+	return &AdminHandler{
+		services:      services,
+		usersTemplate: usersTmpl,
+	}, nil
+}
+
+// Index sends admins to the user list, the only admin page so far
+func (h *AdminHandler) Index(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// Users lists and searches users
+func (h *AdminHandler) Users(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data := AdminUsersPageData{}
+	switch r.URL.Query().Get("status") {
+	case "verification-sent":
+		data.Success = "Verification email queued."
+	case "disabled":
+		data.Success = "The account has been disabled and signed out everywhere."
+	case "enabled":
+		data.Success = "The account has been re-enabled."
+	case "sessions-revoked":
+		data.Success = "The user has been signed out everywhere."
+	}
+	if code := r.URL.Query().Get("error"); code != "" {
+		data.Error = adminErrors[code]
+		if data.Error == "" {
+			data.Error = adminErrors["failed"]
+		}
+	}
+
+	h.renderUsers(w, r, data)
+}
+
+// ResendVerification queues a new verification link for a user
+func (h *AdminHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	h.userAction(w, r, "verification-sent", func(actorID, userID int) error {
+		return h.services.Admin.ResendVerification(r.Context(), userID)
+	})
+}
+
+// Disable disables a user's account and signs them out
+func (h *AdminHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	h.userAction(w, r, "disabled", func(actorID, userID int) error {
+		return h.services.Admin.DisableUser(r.Context(), actorID, userID)
+	})
+}
+
+// Enable re-enables a disabled account
+func (h *AdminHandler) Enable(w http.ResponseWriter, r *http.Request) {
+	h.userAction(w, r, "enabled", func(actorID, userID int) error {
+		return h.services.Admin.EnableUser(r.Context(), userID)
+	})
+}
+
+// RevokeSessions force-logs-out every session of a user
+func (h *AdminHandler) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	h.userAction(w, r, "sessions-revoked", func(actorID, userID int) error {
+		return h.services.Admin.RevokeUserSessions(r.Context(), userID)
+	})
+}
+
+// userAction runs a POSTed action against the user in the user_id field and
+// redirects back to the user list with the outcome
+func (h *AdminHandler) userAction(w http.ResponseWriter, r *http.Request, status string, action func(actorID, userID int) error) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	actorID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	params := url.Values{}
+	if q := r.FormValue("q"); q != "" {
+		params.Set("q", q)
+	}
+
+	userID, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil || userID <= 0 {
+		params.Set("error", "invalid-user")
+	} else if err := action(actorID, userID); err != nil {
+		params.Set("error", adminErrorCode(r, err))
+	} else {
+		params.Set("status", status)
+	}
+
+	http.Redirect(w, r, "/admin/users?"+params.Encode(), http.StatusSeeOther)
+}
+
+// adminErrorCode returns the ?error= code for an action's error, logging
+// errors that aren't the admin's to fix
+func adminErrorCode(r *http.Request, err error) string {
+	switch {
+	case errors.Is(err, service.ErrUserNotFound):
+		return "not-found"
+	case errors.Is(err, service.ErrEmailAlreadyVerified):
+		return "already-verified"
+	case errors.Is(err, service.ErrDisableSelf):
+		return "disable-self"
+	}
+	logging.FromContext(r.Context()).Error("admin action failed", "error", err)
+	return "failed"
+}
+
+func (h *AdminHandler) renderUsers(w http.ResponseWriter, r *http.Request, data AdminUsersPageData) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	permissions, err := h.services.Auth.Permissions(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	data.Can = make(map[string]bool, len(permissions))
+	for _, p := range permissions {
+		data.Can[p] = true
+	}
+
+	data.Query = r.URL.Query().Get("q")
+	afterID, _ := strconv.Atoi(r.URL.Query().Get("after"))
+
+	filter := repository.UserFilter{
+		Query:   data.Query,
+		AfterID: afterID,
+	}
+	data.Users, err = h.services.Admin.ListUsers(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if n := len(data.Users); n > 0 && n == service.DefaultUserPageSize {
+		data.NextAfterID = data.Users[n-1].ID
+	}
+	data.CSRFField = middleware.CSRFField(r)
+
+	h.usersTemplate.Execute(w, data)
+}
@@ -0,0 +1,421 @@
+package handlers
+
+import (
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"option-manager/internal/logging"
+	"option-manager/internal/middleware"
+	"option-manager/internal/repository"
+	"option-manager/internal/service"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openAPISpec documents the routes below. NewAPIHandler refuses to start if
+// the two disagree.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// maxAPIBodyBytes caps the size of a JSON request body
+const maxAPIBodyBytes = 1 << 20
+
+// apiResponse is the envelope for successful responses. NextCursor is set on
+// list responses when there are more results.
+type apiResponse struct {
+	Data       any    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// apiUser is the JSON representation of a user
+type apiUser struct {
+	ID            int        `json:"id"`
+	Email         string     `json:"email"`
+	FirstName     string     `json:"first_name"`
+	LastName      string     `json:"last_name"`
+	EmailVerified bool       `json:"email_verified"`
+	Role          string     `json:"role"`
+	DisabledAt    *time.Time `json:"disabled_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+func newAPIUser(u *repository.User) apiUser {
+	return apiUser{
+		ID:            u.ID,
+		Email:         u.Email,
+		FirstName:     u.FirstName,
+		LastName:      u.LastName,
+		EmailVerified: u.EmailVerified,
+		Role:          u.Role,
+		DisabledAt:    u.DisabledAt,
+		CreatedAt:     u.CreatedAt,
+		UpdatedAt:     u.UpdatedAt,
+	}
+}
+
+// apiRoute is one operation of the API. Token requests must carry scope.
+type apiRoute struct {
+	method  string
+	path    string
+	scope   string
+	handler http.HandlerFunc
+}
+
+// APIHandler serves the versioned JSON API under /api/v1. It expects to sit
+// behind middleware.RequireToken.
+type APIHandler struct {
+	services *service.Services
+	mux      *http.ServeMux
+}
+
+func NewAPIHandler(services *service.Services) (*APIHandler, error) {
+	h := &APIHandler{
+		services: services,
+		mux:      http.NewServeMux(),
+	}
+
+	routes := h.routes()
+	if err := checkOpenAPISpec(openAPISpec, routes); err != nil {
+		return nil, fmt.Errorf("openapi.json is out of date: %w", err)
+	}
+
+	allowed := make(map[string][]string)
+	var paths []string
+	for _, route := range routes {
+		h.mux.Handle(route.method+" "+route.path, middleware.Chain(
+			route.handler,
+			middleware.RequireScope(route.scope),
+		))
+		if allowed[route.path] == nil {
+			paths = append(paths, route.path)
+		}
+		allowed[route.path] = append(allowed[route.path], route.method)
+		if route.method == http.MethodGet {
+			// GET patterns also serve HEAD
+			allowed[route.path] = append(allowed[route.path], http.MethodHead)
+		}
+	}
+	// Other methods on a known path get a 405 rather than the 404 below
+	for _, path := range paths {
+		allow := strings.Join(allowed[path], ", ")
+		h.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Allow", allow)
+			middleware.WriteJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		})
+	}
+	h.mux.HandleFunc("/api/v1/", func(w http.ResponseWriter, r *http.Request) {
+		middleware.WriteJSONError(w, http.StatusNotFound, "not_found", "no such endpoint")
+	})
+
+	return h, nil
+}
+
+func (h *APIHandler) routes() []apiRoute {
+	return []apiRoute{
+		{http.MethodGet, "/api/v1/me", service.ScopeRead, h.GetMe},
+		{http.MethodPatch, "/api/v1/me", service.ScopeWrite, h.UpdateMe},
+		{http.MethodGet, "/api/v1/users", service.ScopeRead, h.ListUsers},
+		{http.MethodGet, "/api/v1/users/{id}", service.ScopeRead, h.GetUser},
+	}
+}
+
+func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Spec serves the OpenAPI document
+func (h *APIHandler) Spec(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		middleware.WriteJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// GetMe returns the authenticated user
+func (h *APIHandler) GetMe(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		middleware.WriteJSONError(w, http.StatusUnauthorized, "unauthorized", "authentication required")
+		return
+	}
+
+	user, err := h.services.User.GetUser(r.Context(), userID)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+
+	writeUser(w, r, http.StatusOK, user)
+}
+
+// UpdateMe changes the authenticated user's name. The request must carry an
+// If-Match header with the user's current ETag.
+func (h *APIHandler) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		middleware.WriteJSONError(w, http.StatusUnauthorized, "unauthorized", "authentication required")
+		return
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		middleware.WriteJSONError(w, http.StatusPreconditionRequired, "precondition_required", "an If-Match header is required")
+		return
+	}
+
+	var body struct {
+		FirstName *string `json:"first_name"`
+		LastName  *string `json:"last_name"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+
+	user, err := h.services.User.GetUser(r.Context(), userID)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	if !etagMatches(ifMatch, userETag(user)) {
+		writeServiceError(w, r, service.ErrUserModified)
+		return
+	}
+
+	input := service.ProfileInput{FirstName: user.FirstName, LastName: user.LastName}
+	if body.FirstName != nil {
+		input.FirstName = *body.FirstName
+	}
+	if body.LastName != nil {
+		input.LastName = *body.LastName
+	}
+
+	user, err = h.services.User.UpdateProfile(r.Context(), userID, input, user.UpdatedAt)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+
+	writeUser(w, r, http.StatusOK, user)
+}
+
+// ListUsers returns a page of users. It accepts q and role filters, and
+// cursor and limit for paging.
+func (h *APIHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	if !h.requirePermission(w, r, service.PermissionUsersRead) {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := repository.UserFilter{
+		Query: strings.TrimSpace(query.Get("q")),
+		Role:  query.Get("role"),
+		Limit: service.DefaultUserPageSize,
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > service.MaxUserPageSize {
+			middleware.WriteJSONError(w, http.StatusBadRequest, "invalid_parameter",
+				fmt.Sprintf("limit must be between 1 and %d", service.MaxUserPageSize))
+			return
+		}
+		filter.Limit = limit
+	}
+
+	if v := query.Get("cursor"); v != "" {
+		afterID, err := decodeCursor(v)
+		if err != nil {
+			middleware.WriteJSONError(w, http.StatusBadRequest, "invalid_parameter", "cursor is invalid")
+			return
+		}
+		filter.AfterID = afterID
+	}
+
+	users, err := h.services.Admin.ListUsers(r.Context(), filter)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+
+	data := make([]apiUser, 0, len(users))
+	for _, user := range users {
+		data = append(data, newAPIUser(user))
+	}
+
+	resp := apiResponse{Data: data}
+	if n := len(users); n > 0 && n == filter.Limit {
+		resp.NextCursor = encodeCursor(users[n-1].ID)
+	}
+
+	middleware.WriteJSON(w, http.StatusOK, resp)
+}
+
+// GetUser returns a single user
+func (h *APIHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	if !h.requirePermission(w, r, service.PermissionUsersRead) {
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		middleware.WriteJSONError(w, http.StatusNotFound, "not_found", service.ErrUserNotFound.Error())
+		return
+	}
+
+	user, err := h.services.User.GetUser(r.Context(), id)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+
+	writeUser(w, r, http.StatusOK, user)
+}
+
+// requirePermission writes an error and returns false unless the
+// authenticated user's role grants permission
+func (h *APIHandler) requirePermission(w http.ResponseWriter, r *http.Request, permission string) bool {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		middleware.WriteJSONError(w, http.StatusUnauthorized, "unauthorized", "authentication required")
+		return false
+	}
+
+	allowed, err := h.services.Auth.HasPermission(r.Context(), userID, permission)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return false
+	}
+	if !allowed {
+		middleware.WriteJSONError(w, http.StatusForbidden, "forbidden", "you don't have permission to do that")
+		return false
+	}
+	return true
+}
+
+// writeUser writes user along with its ETag, or 304 if the client's copy
+// is current
+func writeUser(w http.ResponseWriter, r *http.Request, status int, user *repository.User) {
+	etag := userETag(user)
+	w.Header().Set("ETag", etag)
+	if r.Method == http.MethodGet && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	middleware.WriteJSON(w, status, apiResponse{Data: newAPIUser(user)})
+}
+
+// writeServiceError maps a service error onto a status and error envelope
+func writeServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, service.ErrUserNotFound):
+		middleware.WriteJSONError(w, http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, service.ErrUserModified):
+		middleware.WriteJSONError(w, http.StatusPreconditionFailed, "precondition_failed", err.Error())
+	case errors.Is(err, service.ErrInvalidInput):
+		middleware.WriteJSONError(w, http.StatusUnprocessableEntity, "invalid_input", err.Error())
+	default:
+		logging.FromContext(r.Context()).Error("API request failed", "error", err)
+		middleware.WriteJSONError(w, http.StatusInternalServerError, "internal_error", "internal server error")
+	}
+}
+
+// decodeJSON reads the request body into v, writing an error and returning
+// false if it isn't valid JSON for v
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		middleware.WriteJSONError(w, http.StatusBadRequest, "invalid_body", "request body is not valid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// userETag identifies a version of a user. It changes whenever updated_at does.
+func userETag(user *repository.User) string {
+	return fmt.Sprintf(`"u%d-%x"`, user.ID, user.UpdatedAt.UnixMicro())
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header value
+// matches etag. Weak validators never match.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Cursors are opaque to clients; today they wrap the last ID of a page
+func encodeCursor(afterID int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(afterID)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.Atoi(string(raw))
+	if err != nil || id < 0 {
+		return 0, errors.New("invalid cursor")
+	}
+	return id, nil
+}
+
+// checkOpenAPISpec makes sure the spec documents exactly the given routes,
+// with the same bearer scope on each operation
+func checkOpenAPISpec(spec []byte, routes []apiRoute) error {
+	var doc struct {
+		Paths map[string]map[string]struct {
+			Security []map[string][]string `json:"security"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return err
+	}
+
+	documented := make(map[string][]string)
+	for path, ops := range doc.Paths {
+		for method, op := range ops {
+			var scopes []string
+			for _, requirement := range op.Security {
+				scopes = append(scopes, requirement["bearerAuth"]...)
+			}
+			documented[strings.ToUpper(method)+" "+path] = scopes
+		}
+	}
+
+	var problems []string
+	for _, route := range routes {
+		key := route.method + " " + route.path
+		scopes, ok := documented[key]
+		if !ok {
+			problems = append(problems, key+" is not documented")
+			continue
+		}
+		delete(documented, key)
+		if len(scopes) != 1 || scopes[0] != route.scope {
+			problems = append(problems, fmt.Sprintf("%s should require scope %q, spec has %v", key, route.scope, scopes))
+		}
+	}
+	for key := range documented {
+		problems = append(problems, key+" is documented but not served")
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
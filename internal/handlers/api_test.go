@@ -0,0 +1,45 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"option-manager/internal/handlers"
+	"option-manager/internal/repository/memory"
+	"option-manager/internal/service"
+	"testing"
+)
+
+func TestAPIWrongMethod(t *testing.T) {
+	services, err := service.NewServices(memory.NewRepository(), discardTransport{}, service.Options{BaseURL: "https://example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := handlers.NewAPIHandler(services)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method, path string
+		status       int
+		allow        string
+	}{
+		{http.MethodDelete, "/api/v1/me", http.StatusMethodNotAllowed, "GET, HEAD, PATCH"},
+		{http.MethodPost, "/api/v1/users", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodPut, "/api/v1/users/7", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodGet, "/api/v1/nothing", http.StatusNotFound, ""},
+		{http.MethodPost, "/api/v1/nothing", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+		})
+	}
+}
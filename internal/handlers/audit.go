@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"option-manager/internal/middleware"
+	"option-manager/internal/repository"
+	"option-manager/internal/service"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditEventLabels describes event types in plain words. Types missing from
+// the map are shown as-is.
+var auditEventLabels = map[string]string{
+	service.AuditLoginSucceeded:       "Signed in",
+	service.AuditLoginFailed:          "Failed sign-in attempt",
+	service.AuditLogout:               "Signed out",
+	service.AuditSessionRevoked:       "Session signed out",
+	service.AuditOtherSessionsRevoked: "Other sessions signed out",
+	service.AuditAllSessionsRevoked:   "All sessions signed out",
+	service.AuditUserRegistered:       "Account created",
+	service.AuditEmailVerified:        "Email verified",
+	service.AuditVerificationResent:   "Verification email resent",
+	service.AuditPasswordChanged:      "Password changed",
+	service.AuditProfileUpdated:       "Profile updated",
+	service.AuditEmailChangeRequested: "Email change requested",
+	service.AuditEmailChanged:         "Email changed",
+	service.AuditEmailChangeCancelled: "Email change cancelled",
+	service.AuditDataExported:         "Account data downloaded",
+	service.AuditDeletionRequested:    "Account deletion requested",
+	service.AuditDeletionCancelled:    "Account deletion cancelled",
+	service.AuditUserDeleted:          "Account deleted",
+	service.AuditUserDisabled:         "Account disabled",
+	service.AuditUserEnabled:          "Account re-enabled",
+	service.AuditRoleChanged:          "Role changed",
+	service.AuditAPITokenCreated:      "API token created",
+	service.AuditAPITokenRevoked:      "API token revoked",
+}
+
+// AuditEventView is an audit event prepared for display
+type AuditEventView struct {
+	ID            int64
+	Type          string
+	Label         string
+	ActorUserID   *int
+	SubjectUserID *int
+	IPAddress     string
+	Device        string
+	RequestID     string
+	Before        string
+	After         string
+	Metadata      []AuditMetadataItem
+	CreatedAt     time.Time
+}
+
+type AuditMetadataItem struct {
+	Key   string
+	Value string
+}
+
+type SecurityHistoryPageData struct {
+	Events       []AuditEventView
+	NextBeforeID int64
+}
+
+type AdminAuditPageData struct {
+	Events    []AuditEventView
+	UserID    string
+	Type      string
+	RequestID string
+	From      string
+	To        string
+	NextURL   string
+	Error     string
+}
+
+type AuditHandler struct {
+	services         *service.Services
+	securityTemplate *template.Template
+	adminTemplate    *template.Template
+}
+
+func NewAuditHandler(services *service.Services) (*AuditHandler, error) {
+	securityTmpl, err := template.ParseFiles("templates/security-history.html")
+	if err != nil {
+		return nil, err
+	}
+
+	adminTmpl, err := template.ParseFiles("templates/admin-audit.html")
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuditHandler{
+		services:         services,
+		securityTemplate: securityTmpl,
+		adminTemplate:    adminTmpl,
+	}, nil
+}
+
+// SecurityHistory shows the signed-in user the security events on their
+// account
+func (h *AuditHandler) SecurityHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	beforeID, _ := strconv.ParseInt(r.URL.Query().Get("before"), 10, 64)
+	events, err := h.services.Audit.ListForUser(r.Context(), userID, beforeID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := SecurityHistoryPageData{Events: newAuditEventViews(events)}
+	for i, event := range events {
+		// The IP address and device belong to whoever did it, which may be
+		// staff or someone trying to sign in; only show the user their own
+		if event.ActorUserID == nil || *event.ActorUserID != userID {
+			data.Events[i].IPAddress = ""
+			data.Events[i].Device = ""
+		}
+	}
+	if n := len(events); n > 0 && n == service.DefaultAuditPageSize {
+		data.NextBeforeID = events[n-1].ID
+	}
+
+	h.securityTemplate.Execute(w, data)
+}
+
+// AdminSearch lets admins search the whole audit log by user, event type,
+// request ID and date range
+func (h *AuditHandler) AdminSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	data := AdminAuditPageData{
+		UserID:    strings.TrimSpace(query.Get("user_id")),
+		Type:      strings.TrimSpace(query.Get("type")),
+		RequestID: strings.TrimSpace(query.Get("request_id")),
+		From:      query.Get("from"),
+		To:        query.Get("to"),
+	}
+
+	filter := repository.AuditFilter{
+		Type:      data.Type,
+		RequestID: data.RequestID,
+	}
+	filter.BeforeID, _ = strconv.ParseInt(query.Get("before"), 10, 64)
+
+	if data.UserID != "" {
+		userID, err := strconv.Atoi(data.UserID)
+		if err != nil || userID <= 0 {
+			data.Error = "User ID must be a number"
+			h.adminTemplate.Execute(w, data)
+			return
+		}
+		filter.UserID = userID
+	}
+
+	// Dates are whole days in UTC; the "to" day is included
+	if data.From != "" {
+		from, err := time.Parse("2006-01-02", data.From)
+		if err != nil {
+			data.Error = "Invalid from date"
+			h.adminTemplate.Execute(w, data)
+			return
+		}
+		filter.Since = &from
+	}
+	if data.To != "" {
+		to, err := time.Parse("2006-01-02", data.To)
+		if err != nil {
+			data.Error = "Invalid to date"
+			h.adminTemplate.Execute(w, data)
+			return
+		}
+		until := to.AddDate(0, 0, 1)
+		filter.Until = &until
+	}
+
+	events, err := h.services.Audit.Search(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	data.Events = newAuditEventViews(events)
+
+	if n := len(events); n > 0 && n == service.DefaultAuditPageSize {
+		next := url.Values{}
+		for _, key := range []string{"user_id", "type", "request_id", "from", "to"} {
+			if v := query.Get(key); v != "" {
+				next.Set(key, v)
+			}
+		}
+		next.Set("before", strconv.FormatInt(events[n-1].ID, 10))
+		data.NextURL = "/admin/audit?" + next.Encode()
+	}
+
+	h.adminTemplate.Execute(w, data)
+}
+
+func newAuditEventViews(events []*repository.AuditEvent) []AuditEventView {
+	views := make([]AuditEventView, 0, len(events))
+	for _, event := range events {
+		label, ok := auditEventLabels[event.Type]
+		if !ok {
+			label = event.Type
+		}
+
+		metadata := make([]AuditMetadataItem, 0, len(event.Metadata))
+		for key, value := range event.Metadata {
+			metadata = append(metadata, AuditMetadataItem{Key: key, Value: value})
+		}
+		sort.Slice(metadata, func(i, j int) bool { return metadata[i].Key < metadata[j].Key })
+
+		views = append(views, AuditEventView{
+			ID:            event.ID,
+			Type:          event.Type,
+			Label:         label,
+			ActorUserID:   event.ActorUserID,
+			SubjectUserID: event.SubjectUserID,
+			IPAddress:     event.IPAddress,
+			Device:        describeDevice(event.UserAgent),
+			RequestID:     event.RequestID,
+			Before:        indentJSON(event.Before),
+			After:         indentJSON(event.After),
+			Metadata:      metadata,
+			CreatedAt:     event.CreatedAt,
+		})
+	}
+	return views
+}
+
+func indentJSON(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, raw, "", "  "); err != nil {
+		return string(raw)
+	}
+	return buf.String()
+}
@@ -3,34 +3,46 @@ package handlers
 import (
 	"html/template"
 	"net/http"
+	"option-manager/internal/logging"
+	"option-manager/internal/middleware"
 	"option-manager/internal/service"
-	"time"
 )
 
 type LoginPageData struct {
-	Error string
+	Error            string
+	CSRFField        template.HTML
+	RegistrationOpen bool
 }
 
 type AuthHandler struct {
-	services *service.Services
-	template *template.Template
+	services         *service.Services
+	template         *template.Template
+	registrationOpen bool
 }
 
-func NewAuthHandler(services *service.Services) (*AuthHandler, error) {
+// NewAuthHandler creates the login handler. registrationOpen controls
+// whether the login page offers to create an account.
+func NewAuthHandler(services *service.Services, registrationOpen bool) (*AuthHandler, error) {
 	tmpl, err := template.ParseFiles("templates/login.html")
 	if err != nil {
 		return nil, err
 	}
 
 	return &AuthHandler{
-		services: services,
-		template: tmpl,
+		services:         services,
+		template:         tmpl,
+		registrationOpen: registrationOpen,
 	}, nil
 }
 
 func (h *AuthHandler) LoginPage(w http.ResponseWriter, r *http.Request) {
+	data := LoginPageData{
+		CSRFField:        middleware.CSRFField(r),
+		RegistrationOpen: h.registrationOpen,
+	}
+
 	if r.Method == http.MethodGet {
-		h.template.Execute(w, LoginPageData{})
+		h.template.Execute(w, data)
 		return
 	}
 
@@ -41,32 +53,30 @@ func (h *AuthHandler) LoginPage(w http.ResponseWriter, r *http.Request) {
 
 		authResp, err := h.services.Auth.Authenticate(r.Context(), email, password)
 		if err != nil {
-			h.template.Execute(w, LoginPageData{
-				Error: err.Error(),
-			})
+			data.Error = err.Error()
+			h.template.Execute(w, data)
 			return
 		}
 
+		// Never carry a pre-existing session across a login, so a planted
+		// session ID can't be upgraded into an authenticated one
+		if cookie, err := r.Cookie(middleware.SessionCookieName); err == nil {
+			h.services.Auth.DeleteSession(r.Context(), cookie.Value)
+		}
+
 		// Create session
-		session, err := h.services.Auth.CreateSession(r.Context(), authResp.User.ID, rememberMe)
+		session, err := h.services.Auth.CreateSession(r.Context(), authResp.User.ID, rememberMe, service.ClientInfo{
+			IPAddress: middleware.ClientIP(r),
+			UserAgent: r.UserAgent(),
+		})
 		if err != nil {
-			h.template.Execute(w, LoginPageData{
-				Error: "Error creating session. Please try again.",
-			})
+			data.Error = "Error creating session. Please try again."
+			h.template.Execute(w, data)
 			return
 		}
 
 		// Set session cookie
-		cookie := &http.Cookie{
-			Name:     "session_id",
-			Value:    session.ID,
-			Path:     "/",
-			HttpOnly: true,
-			Secure:   r.TLS != nil,
-			Expires:  session.ExpiresAt,
-			SameSite: http.SameSiteLaxMode,
-		}
-		http.SetCookie(w, cookie)
+		middleware.SetSessionCookie(w, r, session)
 
 		// Redirect to dashboard
 		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
@@ -77,18 +87,13 @@ func (h *AuthHandler) LoginPage(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
-	if cookie, err := r.Cookie("session_id"); err == nil {
-		h.services.Auth.DeleteSession(r.Context(), cookie.Value)
+	if cookie, err := r.Cookie(middleware.SessionCookieName); err == nil {
+		if err := h.services.Auth.Logout(r.Context(), cookie.Value); err != nil {
+			logging.FromContext(r.Context()).Error("failed to log out session", "error", err)
+		}
 
 		// Clear the cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "session_id",
-			Value:    "",
-			Path:     "/",
-			HttpOnly: true,
-			Expires:  time.Now().Add(-24 * time.Hour),
-			MaxAge:   -1,
-		})
+		middleware.ClearSessionCookie(w)
 	}
 
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
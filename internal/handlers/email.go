@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"option-manager/internal/repository"
+	"option-manager/internal/service"
+	"strconv"
+	"strings"
+)
+
+// outboxStatuses are the statuses the admin outbox can be filtered by
+var outboxStatuses = []string{
+	repository.OutboxPending,
+	repository.OutboxSent,
+	repository.OutboxDead,
+}
+
+type AdminEmailPageData struct {
+	Messages  []*repository.OutboxMessage
+	Statuses  []string
+	Status    string
+	Recipient string
+	NextURL   string
+}
+
+type EmailHandler struct {
+	services      *service.Services
+	adminTemplate *template.Template
+}
+
+func NewEmailHandler(services *service.Services) (*EmailHandler, error) {
+	adminTmpl, err := template.ParseFiles("templates/admin-email.html")
+	if err != nil {
+		return nil, err
+	}
+
+	return &EmailHandler{
+		services:      services,
+		adminTemplate: adminTmpl,
+	}, nil
+}
+
+// AdminOutbox shows queued and sent emails with their delivery status,
+// filtered by status and recipient
+func (h *EmailHandler) AdminOutbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	data := AdminEmailPageData{
+		Statuses:  outboxStatuses,
+		Status:    query.Get("status"),
+		Recipient: strings.TrimSpace(query.Get("recipient")),
+	}
+
+	filter := repository.OutboxFilter{
+		Status:    data.Status,
+		Recipient: data.Recipient,
+	}
+	filter.BeforeID, _ = strconv.ParseInt(query.Get("before"), 10, 64)
+
+	messages, err := h.services.Email.ListOutbox(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	data.Messages = messages
+
+	if n := len(messages); n > 0 && n == service.DefaultOutboxPageSize {
+		next := url.Values{}
+		for _, key := range []string{"status", "recipient"} {
+			if v := query.Get(key); v != "" {
+				next.Set(key, v)
+			}
+		}
+		next.Set("before", strconv.FormatInt(messages[n-1].ID, 10))
+		data.NextURL = "/admin/email?" + next.Encode()
+	}
+
+	h.adminTemplate.Execute(w, data)
+}
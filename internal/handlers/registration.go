@@ -1,13 +1,18 @@
 package handlers
 
 import (
+	"errors"
 	"html/template"
 	"net/http"
+	"option-manager/internal/middleware"
+	"option-manager/internal/password"
 	"option-manager/internal/service"
 )
 
 type RegistrationPageData struct {
-	Error string
+	Error               string
+	PasswordSuggestions []string
+	CSRFField           template.HTML
 }
 
 type RegistrationHandler struct {
@@ -28,63 +33,50 @@ func NewRegistrationHandler(services *service.Services) (*RegistrationHandler, e
 }
 
 func (h *RegistrationHandler) RegisterPage(w http.ResponseWriter, r *http.Request) {
+	data := RegistrationPageData{
+		CSRFField: middleware.CSRFField(r),
+	}
+
 	if r.Method == http.MethodGet {
-		h.template.Execute(w, RegistrationPageData{})
+		h.template.Execute(w, data)
 		return
 	}
 
 	if r.Method == http.MethodPost {
 		// Verify passwords match
-		password := r.FormValue("password")
+		newPassword := r.FormValue("password")
 		passwordConfirm := r.FormValue("password_confirm")
-		if password != passwordConfirm {
-			h.template.Execute(w, RegistrationPageData{
-				Error: "Passwords do not match",
-			})
+		if newPassword != passwordConfirm {
+			data.Error = "Passwords do not match"
+			h.template.Execute(w, data)
 			return
 		}
 
 		input := service.RegistrationInput{
 			Email:     r.FormValue("email"),
-			Password:  password,
+			Password:  newPassword,
 			FirstName: r.FormValue("first_name"),
 			LastName:  r.FormValue("last_name"),
 		}
 
 		// Validate input
 		if err := h.services.User.ValidateRegistration(input); err != nil {
-			h.template.Execute(w, RegistrationPageData{
-				Error: err.Error(),
-			})
+			data.Error = err.Error()
+			var policyErr *password.PolicyError
+			if errors.As(err, &policyErr) {
+				data.PasswordSuggestions = policyErr.Suggestions
+			}
+			h.template.Execute(w, data)
 			return
 		}
 
-		// Register user
-		user, err := h.services.User.RegisterUser(r.Context(), input)
-		if err != nil {
-			h.template.Execute(w, RegistrationPageData{
-				Error: err.Error(),
-			})
+		// Register user; the verification email is queued with the account
+		if _, err := h.services.User.RegisterUser(r.Context(), input); err != nil {
+			data.Error = err.Error()
+			h.template.Execute(w, data)
 			return
 		}
 
-		// Generate verification token
-		token, err := h.services.User.GenerateVerificationToken(r.Context(), user.ID)
-		if err != nil {
-			h.template.Execute(w, RegistrationPageData{
-				Error: "Failed to generate verification token. Please try again.",
-			})
-			return
-		}
-
-		// Send verification email
-		err = h.services.Email.SendVerificationEmail(user.Email, user.FirstName, token)
-		if err != nil {
-			h.template.Execute(w, RegistrationPageData{
-				Error: "Failed to send verification email. Please try again.",
-			})
-		}
-
 		// Redirect to verification pending page
 		http.Redirect(w, r, "/verification-pending", http.StatusSeeOther)
 
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"option-manager/internal/email"
+	"option-manager/internal/logging"
+	"option-manager/internal/service"
+	"option-manager/internal/sns"
+)
+
+// maxSNSMessageSize is larger than any message SNS sends, which it caps at
+// 256 KB plus the envelope
+const maxSNSMessageSize = 512 << 10
+
+// SESHandler receives SES bounce and complaint notifications through an SNS
+// HTTP(S) subscription
+type SESHandler struct {
+	services *service.Services
+	verifier *sns.Verifier
+	topicARN string
+}
+
+// NewSESHandler accepts messages from the SNS topic topicARN only
+func NewSESHandler(services *service.Services, verifier *sns.Verifier, topicARN string) (*SESHandler, error) {
+	if verifier == nil {
+		return nil, errors.New("SNS verifier is required")
+	}
+	if topicARN == "" {
+		return nil, errors.New("SNS topic ARN is required")
+	}
+	return &SESHandler{
+		services: services,
+		verifier: verifier,
+		topicARN: topicARN,
+	}, nil
+}
+
+// Notify handles one SNS message. Anything but a 2xx response makes SNS
+// retry, so errors that a retry can't fix are answered with 4xx.
+func (h *SESHandler) Notify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	log := logging.FromContext(ctx)
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSNSMessageSize))
+	if err != nil {
+		http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	msg, err := sns.Parse(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if msg.TopicARN != h.topicARN {
+		log.Warn("rejected SNS message from an unexpected topic", "topic_arn", msg.TopicARN)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if err := h.verifier.Verify(ctx, msg); errors.Is(err, sns.ErrInvalidSignature) {
+		log.Warn("rejected SNS message", "error", err)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	} else if err != nil {
+		log.Error("failed to verify SNS message", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	switch msg.Type {
+	case sns.TypeSubscriptionConfirmation:
+		if err := h.verifier.ConfirmSubscription(ctx, msg); err != nil {
+			log.Error("failed to confirm SNS subscription", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		log.Info("confirmed SNS subscription", "topic_arn", msg.TopicARN)
+
+	case sns.TypeUnsubscribeConfirmation:
+		log.Warn("SNS subscription was removed; bounces will no longer be recorded", "topic_arn", msg.TopicARN)
+
+	case sns.TypeNotification:
+		if err := h.handleNotification(r, msg); err != nil {
+			log.Error("failed to handle SES notification", "message_id", msg.MessageID, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *SESHandler) handleNotification(r *http.Request, msg *sns.Message) error {
+	notification, err := email.ParseSESNotification(msg.Message)
+	if err != nil {
+		// Retrying won't make it parse
+		logging.FromContext(r.Context()).Warn("ignored SNS notification", "message_id", msg.MessageID, "error", err)
+		return nil
+	}
+
+	for _, recipient := range notification.PermanentBounces() {
+		if err := h.services.Email.SuppressBounce(r.Context(), recipient.Email, recipient.Detail); err != nil && !errors.Is(err, service.ErrInvalidInput) {
+			return fmt.Errorf("error recording bounce: %w", err)
+		}
+	}
+	for _, recipient := range notification.Complaints() {
+		if err := h.services.Email.SuppressComplaint(r.Context(), recipient.Email, recipient.Detail); err != nil && !errors.Is(err, service.ErrInvalidInput) {
+			return fmt.Errorf("error recording complaint: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"option-manager/internal/email"
+	"option-manager/internal/handlers"
+	"option-manager/internal/repository"
+	"option-manager/internal/repository/memory"
+	"option-manager/internal/service"
+	"option-manager/internal/sns"
+	"option-manager/internal/sns/snstest"
+	"testing"
+)
+
+type discardTransport struct{}
+
+func (discardTransport) Send(context.Context, *email.EmailContent) error { return nil }
+
+type sesFixture struct {
+	handler *handlers.SESHandler
+	signer  *snstest.Signer
+	repo    *repository.Repository
+}
+
+func newSESFixture(t *testing.T) *sesFixture {
+	t.Helper()
+	repo := memory.NewRepository()
+	services, err := service.NewServices(repo, discardTransport{}, service.Options{BaseURL: "https://example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := snstest.NewSigner(t)
+	handler, err := handlers.NewSESHandler(services, sns.NewVerifier(signer.Client()), snstest.TopicARN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &sesFixture{handler: handler, signer: signer, repo: repo}
+}
+
+// post signs and delivers msg to the handler, returning the status code
+func (f *sesFixture) post(t *testing.T, msg *sns.Message) int {
+	t.Helper()
+	f.signer.Sign(t, msg, "2")
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/ses", bytes.NewReader(snstest.Body(t, msg)))
+	rec := httptest.NewRecorder()
+	f.handler.Notify(rec, req)
+	return rec.Code
+}
+
+func (f *sesFixture) suppression(t *testing.T, address string) *repository.EmailSuppression {
+	t.Helper()
+	suppression, err := f.repo.Suppression.Find(context.Background(), address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return suppression
+}
+
+func TestSESNotify(t *testing.T) {
+	t.Run("PermanentBounce", func(t *testing.T) {
+		f := newSESFixture(t)
+		if code := f.post(t, snstest.Payload(t, "bounce-permanent.json")); code != http.StatusNoContent {
+			t.Fatalf("status = %d, want %d", code, http.StatusNoContent)
+		}
+		suppression := f.suppression(t, "jane.doe@mail.example.org")
+		if suppression == nil || suppression.Reason != repository.SuppressionBounce {
+			t.Fatalf("suppression = %+v, want a bounce", suppression)
+		}
+	})
+
+	t.Run("TransientBounce", func(t *testing.T) {
+		f := newSESFixture(t)
+		if code := f.post(t, snstest.Payload(t, "bounce-transient.json")); code != http.StatusNoContent {
+			t.Fatalf("status = %d, want %d", code, http.StatusNoContent)
+		}
+		if suppression := f.suppression(t, "full@mail.example.org"); suppression != nil {
+			t.Fatalf("transient bounce was suppressed: %+v", suppression)
+		}
+	})
+
+	t.Run("Complaint", func(t *testing.T) {
+		f := newSESFixture(t)
+		if code := f.post(t, snstest.Payload(t, "complaint.json")); code != http.StatusNoContent {
+			t.Fatalf("status = %d, want %d", code, http.StatusNoContent)
+		}
+		suppression := f.suppression(t, "annoyed@mail.example.org")
+		if suppression == nil || suppression.Reason != repository.SuppressionComplaint {
+			t.Fatalf("suppression = %+v, want a complaint", suppression)
+		}
+	})
+
+	t.Run("Delivery", func(t *testing.T) {
+		f := newSESFixture(t)
+		if code := f.post(t, snstest.Payload(t, "delivery.json")); code != http.StatusNoContent {
+			t.Fatalf("status = %d, want %d", code, http.StatusNoContent)
+		}
+	})
+
+	t.Run("SubscriptionConfirmation", func(t *testing.T) {
+		f := newSESFixture(t)
+		msg := snstest.Payload(t, "subscription-confirmation.json")
+		if code := f.post(t, msg); code != http.StatusNoContent {
+			t.Fatalf("status = %d, want %d", code, http.StatusNoContent)
+		}
+		if fetched := f.signer.Fetched(); len(fetched) != 1 || fetched[0] != msg.SubscribeURL {
+			t.Fatalf("fetched %v, want the subscribe URL", fetched)
+		}
+	})
+
+	t.Run("WrongTopic", func(t *testing.T) {
+		f := newSESFixture(t)
+		msg := snstest.Payload(t, "bounce-permanent.json")
+		msg.TopicARN = "arn:aws:sns:us-east-1:999999999999:someone-else"
+		if code := f.post(t, msg); code != http.StatusForbidden {
+			t.Fatalf("status = %d, want %d", code, http.StatusForbidden)
+		}
+		if suppression := f.suppression(t, "jane.doe@mail.example.org"); suppression != nil {
+			t.Fatalf("message from another topic was acted on: %+v", suppression)
+		}
+	})
+
+	t.Run("BadSignature", func(t *testing.T) {
+		f := newSESFixture(t)
+		// The recorded signature is SNS's own, which the test key can't match
+		msg := snstest.Payload(t, "bounce-permanent.json")
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/ses", bytes.NewReader(snstest.Body(t, msg)))
+		rec := httptest.NewRecorder()
+		f.handler.Notify(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("TamperedMessage", func(t *testing.T) {
+		f := newSESFixture(t)
+		msg := snstest.Payload(t, "complaint.json")
+		f.signer.Sign(t, msg, "2")
+		msg.Message = `{"notificationType":"Complaint","complaint":{"complainedRecipients":[{"emailAddress":"victim@example.com"}]}}`
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/ses", bytes.NewReader(snstest.Body(t, msg)))
+		rec := httptest.NewRecorder()
+		f.handler.Notify(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusForbidden)
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"option-manager/internal/middleware"
+	"option-manager/internal/service"
+	"strings"
+	"time"
+)
+
+type SessionView struct {
+	ID         string
+	Device     string
+	IPAddress  string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	Current    bool
+}
+
+type SessionsPageData struct {
+	Sessions  []SessionView
+	Error     string
+	Success   string
+	CSRFField template.HTML
+}
+
+type SessionsHandler struct {
+	services *service.Services
+	template *template.Template
+}
+
+func NewSessionsHandler(services *service.Services) (*SessionsHandler, error) {
+	tmpl, err := template.ParseFiles("templates/sessions.html")
+	if err != nil {
+		return nil, err
+	}
+
+	return &SessionsHandler{
+		services: services,
+		template: tmpl,
+	}, nil
+}
+
+// SessionsPage lists the user's active sessions
+func (h *SessionsHandler) SessionsPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data := SessionsPageData{}
+	switch r.URL.Query().Get("status") {
+	case "revoked":
+		data.Success = "The session has been signed out."
+	case "revoked-others":
+		data.Success = "All other sessions have been signed out."
+	}
+	h.render(w, r, data)
+}
+
+// Revoke signs out a single session belonging to the user
+func (h *SessionsHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.services.Auth.RevokeSession(r.Context(), userID, r.FormValue("session_id")); err != nil {
+		h.render(w, r, SessionsPageData{Error: err.Error()})
+		return
+	}
+
+	http.Redirect(w, r, "/sessions?status=revoked", http.StatusSeeOther)
+}
+
+// RevokeOthers signs out every session except the current one
+func (h *SessionsHandler) RevokeOthers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, ok := middleware.GetSession(r.Context())
+	if !ok {
+		http.Error(w, "Session not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.services.Auth.RevokeOtherSessions(r.Context(), session.UserID, session.ID); err != nil {
+		h.render(w, r, SessionsPageData{Error: "Failed to sign out other sessions. Please try again."})
+		return
+	}
+
+	http.Redirect(w, r, "/sessions?status=revoked-others", http.StatusSeeOther)
+}
+
+func (h *SessionsHandler) render(w http.ResponseWriter, r *http.Request, data SessionsPageData) {
+	current, ok := middleware.GetSession(r.Context())
+	if !ok {
+		http.Error(w, "Session not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	sessions, err := h.services.Auth.ListSessions(r.Context(), current.UserID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	for _, s := range sessions {
+		data.Sessions = append(data.Sessions, SessionView{
+			ID:         s.ID,
+			Device:     describeDevice(s.UserAgent),
+			IPAddress:  s.IPAddress,
+			UserAgent:  s.UserAgent,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			Current:    s.ID == current.ID,
+		})
+	}
+	data.CSRFField = middleware.CSRFField(r)
+
+	h.template.Execute(w, data)
+}
+
+// describeDevice turns a user agent into a short "Browser on OS" label
+func describeDevice(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	case strings.Contains(userAgent, "curl/"):
+		browser = "curl"
+	}
+
+	os := "unknown OS"
+	switch {
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"):
+		os = "iOS"
+	case strings.Contains(userAgent, "Android"):
+		os = "Android"
+	case strings.Contains(userAgent, "Windows"):
+		os = "Windows"
+	case strings.Contains(userAgent, "Mac OS X"):
+		os = "macOS"
+	case strings.Contains(userAgent, "Linux"):
+		os = "Linux"
+	}
+
+	return browser + " on " + os
+}
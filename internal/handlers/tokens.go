@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"option-manager/internal/middleware"
+	"option-manager/internal/repository"
+	"option-manager/internal/service"
+	"strconv"
+	"time"
+)
+
+// tokenExpiryOptions are the lifetimes offered when creating a token, in days.
+// Zero means the token never expires.
+var tokenExpiryOptions = []int{30, 90, 365, 0}
+
+type TokensPageData struct {
+	Tokens        []*repository.APIToken
+	NewToken      string
+	Scopes        []string
+	ExpiryOptions []int
+	Error         string
+	Success       string
+	CSRFField     template.HTML
+}
+
+type TokensHandler struct {
+	services *service.Services
+	template *template.Template
+}
+
+func NewTokensHandler(services *service.Services) (*TokensHandler, error) {
+	tmpl, err := template.ParseFiles("templates/tokens.html")
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokensHandler{
+		services: services,
+		template: tmpl,
+	}, nil
+}
+
+// TokensPage lists the user's personal access tokens
+func (h *TokensHandler) TokensPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data := TokensPageData{}
+	if r.URL.Query().Get("status") == "revoked" {
+		data.Success = "The token has been revoked."
+	}
+	h.render(w, r, data)
+}
+
+// Create issues a new token and shows it once
+func (h *TokensHandler) Create(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		h.render(w, r, TokensPageData{Error: "Invalid form submission"})
+		return
+	}
+
+	days, _ := strconv.Atoi(r.FormValue("expires_in_days"))
+	input := service.CreateAPITokenInput{
+		Name:      r.FormValue("name"),
+		Scopes:    r.Form["scopes"],
+		ExpiresIn: time.Duration(days) * 24 * time.Hour,
+	}
+
+	_, raw, err := h.services.APIToken.CreateToken(r.Context(), userID, input)
+	if err != nil {
+		h.render(w, r, TokensPageData{Error: err.Error()})
+		return
+	}
+
+	// Render rather than redirect: this is the only time the token is shown
+	w.Header().Set("Cache-Control", "no-store")
+	h.render(w, r, TokensPageData{NewToken: raw})
+}
+
+// Revoke deletes one of the user's tokens
+func (h *TokensHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	tokenID, err := strconv.Atoi(r.FormValue("token_id"))
+	if err != nil {
+		h.render(w, r, TokensPageData{Error: "Invalid token ID"})
+		return
+	}
+
+	if err := h.services.APIToken.RevokeToken(r.Context(), userID, tokenID); err != nil {
+		h.render(w, r, TokensPageData{Error: err.Error()})
+		return
+	}
+
+	http.Redirect(w, r, "/tokens?status=revoked", http.StatusSeeOther)
+}
+
+func (h *TokensHandler) render(w http.ResponseWriter, r *http.Request, data TokensPageData) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := h.services.APIToken.ListTokens(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data.Tokens = tokens
+	data.Scopes = service.ValidScopes
+	data.ExpiryOptions = tokenExpiryOptions
+	data.CSRFField = middleware.CSRFField(r)
+
+	h.template.Execute(w, data)
+}
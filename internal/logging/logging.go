@@ -0,0 +1,91 @@
+// internal/logging/logging.go
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Formats accepted by New
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// New builds a logger writing to w. format is "text" or "json" and level is
+// one of "debug", "info", "warn" or "error"; empty values mean text and info.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q", level)
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "", FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+}
+
+type loggerKey struct{}
+
+type requestAttrsKey struct{}
+
+// requestAttrs collects attributes added while a request is handled so the
+// access log line written on the way out can include them
+type requestAttrs struct {
+	mu    sync.Mutex
+	attrs []any
+}
+
+// NewContext returns a copy of ctx carrying logger
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger if
+// there is none
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// With returns a copy of ctx whose logger includes args on every line. Inside
+// a request started with StartRequest, the attributes are also added to the
+// request's access log line.
+func With(ctx context.Context, args ...any) context.Context {
+	if ra, ok := ctx.Value(requestAttrsKey{}).(*requestAttrs); ok {
+		ra.mu.Lock()
+		ra.attrs = append(ra.attrs, args...)
+		ra.mu.Unlock()
+	}
+	return NewContext(ctx, FromContext(ctx).With(args...))
+}
+
+// StartRequest prepares ctx to collect attributes for a request's access log
+func StartRequest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestAttrsKey{}, &requestAttrs{})
+}
+
+// RequestAttrs returns the attributes added with With since StartRequest
+func RequestAttrs(ctx context.Context) []any {
+	ra, ok := ctx.Value(requestAttrsKey{}).(*requestAttrs)
+	if !ok {
+		return nil
+	}
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	return append([]any(nil), ra.attrs...)
+}
@@ -0,0 +1,155 @@
+// internal/metrics/metrics.go
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "option_manager"
+
+// Registry holds every metric the application exports. It is separate from
+// prometheus.DefaultRegisterer so nothing registers into it by accident.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route pattern, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, by route pattern, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	emails = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "emails_total",
+		Help:      "Emails handed to the email provider, by kind and result (sent, failed or dead).",
+	}, []string{"kind", "result"})
+
+	logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Sign-in attempts, by result (success or failure).",
+	}, []string{"result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		emails,
+		logins,
+	)
+}
+
+// Handler serves the registry in the Prometheus text exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records one handled HTTP request. route should be the
+// matched route pattern rather than the raw path, to keep label cardinality
+// bounded.
+func ObserveRequest(route, method string, status int, duration time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	method = methodLabel(method)
+	code := strconv.Itoa(status)
+	httpRequests.WithLabelValues(route, method, code).Inc()
+	httpDuration.WithLabelValues(route, method, code).Observe(duration.Seconds())
+}
+
+// methodLabel returns method if it is a standard one and "OTHER" if not. The
+// method comes from the client, so using it as is would let anyone create
+// new series.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
+
+// EmailSent records an email the provider accepted
+func EmailSent(kind string) {
+	emails.WithLabelValues(kind, "sent").Inc()
+}
+
+// EmailFailed records an email the provider rejected or that couldn't be sent
+func EmailFailed(kind string) {
+	emails.WithLabelValues(kind, "failed").Inc()
+}
+
+// EmailDead records an email given up on after its last failed attempt
+func EmailDead(kind string) {
+	emails.WithLabelValues(kind, "dead").Inc()
+}
+
+// LoginSucceeded records a successful sign-in
+func LoginSucceeded() {
+	logins.WithLabelValues("success").Inc()
+}
+
+// LoginFailed records a failed sign-in attempt
+func LoginFailed() {
+	logins.WithLabelValues("failure").Inc()
+}
+
+// RegisterDB exports connection pool statistics for db
+func RegisterDB(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// RegisterActiveSessions exports the number of unexpired sessions, counted
+// with count at scrape time
+func RegisterActiveSessions(count func(ctx context.Context) (int, error)) error {
+	return Registry.Register(&activeSessionsCollector{count: count})
+}
+
+// scrapeTimeout bounds the database query behind a scrape-time metric
+const scrapeTimeout = 5 * time.Second
+
+var activeSessionsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "active_sessions"),
+	"Sessions that have not expired.",
+	nil, nil,
+)
+
+type activeSessionsCollector struct {
+	count func(ctx context.Context) (int, error)
+}
+
+func (c *activeSessionsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- activeSessionsDesc
+}
+
+func (c *activeSessionsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+	defer cancel()
+
+	n, err := c.count(ctx)
+	if err != nil {
+		slog.Error("failed to count active sessions", "error", err)
+		ch <- prometheus.NewInvalidMetric(activeSessionsDesc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(activeSessionsDesc, prometheus.GaugeValue, float64(n))
+}
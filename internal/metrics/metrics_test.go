@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMethodLabel(t *testing.T) {
+	tests := []struct {
+		method, want string
+	}{
+		{http.MethodGet, "GET"},
+		{http.MethodHead, "HEAD"},
+		{http.MethodPost, "POST"},
+		{http.MethodPut, "PUT"},
+		{http.MethodPatch, "PATCH"},
+		{http.MethodDelete, "DELETE"},
+		{http.MethodOptions, "OPTIONS"},
+		{http.MethodTrace, "OTHER"},
+		{http.MethodConnect, "OTHER"},
+		{"get", "OTHER"},
+		{"PROPFIND", "OTHER"},
+		{"X-RANDOM-12345", "OTHER"},
+		{"", "OTHER"},
+	}
+	for _, tt := range tests {
+		if got := methodLabel(tt.method); got != tt.want {
+			t.Errorf("methodLabel(%q) = %q, want %q", tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestObserveRequestBoundsMethods(t *testing.T) {
+	const route = "/metrics-test"
+	before := testutil.CollectAndCount(httpRequests)
+	for _, method := range []string{"RANDOMA", "RANDOMB", "RANDOMC"} {
+		ObserveRequest(route, method, http.StatusNotFound, time.Millisecond)
+	}
+	if n := testutil.CollectAndCount(httpRequests) - before; n != 1 {
+		t.Errorf("three unknown methods added %d series, want 1", n)
+	}
+	if n := testutil.ToFloat64(httpRequests.WithLabelValues(route, "OTHER", "404")); n != 3 {
+		t.Errorf("OTHER requests = %v, want 3", n)
+	}
+}
@@ -0,0 +1,148 @@
+// internal/middleware/csrf.go
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	csrfCookieName = "csrf_token"
+	csrfFormField  = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// safeMethods are exempt from CSRF checks as they must not change state
+var safeMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// ForbiddenPageData holds data for the 403 page
+type ForbiddenPageData struct {
+	Reason string
+}
+
+// CSRF protects state-changing requests using the double-submit cookie
+// pattern. Every request is given a random token in a cookie; unsafe requests
+// must echo it back in the csrf_token form field or the X-CSRF-Token header,
+// and must come from the same origin as baseURL or the request host.
+func CSRF(baseURL string) (Middleware, error) {
+	trusted, err := url.Parse(baseURL)
+	if err != nil || trusted.Scheme == "" || trusted.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q", baseURL)
+	}
+
+	tmpl, err := template.ParseFiles("templates/forbidden.html")
+	if err != nil {
+		return nil, err
+	}
+
+	forbidden := func(w http.ResponseWriter, reason string) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusForbidden)
+		tmpl.Execute(w, ForbiddenPageData{Reason: reason})
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := ""
+			if cookie, err := r.Cookie(csrfCookieName); err == nil && cookie.Value != "" {
+				token = cookie.Value
+			} else {
+				token, err = generateCSRFToken()
+				if err != nil {
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				http.SetCookie(w, &http.Cookie{
+					Name:     csrfCookieName,
+					Value:    token,
+					Path:     "/",
+					HttpOnly: true,
+					Secure:   r.TLS != nil,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+
+			if !safeMethods[r.Method] {
+				if !sameOrigin(r, trusted) {
+					forbidden(w, "This request did not come from Options Manager.")
+					return
+				}
+
+				submitted := r.Header.Get(csrfHeaderName)
+				if submitted == "" {
+					submitted = r.FormValue(csrfFormField)
+				}
+				if submitted == "" || subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+					forbidden(w, "Your form has expired. Please go back, refresh the page and try again.")
+					return
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), CSRFKey, token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}, nil
+}
+
+// GetCSRFToken retrieves the CSRF token from the context
+func GetCSRFToken(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(CSRFKey).(string)
+	return token, ok
+}
+
+// CSRFField returns a hidden form input carrying the request's CSRF token.
+// Templates render it inside every form that posts back to the application.
+func CSRFField(r *http.Request) template.HTML {
+	token, ok := GetCSRFToken(r.Context())
+	if !ok {
+		return ""
+	}
+	return template.HTML(fmt.Sprintf(
+		`<input type="hidden" name="%s" value="%s">`,
+		csrfFormField,
+		template.HTMLEscapeString(token),
+	))
+}
+
+// sameOrigin checks the Origin header, falling back to Referer, against the
+// trusted base URL and the request host. Requests carrying neither header are
+// allowed through and rely on the token check alone.
+func sameOrigin(r *http.Request, trusted *url.URL) bool {
+	source := r.Header.Get("Origin")
+	if source == "" || source == "null" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return r.Header.Get("Origin") != "null"
+	}
+
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	if strings.EqualFold(u.Scheme, trusted.Scheme) && strings.EqualFold(u.Host, trusted.Host) {
+		return true
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+func generateCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -3,8 +3,10 @@ package middleware
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"option-manager/internal/logging"
+	"option-manager/internal/metrics"
 	"runtime/debug"
 	"strings"
 	"time"
@@ -28,7 +30,10 @@ var sensitiveParams = map[string]bool{
 	"credential": true,
 }
 
-// Logger logs request details
+// Logger writes one access log line per request through the request's
+// logger, so it carries the request ID and, once authenticated, the user ID.
+// Server errors are logged at error level and client errors at warn. It also
+// records the request's count and latency metrics.
 func Logger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -38,32 +43,39 @@ func Logger(next http.Handler) http.Handler {
 
 		// Defer logging until after the request is processed
 		defer func() {
+			duration := time.Since(start)
+			logger := logging.FromContext(r.Context()).With(logging.RequestAttrs(r.Context())...)
+
 			// Recover from panics and log them
 			if err := recover(); err != nil {
-				log.Printf("[PANIC] %v\n%s", err, debug.Stack())
+				logger.Error("panic", "error", err, "stack", string(debug.Stack()))
 				rw.statusCode = http.StatusInternalServerError
 			}
 
-			duration := time.Since(start)
+			level := slog.LevelInfo
+			switch {
+			case rw.statusCode >= 500:
+				level = slog.LevelError
+			case rw.statusCode >= 400:
+				level = slog.LevelWarn
+			}
 
-			// Clean the URL query parameters
-			cleanedURL := cleanURL(r.URL.String())
-
-			// Log the request details
-			log.Printf(
-				"[%s] %s %s - Status: %d - Duration: %s - IP: %s - User-Agent: %s",
-				r.Method,
-				r.Host,
-				cleanedURL,
-				rw.statusCode,
-				duration.Round(time.Millisecond),
-				getClientIP(r),
-				r.UserAgent(),
+			logger.Log(r.Context(), level, "request",
+				"method", r.Method,
+				"host", r.Host,
+				"url", cleanURL(r.URL.String()),
+				"status", rw.statusCode,
+				"duration", duration.Round(time.Millisecond),
+				"ip", ClientIP(r),
+				"user_agent", r.UserAgent(),
 			)
 
-			// Log headers (excluding sensitive ones)
-			if shouldLogHeaders() {
-				logHeaders(r)
+			// r.Pattern is the route the mux matched, e.g. "/sessions"
+			metrics.ObserveRequest(r.Pattern, r.Method, rw.statusCode, duration)
+
+			// Log headers (excluding sensitive ones) when debugging
+			if logger.Enabled(r.Context(), slog.LevelDebug) {
+				logger.Debug("request headers", headerAttrs(r)...)
 			}
 		}()
 
@@ -118,8 +130,8 @@ func cleanURL(url string) string {
 	return fmt.Sprintf("%s?%s", path, strings.Join(params, "&"))
 }
 
-// getClientIP extracts the client's IP address
-func getClientIP(r *http.Request) string {
+// ClientIP extracts the client's IP address
+func ClientIP(r *http.Request) string {
 	// Check X-Forwarded-For header first
 	forwarded := r.Header.Get("X-Forwarded-For")
 	if forwarded != "" {
@@ -136,21 +148,28 @@ func getClientIP(r *http.Request) string {
 	return strings.Split(r.RemoteAddr, ":")[0]
 }
 
-// logHeaders logs non-sensitive headers
-func logHeaders(r *http.Request) {
+// headerAttrs returns the request headers as log attributes, with sensitive
+// ones redacted
+func headerAttrs(r *http.Request) []any {
+	attrs := make([]any, 0, len(r.Header))
 	for name, values := range r.Header {
-		if sensitiveHeaders[name] {
-			log.Printf("Header %s: [REDACTED]", name)
-			continue
+		value := strings.Join(values, ", ")
+		if isSensitiveHeader(name) {
+			value = "[REDACTED]"
 		}
-		log.Printf("Header %s: %s", name, strings.Join(values, ", "))
+		attrs = append(attrs, slog.String(name, value))
 	}
+	return attrs
 }
 
-// shouldLogHeaders returns true if detailed header logging should be enabled
-// This could be controlled by environment variables or other configuration
-func shouldLogHeaders() bool {
-	// For development environments, you might want to return true
-	// For production, you might want to make this configurable
+// isSensitiveHeader matches header names case-insensitively, since incoming
+// names are canonicalised (X-Csrf-Token) while the list is written as they
+// are usually spelled (X-CSRF-Token)
+func isSensitiveHeader(name string) bool {
+	for header := range sensitiveHeaders {
+		if strings.EqualFold(header, name) {
+			return true
+		}
+	}
 	return false
 }
@@ -4,21 +4,28 @@ package middleware
 import (
 	"context"
 	"net/http"
+	"option-manager/internal/logging"
+	"option-manager/internal/repository"
 	"option-manager/internal/service"
+	"runtime/debug"
+	"strings"
 )
 
 // contextKey is a custom type for context keys to avoid collisions
 type contextKey string
 
 const (
-	UserIDKey  contextKey = "user_id"
-	SessionKey contextKey = "session"
+	UserIDKey   contextKey = "user_id"
+	SessionKey  contextKey = "session"
+	CSRFKey     contextKey = "csrf_token"
+	APITokenKey contextKey = "api_token"
 )
 
 // Middleware represents a middleware function
 type Middleware func(http.Handler) http.Handler
 
-// Chain applies multiple middlewares to a handler
+// Chain applies multiple middlewares to a handler. Each middleware wraps the
+// ones before it, so the last middleware in the list runs first.
 func Chain(h http.Handler, middlewares ...Middleware) http.Handler {
 	for _, m := range middlewares {
 		h = m(h)
@@ -30,14 +37,14 @@ func Chain(h http.Handler, middlewares ...Middleware) http.Handler {
 func RequireAuth(services *service.Services) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			cookie, err := r.Cookie("session_id")
+			cookie, err := r.Cookie(SessionCookieName)
 			if err != nil {
 				http.Redirect(w, r, "/login", http.StatusSeeOther)
 				return
 			}
 
 			session, err := services.Auth.GetSession(r.Context(), cookie.Value)
-			if err != nil {
+			if err != nil || session == nil {
 				http.Redirect(w, r, "/login", http.StatusSeeOther)
 				return
 			}
@@ -45,6 +52,16 @@ func RequireAuth(services *service.Services) Middleware {
 			// Add session and user info to context
 			ctx := context.WithValue(r.Context(), SessionKey, session)
 			ctx = context.WithValue(ctx, UserIDKey, session.UserID)
+			ctx = service.WithActor(ctx, session.UserID)
+			ctx = logging.With(ctx, "user_id", session.UserID)
+
+			// Slide the idle expiry forward and re-issue the cookie to match
+			extended, err := services.Auth.TouchSession(ctx, session)
+			if err != nil {
+				logging.FromContext(ctx).Error("failed to update session activity", "error", err)
+			} else if extended {
+				SetSessionCookie(w, r, session)
+			}
 
 			// Call the next handler with the updated context
 			next.ServeHTTP(w, r.WithContext(ctx))
@@ -52,6 +69,92 @@ func RequireAuth(services *service.Services) Middleware {
 	}
 }
 
+// RequirePermission ensures the authenticated user's role grants permission.
+// It relies on RequireAuth having run first, so list it before RequireAuth
+// in a Chain.
+func RequirePermission(services *service.Services, permission string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := GetUserID(r.Context())
+			if !ok {
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				return
+			}
+
+			allowed, err := services.Auth.HasPermission(r.Context(), userID, permission)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("failed to check permission", "permission", permission, "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if !allowed {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireToken authenticates requests carrying an "Authorization: Bearer"
+// personal access token. It puts the token's user under UserIDKey, just like
+// RequireAuth, so handlers work the same behind either. Failures are answered
+// with a JSON error envelope since only the API uses tokens.
+func RequireToken(services *service.Services) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme, raw, found := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !found || !strings.EqualFold(scheme, "Bearer") || raw == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+				WriteJSONError(w, http.StatusUnauthorized, "unauthorized", "a bearer token is required")
+				return
+			}
+
+			token, err := services.APIToken.Authenticate(r.Context(), strings.TrimSpace(raw))
+			if err != nil {
+				logging.FromContext(r.Context()).Error("failed to authenticate API token", "error", err)
+				WriteJSONError(w, http.StatusInternalServerError, "internal_error", "internal server error")
+				return
+			}
+			if token == nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+				WriteJSONError(w, http.StatusUnauthorized, "invalid_token", "the token is invalid, expired or revoked")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), APITokenKey, token)
+			ctx = context.WithValue(ctx, UserIDKey, token.UserID)
+			ctx = service.WithActor(ctx, token.UserID)
+			ctx = logging.With(ctx, "user_id", token.UserID, "api_token_id", token.ID)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireScope ensures a token-authenticated request was granted scope.
+// Requests authenticated by session cookie are not limited by scopes. List it
+// before RequireToken in a Chain.
+func RequireScope(scope string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token, ok := GetAPIToken(r.Context()); ok && !service.HasScope(token, scope) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="insufficient_scope", scope="`+scope+`"`)
+				WriteJSONError(w, http.StatusForbidden, "insufficient_scope", "the token lacks the "+scope+" scope")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// GetAPIToken retrieves the API token from the context
+func GetAPIToken(ctx context.Context) (*repository.APIToken, bool) {
+	token, ok := ctx.Value(APITokenKey).(*repository.APIToken)
+	return token, ok
+}
+
 // GetUserID retrieves the user ID from the context
 func GetUserID(ctx context.Context) (int, bool) {
 	userID, ok := ctx.Value(UserIDKey).(int)
@@ -59,8 +162,8 @@ func GetUserID(ctx context.Context) (int, bool) {
 }
 
 // GetSession retrieves the session from the context
-func GetSession(ctx context.Context) (interface{}, bool) {
-	session, ok := ctx.Value(SessionKey).(interface{})
+func GetSession(ctx context.Context) (*repository.Session, bool) {
+	session, ok := ctx.Value(SessionKey).(*repository.Session)
 	return session, ok
 }
 
@@ -71,7 +174,7 @@ func Recoverer(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				// Log the error
+				logging.FromContext(r.Context()).Error("panic", "error", err, "stack", string(debug.Stack()))
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			}
 		}()
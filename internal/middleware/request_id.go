@@ -0,0 +1,76 @@
+// internal/middleware/request_id.go
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"option-manager/internal/logging"
+	"option-manager/internal/service"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the request ID in both directions. An ID supplied
+// by a trusted proxy is kept so logs can be correlated across services.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds IDs accepted from clients
+const maxRequestIDLength = 64
+
+// RequestID tags each request with an ID and echoes it in the response. It
+// attaches the ID to the context twice: on the request's logger, so every
+// line logged while handling the request carries it, and in the
+// service.RequestInfo read by the service layer, along with the client's IP
+// and user agent. List it last in a Chain so it runs before everything else.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := service.WithRequestInfo(r.Context(), service.RequestInfo{
+			RequestID: id,
+			IPAddress: ClientIP(r),
+			UserAgent: r.UserAgent(),
+		})
+		ctx = logging.With(ctx, "request_id", id)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("request.id", id))
+		ctx = logging.StartRequest(ctx)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetRequestID retrieves the request ID from the context
+func GetRequestID(ctx context.Context) string {
+	return service.RequestInfoFromContext(ctx).RequestID
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts IDs made of letters, digits and a little
+// punctuation, so they're safe to log and store
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
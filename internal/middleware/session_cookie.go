@@ -0,0 +1,37 @@
+// internal/middleware/session_cookie.go
+package middleware
+
+import (
+	"net/http"
+	"option-manager/internal/repository"
+	"time"
+)
+
+// SessionCookieName is the cookie holding the raw session token
+const SessionCookieName = "session_id"
+
+// SetSessionCookie issues the session cookie. It must be called whenever the
+// session token or expiry changes so the browser never holds a stale copy.
+func SetSessionCookie(w http.ResponseWriter, r *http.Request, session *repository.Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    session.Token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		Expires:  session.ExpiresAt,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// ClearSessionCookie removes the session cookie from the browser
+func ClearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Expires:  time.Now().Add(-24 * time.Hour),
+		MaxAge:   -1,
+	})
+}
@@ -0,0 +1,78 @@
+// internal/password/bcrypt.go
+package password
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultBcryptCost is the work factor for new bcrypt hashes
+const DefaultBcryptCost = bcrypt.DefaultCost
+
+// bcryptMaxLength is the number of bytes bcrypt actually hashes; anything
+// beyond it would be ignored
+const bcryptMaxLength = 72
+
+// Bcrypt hashes passwords with bcrypt. Its modular crypt strings
+// ("$2a$10$...") already carry the algorithm and cost.
+type Bcrypt struct {
+	cost int
+}
+
+// NewBcrypt returns a bcrypt hasher with the given cost
+func NewBcrypt(cost int) *Bcrypt {
+	return &Bcrypt{cost: cost}
+}
+
+func (b *Bcrypt) Hash(password string) (string, error) {
+	if len(password) > bcryptMaxLength {
+		return "", fmt.Errorf("%w: bcrypt accepts at most %d bytes", ErrTooLong, bcryptMaxLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (b *Bcrypt) Verify(password, encoded string) (bool, error) {
+	if !isBcrypt(encoded) {
+		return false, ErrUnsupportedHash
+	}
+	// bcrypt would compare only the first 72 bytes, so a longer password
+	// sharing them would match. Hash never accepts one, so none can.
+	if len(password) > bcryptMaxLength {
+		return false, nil
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *Bcrypt) NeedsRehash(encoded string) bool {
+	if !isBcrypt(encoded) {
+		return true
+	}
+
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return true
+	}
+	return cost < b.cost
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
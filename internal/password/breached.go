@@ -0,0 +1,60 @@
+// internal/password/breached.go
+package password
+
+import (
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"fmt"
+	"os"
+	"sort"
+)
+
+//go:generate go run gen_breached.go -o data/breached.bin data/common.txt
+
+//go:embed data/breached.bin
+var defaultBreachedList []byte
+
+// BreachedList is a set of known-breached passwords stored as sorted raw
+// SHA-1 digests, 20 bytes each. Lookups are a binary search, so the list
+// works offline and large lists only cost their size in memory.
+type BreachedList struct {
+	digests []byte
+}
+
+// DefaultBreachedList returns the list bundled with the binary
+func DefaultBreachedList() *BreachedList {
+	return &BreachedList{digests: defaultBreachedList}
+}
+
+// LoadBreachedList reads a list produced by gen_breached.go from disk
+func LoadBreachedList(path string) (*BreachedList, error) {
+	digests, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read breached password list: %w", err)
+	}
+	if len(digests)%sha1.Size != 0 {
+		return nil, fmt.Errorf("breached password list %s is corrupt", path)
+	}
+	return &BreachedList{digests: digests}, nil
+}
+
+// Len returns the number of passwords in the list
+func (l *BreachedList) Len() int {
+	return len(l.digests) / sha1.Size
+}
+
+// Contains reports whether password appears in the list
+func (l *BreachedList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	n := l.Len()
+
+	i := sort.Search(n, func(i int) bool {
+		return bytes.Compare(l.record(i), sum[:]) >= 0
+	})
+	return i < n && bytes.Equal(l.record(i), sum[:])
+}
+
+func (l *BreachedList) record(i int) []byte {
+	return l.digests[i*sha1.Size : (i+1)*sha1.Size]
+}
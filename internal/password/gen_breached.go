@@ -0,0 +1,88 @@
+//go:build ignore
+
+// gen_breached builds the compact breached-password list read by
+// LoadBreachedList. Each input line is either a plaintext password or a
+// SHA-1 hex digest in the "HASH" or "HASH:COUNT" form used by the Have I Been
+// Pwned downloads. The output is the sorted, de-duplicated raw SHA-1 digests.
+//
+// Usage:
+//
+//	go run gen_breached.go -o data/breached.bin data/common.txt [more.txt ...]
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+func main() {
+	out := flag.String("o", "data/breached.bin", "output file")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Fatal("usage: go run gen_breached.go -o OUTPUT INPUT...")
+	}
+
+	var digests [][]byte
+	for _, path := range flag.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimRight(scanner.Text(), "\r")
+			if line == "" {
+				continue
+			}
+			digests = append(digests, digestOf(line))
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal(err)
+		}
+		f.Close()
+	}
+
+	sort.Slice(digests, func(i, j int) bool {
+		return bytes.Compare(digests[i], digests[j]) < 0
+	})
+
+	var buf bytes.Buffer
+	var prev []byte
+	count := 0
+	for _, d := range digests {
+		if bytes.Equal(d, prev) {
+			continue
+		}
+		buf.Write(d)
+		prev = d
+		count++
+	}
+
+	if err := os.WriteFile(*out, buf.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("wrote %d hashes to %s\n", count, *out)
+}
+
+// digestOf returns the SHA-1 digest for a line, decoding it if the line is
+// already a hex digest
+func digestOf(line string) []byte {
+	candidate, _, _ := strings.Cut(line, ":")
+	if len(candidate) == sha1.Size*2 {
+		if d, err := hex.DecodeString(candidate); err == nil {
+			return d
+		}
+	}
+	sum := sha1.Sum([]byte(line))
+	return sum[:]
+}
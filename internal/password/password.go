@@ -0,0 +1,72 @@
+// internal/password/password.go
+package password
+
+import (
+	"errors"
+)
+
+var (
+	// ErrUnsupportedHash is returned when a hasher doesn't recognise the
+	// format of a stored hash
+	ErrUnsupportedHash = errors.New("unsupported password hash format")
+
+	// ErrTooLong is returned when a password exceeds what the algorithm can
+	// hash without truncating it
+	ErrTooLong = errors.New("password is too long")
+)
+
+// Hasher hashes and verifies passwords. Hashes are encoded as PHC-style
+// strings ("$<id>$<params>$...") so every stored hash records the algorithm
+// and parameters it was produced with.
+type Hasher interface {
+	// Hash returns the encoded hash of password
+	Hash(password string) (string, error)
+
+	// Verify reports whether password matches the encoded hash. It returns
+	// ErrUnsupportedHash if the hash was produced by another algorithm.
+	Verify(password, encoded string) (bool, error)
+
+	// NeedsRehash reports whether the encoded hash was produced by another
+	// algorithm or with weaker parameters than the hasher's current policy
+	NeedsRehash(encoded string) bool
+}
+
+// Default returns the hasher used for new passwords: Argon2id with the
+// default parameters, still accepting bcrypt hashes created before it.
+func Default() Hasher {
+	return New(NewArgon2id(DefaultArgon2idParams), NewBcrypt(DefaultBcryptCost))
+}
+
+// New returns a Hasher that hashes with current and verifies hashes produced
+// by current or any of the legacy hashers. Hashes that current didn't produce
+// with its present parameters are reported as needing a rehash.
+func New(current Hasher, legacy ...Hasher) Hasher {
+	return &upgradingHasher{
+		current: current,
+		legacy:  legacy,
+	}
+}
+
+type upgradingHasher struct {
+	current Hasher
+	legacy  []Hasher
+}
+
+func (h *upgradingHasher) Hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+func (h *upgradingHasher) Verify(password, encoded string) (bool, error) {
+	for _, hasher := range append([]Hasher{h.current}, h.legacy...) {
+		ok, err := hasher.Verify(password, encoded)
+		if errors.Is(err, ErrUnsupportedHash) {
+			continue
+		}
+		return ok, err
+	}
+	return false, ErrUnsupportedHash
+}
+
+func (h *upgradingHasher) NeedsRehash(encoded string) bool {
+	return h.current.NeedsRehash(encoded)
+}
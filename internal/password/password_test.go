@@ -0,0 +1,285 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2idParams keep the tests fast; the encoding doesn't depend on them
+var testArgon2idParams = Argon2idParams{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	hasher := NewArgon2id(testArgon2idParams)
+
+	for _, password := range []string{"correct horse battery staple", "", "pässwörd 🔑", strings.Repeat("x", 200)} {
+		encoded, err := hasher.Hash(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+			t.Errorf("Hash = %q, want a PHC string with the hasher's parameters", encoded)
+		}
+
+		params, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			t.Fatalf("decodeArgon2id(%q): %v", encoded, err)
+		}
+		if params != testArgon2idParams {
+			t.Errorf("decoded params = %+v, want %+v", params, testArgon2idParams)
+		}
+		if len(salt) != 16 || len(key) != 32 {
+			t.Errorf("decoded %d-byte salt and %d-byte key, want 16 and 32", len(salt), len(key))
+		}
+
+		if ok, err := hasher.Verify(password, encoded); err != nil || !ok {
+			t.Errorf("Verify(%q) = %v, %v; want true, nil", password, ok, err)
+		}
+		if ok, err := hasher.Verify(password+"!", encoded); err != nil || ok {
+			t.Errorf("Verify with the wrong password = %v, %v; want false, nil", ok, err)
+		}
+		if hasher.NeedsRehash(encoded) {
+			t.Error("NeedsRehash = true for a hash made with the current parameters")
+		}
+	}
+
+	first, _ := hasher.Hash("same")
+	second, _ := hasher.Hash("same")
+	if first == second {
+		t.Error("two hashes of the same password are equal; salt isn't random")
+	}
+}
+
+func TestArgon2idRejectsMalformed(t *testing.T) {
+	hasher := NewArgon2id(testArgon2idParams)
+	valid, err := hasher.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(valid, "$")
+	salt, key := parts[4], parts[5]
+
+	tests := []struct {
+		name        string
+		encoded     string
+		unsupported bool
+	}{
+		{"empty", "", true},
+		{"bcrypt", "$2a$10$abcdefghijklmnopqrstuu5Gx2i7Tpmv3qf6fxJgW3cZ1hYp2Gm6", true},
+		{"argon2i", "$argon2i$v=19$m=1024,t=1,p=1$" + salt + "$" + key, true},
+		{"missing hash", "$argon2id$v=19$m=1024,t=1,p=1$" + salt, true},
+		{"extra field", valid + "$extra", true},
+		{"no leading $", strings.TrimPrefix(valid, "$"), true},
+		{"old version", "$argon2id$v=16$m=1024,t=1,p=1$" + salt + "$" + key, false},
+		{"bad version", "$argon2id$v=x$m=1024,t=1,p=1$" + salt + "$" + key, false},
+		{"bad params", "$argon2id$v=19$m=lots,t=1,p=1$" + salt + "$" + key, false},
+		{"bad salt", "$argon2id$v=19$m=1024,t=1,p=1$!!!!$" + key, false},
+		{"bad hash", "$argon2id$v=19$m=1024,t=1,p=1$" + salt + "$!!!!", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := hasher.Verify("secret", tt.encoded)
+			if ok || err == nil {
+				t.Errorf("Verify = %v, %v; want an error", ok, err)
+			}
+			if got := errors.Is(err, ErrUnsupportedHash); got != tt.unsupported {
+				t.Errorf("Verify error %v: ErrUnsupportedHash = %v, want %v", err, got, tt.unsupported)
+			}
+			if !hasher.NeedsRehash(tt.encoded) {
+				t.Error("NeedsRehash = false for a hash that can't be parsed")
+			}
+		})
+	}
+}
+
+func TestArgon2idRejectsTampered(t *testing.T) {
+	hasher := NewArgon2id(testArgon2idParams)
+	valid, err := hasher.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(valid, "$")
+
+	flip := func(s string) string {
+		c := byte('A')
+		if s[0] == 'A' {
+			c = 'B'
+		}
+		return string(c) + s[1:]
+	}
+
+	tests := map[string]string{
+		"hash":       strings.Join([]string{"", parts[1], parts[2], parts[3], parts[4], flip(parts[5])}, "$"),
+		"salt":       strings.Join([]string{"", parts[1], parts[2], parts[3], flip(parts[4]), parts[5]}, "$"),
+		"iterations": strings.Join([]string{"", parts[1], parts[2], "m=1024,t=2,p=1", parts[4], parts[5]}, "$"),
+		"memory":     strings.Join([]string{"", parts[1], parts[2], "m=2048,t=1,p=1", parts[4], parts[5]}, "$"),
+		"truncated":  valid[:len(valid)-4],
+	}
+	for name, encoded := range tests {
+		t.Run(name, func(t *testing.T) {
+			if ok, err := hasher.Verify("secret", encoded); ok {
+				t.Errorf("Verify = true, %v; want false", err)
+			}
+		})
+	}
+}
+
+func TestArgon2idNeedsRehash(t *testing.T) {
+	policy := Argon2idParams{Memory: 1024, Iterations: 2, Parallelism: 2, SaltLength: 16, KeyLength: 32}
+	current := NewArgon2id(policy)
+
+	tests := []struct {
+		name   string
+		params func(p *Argon2idParams)
+		want   bool
+	}{
+		{"same", func(p *Argon2idParams) {}, false},
+		{"stronger", func(p *Argon2idParams) { p.Memory *= 2; p.Iterations++ }, false},
+		{"less memory", func(p *Argon2idParams) { p.Memory /= 2 }, true},
+		{"fewer iterations", func(p *Argon2idParams) { p.Iterations-- }, true},
+		{"less parallelism", func(p *Argon2idParams) { p.Parallelism-- }, true},
+		{"shorter salt", func(p *Argon2idParams) { p.SaltLength = 8 }, true},
+		{"shorter key", func(p *Argon2idParams) { p.KeyLength = 16 }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := policy
+			tt.params(&params)
+			encoded, err := NewArgon2id(params).Hash("secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := current.NeedsRehash(encoded); got != tt.want {
+				t.Errorf("NeedsRehash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBcrypt(t *testing.T) {
+	hasher := NewBcrypt(bcrypt.MinCost)
+
+	encoded, err := hasher.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := hasher.Verify("secret", encoded); err != nil || !ok {
+		t.Errorf("Verify = %v, %v; want true, nil", ok, err)
+	}
+	if ok, err := hasher.Verify("Secret", encoded); err != nil || ok {
+		t.Errorf("Verify with the wrong password = %v, %v; want false, nil", ok, err)
+	}
+	if hasher.NeedsRehash(encoded) {
+		t.Error("NeedsRehash = true at the current cost")
+	}
+	if !NewBcrypt(bcrypt.MinCost + 1).NeedsRehash(encoded) {
+		t.Error("NeedsRehash = false after the cost went up")
+	}
+
+	argon, err := NewArgon2id(testArgon2idParams).Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, other := range []string{argon, "", "secret", "$1$salt$hash"} {
+		if _, err := hasher.Verify("secret", other); !errors.Is(err, ErrUnsupportedHash) {
+			t.Errorf("Verify(%q) error = %v, want ErrUnsupportedHash", other, err)
+		}
+		if !hasher.NeedsRehash(other) {
+			t.Errorf("NeedsRehash(%q) = false for a non-bcrypt hash", other)
+		}
+	}
+}
+
+func TestBcryptLengthLimit(t *testing.T) {
+	hasher := NewBcrypt(bcrypt.MinCost)
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  bool
+	}{
+		{"72 bytes", strings.Repeat("a", 72), false},
+		{"73 bytes", strings.Repeat("a", 73), true},
+		// The limit is in bytes, not characters: 24 × 3-byte runes is 72
+		{"72 bytes of multi-byte runes", strings.Repeat("€", 24), false},
+		{"75 bytes of multi-byte runes", strings.Repeat("€", 25), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := hasher.Hash(tt.password)
+			if tt.wantErr {
+				if !errors.Is(err, ErrTooLong) {
+					t.Errorf("Hash error = %v, want ErrTooLong", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A longer password that only differs past the limit must not match
+			if ok, _ := hasher.Verify(tt.password+"b", encoded); ok {
+				t.Error("a password longer than the limit verified against a shorter one's hash")
+			}
+		})
+	}
+}
+
+func TestUpgradingHasher(t *testing.T) {
+	legacy := NewBcrypt(bcrypt.MinCost)
+	hasher := New(NewArgon2id(testArgon2idParams), legacy)
+
+	current, err := hasher.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(current, "$argon2id$") {
+		t.Errorf("Hash = %q, want an argon2id hash", current)
+	}
+	old, err := legacy.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	weak, err := NewArgon2id(Argon2idParams{Memory: 512, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}).Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		encoded     string
+		needsRehash bool
+	}{
+		{"current", current, false},
+		{"legacy bcrypt", old, true},
+		{"weaker argon2id", weak, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ok, err := hasher.Verify("secret", tt.encoded); err != nil || !ok {
+				t.Errorf("Verify = %v, %v; want true, nil", ok, err)
+			}
+			if ok, err := hasher.Verify("wrong", tt.encoded); err != nil || ok {
+				t.Errorf("Verify with the wrong password = %v, %v; want false, nil", ok, err)
+			}
+			if got := hasher.NeedsRehash(tt.encoded); got != tt.needsRehash {
+				t.Errorf("NeedsRehash = %v, want %v", got, tt.needsRehash)
+			}
+		})
+	}
+
+	if _, err := hasher.Verify("secret", "plaintext"); !errors.Is(err, ErrUnsupportedHash) {
+		t.Errorf("Verify of an unknown format: error = %v, want ErrUnsupportedHash", err)
+	}
+	// A malformed hash in a recognised format is an error, not a mismatch
+	if _, err := hasher.Verify("secret", "$argon2id$v=19$m=x$a$b"); err == nil || errors.Is(err, ErrUnsupportedHash) {
+		t.Errorf("Verify of a malformed argon2id hash: error = %v, want a parse error", err)
+	}
+}
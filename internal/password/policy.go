@@ -0,0 +1,66 @@
+// internal/password/policy.go
+package password
+
+import (
+	"fmt"
+)
+
+// Policy decides whether a new password is acceptable
+type Policy struct {
+	MinLength int
+	MinScore  int // minimum Estimate score, 0 to 4
+	Breached  *BreachedList
+}
+
+// DefaultPolicy requires eight characters, a strength score of at least 3
+// and that the password isn't on the bundled breached-password list
+func DefaultPolicy() *Policy {
+	return &Policy{
+		MinLength: 8,
+		MinScore:  3,
+		Breached:  DefaultBreachedList(),
+	}
+}
+
+// PolicyError explains why a password was rejected and how to pick a
+// better one
+type PolicyError struct {
+	Reason      string
+	Suggestions []string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Reason
+}
+
+// Check returns a *PolicyError if password doesn't meet the policy.
+// userInputs are the user's personal details, which make for easily guessed
+// passwords.
+func (p *Policy) Check(password string, userInputs ...string) error {
+	if len([]rune(password)) < p.MinLength {
+		return &PolicyError{
+			Reason: fmt.Sprintf("Password must be at least %d characters long.", p.MinLength),
+		}
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		return &PolicyError{
+			Reason:      "This password has appeared in a data breach and can't be used.",
+			Suggestions: []string{"Choose a password you haven't used on any other site."},
+		}
+	}
+
+	strength := Estimate(password, userInputs...)
+	if strength.Score < p.MinScore {
+		reason := "This password is too easy to guess."
+		if strength.Warning != "" {
+			reason = reason + " " + strength.Warning
+		}
+		return &PolicyError{
+			Reason:      reason,
+			Suggestions: strength.Suggestions,
+		}
+	}
+
+	return nil
+}
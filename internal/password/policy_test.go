@@ -0,0 +1,264 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeBreachedList writes passwords in the format gen_breached.go produces
+// and loads it
+func writeBreachedList(t *testing.T, passwords []string) *BreachedList {
+	t.Helper()
+	digests := make([][]byte, len(passwords))
+	for i, password := range passwords {
+		sum := sha1.Sum([]byte(password))
+		digests[i] = sum[:]
+	}
+	sort.Slice(digests, func(i, j int) bool { return bytes.Compare(digests[i], digests[j]) < 0 })
+
+	path := filepath.Join(t.TempDir(), "breached.bin")
+	if err := os.WriteFile(path, bytes.Join(digests, nil), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	list, err := LoadBreachedList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+// byDigest returns passwords sorted the way the list stores them
+func byDigest(passwords []string) []string {
+	sorted := append([]string(nil), passwords...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sha1.Sum([]byte(sorted[i])), sha1.Sum([]byte(sorted[j]))
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+	return sorted
+}
+
+func TestBreachedListSearch(t *testing.T) {
+	var passwords []string
+	for i := 0; i < 100; i++ {
+		passwords = append(passwords, fmt.Sprintf("leaked-%d", i))
+	}
+	list := writeBreachedList(t, passwords)
+	if list.Len() != len(passwords) {
+		t.Fatalf("Len = %d, want %d", list.Len(), len(passwords))
+	}
+
+	sorted := byDigest(passwords)
+	tests := []struct {
+		name     string
+		password string
+		want     bool
+	}{
+		{"first entry", sorted[0], true},
+		{"second entry", sorted[1], true},
+		{"middle entry", sorted[len(sorted)/2], true},
+		{"last entry", sorted[len(sorted)-1], true},
+		{"not listed", "leaked-100", false},
+		{"different case", "LEAKED-1", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := list.Contains(tt.password); got != tt.want {
+				t.Errorf("Contains(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+
+	for _, size := range []int{0, 1, 2} {
+		small := writeBreachedList(t, passwords[:size])
+		for i, password := range passwords[:3] {
+			if got, want := small.Contains(password), i < size; got != want {
+				t.Errorf("list of %d: Contains(%q) = %v, want %v", size, password, got, want)
+			}
+		}
+	}
+}
+
+func TestLoadBreachedListRejectsCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.bin")
+	if err := os.WriteFile(path, make([]byte, sha1.Size+1), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadBreachedList(path); err == nil {
+		t.Error("LoadBreachedList accepted a file that isn't whole digests")
+	}
+	if _, err := LoadBreachedList(filepath.Join(t.TempDir(), "missing.bin")); err == nil {
+		t.Error("LoadBreachedList accepted a missing file")
+	}
+}
+
+// The bundled list is built from data/common.txt; every password in it,
+// including the ones stored first and last, must be found
+func TestDefaultBreachedList(t *testing.T) {
+	f, err := os.Open("data/common.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var passwords []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), "\r"); line != "" {
+			passwords = append(passwords, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	list := DefaultBreachedList()
+	if list.Len() == 0 {
+		t.Fatal("bundled list is empty")
+	}
+	sorted := byDigest(passwords)
+	for _, password := range []string{sorted[0], sorted[len(sorted)-1]} {
+		if !list.Contains(password) {
+			t.Errorf("bundled list doesn't contain %q", password)
+		}
+	}
+	for _, password := range passwords {
+		if !list.Contains(password) {
+			t.Errorf("bundled list doesn't contain %q; regenerate it with go generate", password)
+		}
+	}
+	if list.Contains("correct horse battery staple 9") {
+		t.Error("bundled list contains a password that isn't in data/common.txt")
+	}
+}
+
+func TestScoreFor(t *testing.T) {
+	tests := []struct {
+		guesses float64
+		want    int
+	}{
+		{1, 0},
+		{999, 0},
+		{1e3, 1},
+		{1e6 - 1, 1},
+		{1e6, 2},
+		{1e8 - 1, 2},
+		{1e8, 3},
+		{1e10 - 1, 3},
+		{1e10, 4},
+		{1e30, 4},
+	}
+	for _, tt := range tests {
+		if got := scoreFor(tt.guesses); got != tt.want {
+			t.Errorf("scoreFor(%g) = %d, want %d", tt.guesses, got, tt.want)
+		}
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	userInputs := []string{"alice.smith@example.com", "Alice", "Smith"}
+
+	tests := []struct {
+		password string
+		maxScore int
+		minScore int
+		warning  string
+	}{
+		{"password", 0, 0, "This is a top-10 common password."},
+		{"P@ssw0rd!", 1, 0, "This is similar to a commonly used password."},
+		{"aaaaaaaaaaaa", 0, 0, `Repeats like "aaa" or "abcabc" are easy to guess.`},
+		{"abcdefghijkl", 0, 0, "Sequences like abc or 6543 are easy to guess."},
+		{"alicesmith2020", 1, 0, "Passwords shouldn't contain your name or email address."},
+		{"correct horse battery staple", 4, 4, ""},
+		{"xk9#Lq2!vBz7@Wp", 4, 4, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			got := Estimate(tt.password, userInputs...)
+			if got.Score < tt.minScore || got.Score > tt.maxScore {
+				t.Errorf("Score = %d, want %d to %d", got.Score, tt.minScore, tt.maxScore)
+			}
+			if got.Warning != tt.warning {
+				t.Errorf("Warning = %q, want %q", got.Warning, tt.warning)
+			}
+			if got.Score <= 2 && len(got.Suggestions) == 0 {
+				t.Error("weak password came with no suggestions")
+			}
+		})
+	}
+
+	// Personal details only count against a password when they're given
+	if with, without := Estimate("alicesmith2020", userInputs...), Estimate("alicesmith2020"); with.Guesses >= without.Guesses {
+		t.Errorf("guesses with the user's name = %g, without = %g; want fewer with", with.Guesses, without.Guesses)
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	policy := &Policy{
+		MinLength: 8,
+		MinScore:  3,
+		Breached:  writeBreachedList(t, []string{"hunter2hunter2", "Tr0ub4dour&3"}),
+	}
+
+	tests := []struct {
+		name       string
+		password   string
+		reason     string // empty if the password is accepted
+		suggestion string
+	}{
+		{"too short", "xk9#Lq2", "Password must be at least 8 characters long.", ""},
+		{"length counts characters, not bytes", "ééééééé", "Password must be at least 8 characters long.", ""},
+		{"breached", "hunter2hunter2", "This password has appeared in a data breach and can't be used.", "Choose a password you haven't used on any other site."},
+		{"breached though strong", "Tr0ub4dour&3", "This password has appeared in a data breach and can't be used.", "Choose a password you haven't used on any other site."},
+		{"weak", "abcdefghijkl", "This password is too easy to guess. Sequences like abc or 6543 are easy to guess.", "Avoid sequences."},
+		{"uses the user's name", "alicesmith2020", "This password is too easy to guess. Passwords shouldn't contain your name or email address.", "Add another word or two. Uncommon words are better."},
+		{"strong", "correct horse battery staple", "", ""},
+		{"exactly long enough and strong", "xk9#Lq2!", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.password, "alice.smith@example.com", "Alice", "Smith")
+			if tt.reason == "" {
+				if err != nil {
+					t.Fatalf("Check = %v, want nil", err)
+				}
+				return
+			}
+
+			var policyErr *PolicyError
+			if !errors.As(err, &policyErr) {
+				t.Fatalf("Check = %v, want a *PolicyError", err)
+			}
+			if err.Error() != tt.reason {
+				t.Errorf("error = %q, want %q", err.Error(), tt.reason)
+			}
+			if tt.suggestion != "" && !slices.Contains(policyErr.Suggestions, tt.suggestion) {
+				t.Errorf("suggestions = %q, want %q among them", policyErr.Suggestions, tt.suggestion)
+			}
+		})
+	}
+}
+
+// The score threshold is inclusive: a password with exactly MinScore passes
+func TestPolicyScoreThreshold(t *testing.T) {
+	for _, password := range []string{"P@ssw0rd!", "zxcvbnm,./", "sunshine99x", "correct horse battery staple"} {
+		score := Estimate(password).Score
+		if err := (&Policy{MinLength: 8, MinScore: score}).Check(password); err != nil {
+			t.Errorf("%q with score %d rejected at MinScore %d: %v", password, score, score, err)
+		}
+		if score < 4 {
+			if err := (&Policy{MinLength: 8, MinScore: score + 1}).Check(password); err == nil {
+				t.Errorf("%q with score %d accepted at MinScore %d", password, score, score+1)
+			}
+		}
+	}
+}
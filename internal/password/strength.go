@@ -0,0 +1,498 @@
+// internal/password/strength.go
+package password
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// A zxcvbn-style strength estimator. The password is broken into the
+// cheapest-to-guess sequence of recognisable patterns (common passwords,
+// personal details, sequences, repeats, keyboard rows, years) with
+// brute-force filling the gaps, and the number of guesses an attacker would
+// need is turned into a score from 0 to 4.
+
+//go:embed data/common.txt
+var commonPasswordData []byte
+
+// commonPasswords maps each bundled common password to its popularity rank
+var commonPasswords = loadRankedList(commonPasswordData)
+
+// maxAnalysedLength bounds the work done on very long passwords; anything
+// past it only adds brute-force guesses
+const maxAnalysedLength = 100
+
+// bruteforceCardinality is the per-character guess count for characters not
+// covered by any pattern
+const bruteforceCardinality = 10
+
+// keyboardRows are the rows of a QWERTY keyboard, used to spot "qwerty" and
+// "asdf"-style passwords
+var keyboardRows = []string{"1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm"}
+
+// l33tSubstitutions maps common character substitutions back to letters
+var l33tSubstitutions = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g',
+	'1': 'i', '!': 'i', '|': 'l', '0': 'o', '$': 's', '5': 's',
+	'7': 't', '+': 't', '2': 'z',
+}
+
+// Strength is the result of estimating how guessable a password is
+type Strength struct {
+	Guesses     float64
+	Score       int // 0 (too guessable) to 4 (very unguessable)
+	Warning     string
+	Suggestions []string
+}
+
+type patternKind int
+
+const (
+	patternBruteforce patternKind = iota
+	patternCommon
+	patternUserInput
+	patternSequence
+	patternRepeat
+	patternKeyboard
+	patternYear
+)
+
+type match struct {
+	start, end int // rune offsets, end exclusive
+	kind       patternKind
+	guesses    float64
+	rank       int
+}
+
+// Estimate scores password. userInputs are personal details such as the
+// user's name and email address, which make a password easy to guess for
+// anyone who knows them.
+func Estimate(password string, userInputs ...string) Strength {
+	runes := []rune(password)
+	extra := 0
+	if len(runes) > maxAnalysedLength {
+		extra = len(runes) - maxAnalysedLength
+		runes = runes[:maxAnalysedLength]
+	}
+
+	var matches []match
+	matches = append(matches, dictionaryMatches(runes, commonPasswords, patternCommon)...)
+	matches = append(matches, dictionaryMatches(runes, userInputDictionary(userInputs), patternUserInput)...)
+	matches = append(matches, sequenceMatches(runes)...)
+	matches = append(matches, repeatMatches(runes)...)
+	matches = append(matches, keyboardMatches(runes)...)
+	matches = append(matches, yearMatches(runes)...)
+
+	guesses, sequence := mostGuessableSequence(runes, matches)
+	guesses *= math.Pow(bruteforceCardinality, float64(extra))
+
+	strength := Strength{
+		Guesses: guesses,
+		Score:   scoreFor(guesses),
+	}
+	strength.Warning, strength.Suggestions = feedbackFor(strength.Score, sequence)
+	return strength
+}
+
+func scoreFor(guesses float64) int {
+	switch {
+	case guesses < 1e3:
+		return 0
+	case guesses < 1e6:
+		return 1
+	case guesses < 1e8:
+		return 2
+	case guesses < 1e10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// mostGuessableSequence finds the cover of the password by non-overlapping
+// matches and brute-force runs that minimises the guesses needed. Like
+// zxcvbn, a sequence of l patterns costs l! times the product of their
+// guesses, since the attacker doesn't know the order the patterns come in.
+func mostGuessableSequence(runes []rune, matches []match) (float64, []match) {
+	n := len(runes)
+	if n == 0 {
+		return 1, nil
+	}
+
+	byEnd := make([][]match, n+1)
+	for _, m := range matches {
+		byEnd[m.end] = append(byEnd[m.end], m)
+	}
+	for end := 1; end <= n; end++ {
+		for start := 0; start < end; start++ {
+			byEnd[end] = append(byEnd[end], match{
+				start:   start,
+				end:     end,
+				kind:    patternBruteforce,
+				guesses: math.Pow(bruteforceCardinality, float64(end-start)),
+			})
+		}
+	}
+
+	// best[k][l] is the smallest product of guesses covering runes[:k] with
+	// exactly l matches; back[k][l] is the last match of that cover
+	best := make([][]float64, n+1)
+	back := make([][]*match, n+1)
+	for k := range best {
+		best[k] = make([]float64, n+1)
+		back[k] = make([]*match, n+1)
+		for l := range best[k] {
+			best[k][l] = math.Inf(1)
+		}
+	}
+	best[0][0] = 1
+
+	for k := 1; k <= n; k++ {
+		for i := range byEnd[k] {
+			m := &byEnd[k][i]
+			for l := 0; l < n; l++ {
+				prev := best[m.start][l]
+				if math.IsInf(prev, 1) {
+					continue
+				}
+				// Adjacent brute-force runs are a single longer run
+				if m.kind == patternBruteforce && back[m.start][l] != nil && back[m.start][l].kind == patternBruteforce {
+					continue
+				}
+				if g := prev * m.guesses; g < best[k][l+1] {
+					best[k][l+1] = g
+					back[k][l+1] = m
+				}
+			}
+		}
+	}
+
+	guesses := math.Inf(1)
+	length := 0
+	for l := 1; l <= n; l++ {
+		if g := factorial(l) * best[n][l]; g < guesses {
+			guesses = g
+			length = l
+		}
+	}
+
+	var sequence []match
+	for k, l := n, length; k > 0; l-- {
+		m := back[k][l]
+		sequence = append([]match{*m}, sequence...)
+		k = m.start
+	}
+	return guesses, sequence
+}
+
+func factorial(n int) float64 {
+	f := 1.0
+	for i := 2; i <= n; i++ {
+		f *= float64(i)
+	}
+	return f
+}
+
+// dictionaryMatches finds substrings of three or more characters that appear
+// in dict, also trying them reversed and with l33t substitutions undone
+func dictionaryMatches(runes []rune, dict map[string]int, kind patternKind) []match {
+	if len(dict) == 0 {
+		return nil
+	}
+
+	lower := []rune(strings.ToLower(string(runes)))
+	var matches []match
+	for i := 0; i < len(lower); i++ {
+		for j := i + 3; j <= len(lower); j++ {
+			word := string(lower[i:j])
+			variants := []struct {
+				word       string
+				multiplier float64
+			}{
+				{word, 1},
+				{reverse(word), 2},
+				{unl33t(word), 2},
+			}
+
+			found := false
+			for _, v := range variants {
+				rank, ok := dict[v.word]
+				if !ok || found {
+					continue
+				}
+				found = true
+				matches = append(matches, match{
+					start:   i,
+					end:     j,
+					kind:    kind,
+					rank:    rank,
+					guesses: float64(rank) * v.multiplier * uppercaseVariations(runes[i:j]),
+				})
+			}
+		}
+	}
+	return matches
+}
+
+// uppercaseVariations estimates the extra guesses spent on capitalisation
+func uppercaseVariations(runes []rune) float64 {
+	upper, lower := 0, 0
+	for _, r := range runes {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		}
+	}
+	switch {
+	case upper == 0 || lower == 0:
+		if upper == 0 {
+			return 1
+		}
+		return 2
+	case unicode.IsUpper(runes[0]) && upper == 1:
+		return 2
+	default:
+		return math.Pow(2, float64(upper))
+	}
+}
+
+// sequenceMatches finds runs like "abcd", "9876" or "aceg"
+func sequenceMatches(runes []rune) []match {
+	var matches []match
+	add := func(start, end int, delta rune) {
+		if end-start < 3 {
+			return
+		}
+		base := 26.0
+		if unicode.IsDigit(runes[start]) {
+			base = 10
+		}
+		switch unicode.ToLower(runes[start]) {
+		case 'a', 'z', '0', '1', '9':
+			base = 4
+		}
+		if delta < 0 {
+			base *= 2
+		}
+		if delta != 1 && delta != -1 {
+			base *= 2
+		}
+		matches = append(matches, match{
+			start:   start,
+			end:     end,
+			kind:    patternSequence,
+			guesses: base * float64(end-start),
+		})
+	}
+
+	for i := 0; i+1 < len(runes); {
+		delta := unicode.ToLower(runes[i+1]) - unicode.ToLower(runes[i])
+		if delta == 0 || delta < -2 || delta > 2 || !sameClass(runes[i], runes[i+1]) {
+			i++
+			continue
+		}
+
+		j := i + 1
+		for j+1 < len(runes) &&
+			unicode.ToLower(runes[j+1])-unicode.ToLower(runes[j]) == delta &&
+			sameClass(runes[j], runes[j+1]) {
+			j++
+		}
+		add(i, j+1, delta)
+		i = j
+	}
+	return matches
+}
+
+func sameClass(a, b rune) bool {
+	return unicode.IsDigit(a) == unicode.IsDigit(b) && unicode.IsLetter(a) == unicode.IsLetter(b)
+}
+
+// repeatMatches finds a unit of one or more characters repeated back to
+// back, like "aaaa" or "abcabc"
+func repeatMatches(runes []rune) []match {
+	var matches []match
+	n := len(runes)
+	for unit := 1; unit <= n/2; unit++ {
+		for start := 0; start+2*unit <= n; start++ {
+			end := start + unit
+			for end+unit <= n && string(runes[end:end+unit]) == string(runes[start:start+unit]) {
+				end += unit
+			}
+			count := (end - start) / unit
+			if count < 2 || (unit == 1 && count < 3) {
+				continue
+			}
+			matches = append(matches, match{
+				start:   start,
+				end:     end,
+				kind:    patternRepeat,
+				guesses: math.Pow(bruteforceCardinality, float64(unit)) * float64(count),
+			})
+		}
+	}
+	return matches
+}
+
+// keyboardMatches finds runs of four or more neighbouring keys on one row
+func keyboardMatches(runes []rune) []match {
+	lower := strings.ToLower(string(runes))
+	lowerRunes := []rune(lower)
+	var matches []match
+	for i := 0; i < len(lowerRunes); i++ {
+		for j := i + 4; j <= len(lowerRunes); j++ {
+			word := string(lowerRunes[i:j])
+			for _, row := range keyboardRows {
+				if strings.Contains(row, word) || strings.Contains(row, reverse(word)) {
+					matches = append(matches, match{
+						start:   i,
+						end:     j,
+						kind:    patternKeyboard,
+						guesses: float64(len(row)) * 2 * float64(j-i),
+					})
+					break
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// yearMatches finds four-digit years from 1900 to 2099
+func yearMatches(runes []rune) []match {
+	var matches []match
+	for i := 0; i+4 <= len(runes); i++ {
+		s := string(runes[i : i+4])
+		if (strings.HasPrefix(s, "19") || strings.HasPrefix(s, "20")) && isDigits(s) {
+			matches = append(matches, match{
+				start:   i,
+				end:     i + 4,
+				kind:    patternYear,
+				guesses: 200,
+			})
+		}
+	}
+	return matches
+}
+
+// feedbackFor explains the weakest part of a password that scored poorly
+func feedbackFor(score int, sequence []match) (string, []string) {
+	if score > 2 {
+		return "", nil
+	}
+
+	suggestions := []string{"Add another word or two. Uncommon words are better."}
+
+	// Explain the longest recognised pattern; it dominates the estimate
+	var worst *match
+	for i := range sequence {
+		m := &sequence[i]
+		if m.kind == patternBruteforce {
+			continue
+		}
+		if worst == nil || m.end-m.start > worst.end-worst.start {
+			worst = m
+		}
+	}
+	if worst == nil {
+		return "", append(suggestions, "Use a longer password with more unpredictable characters.")
+	}
+
+	switch worst.kind {
+	case patternCommon:
+		warning := "This is similar to a commonly used password."
+		switch {
+		case worst.rank <= 10 && len(sequence) == 1:
+			warning = "This is a top-10 common password."
+		case worst.rank <= 100 && len(sequence) == 1:
+			warning = "This is a top-100 common password."
+		case len(sequence) == 1:
+			warning = "This is a very common password."
+		}
+		return warning, append(suggestions,
+			"Capitalisation and predictable substitutions like '@' for 'a' don't help very much.")
+	case patternUserInput:
+		return "Passwords shouldn't contain your name or email address.", suggestions
+	case patternSequence:
+		return "Sequences like abc or 6543 are easy to guess.", append(suggestions, "Avoid sequences.")
+	case patternRepeat:
+		return `Repeats like "aaa" or "abcabc" are easy to guess.`, append(suggestions, "Avoid repeated words and characters.")
+	case patternKeyboard:
+		return "Straight rows of keys are easy to guess.", append(suggestions, "Use a longer keyboard pattern with more turns.")
+	case patternYear:
+		return "Years are easy to guess.", append(suggestions, "Avoid years that are associated with you.")
+	}
+	return "", suggestions
+}
+
+// userInputDictionary ranks the user's personal details, splitting email
+// addresses and names into their parts
+func userInputDictionary(inputs []string) map[string]int {
+	dict := make(map[string]int)
+	rank := 1
+	for _, input := range inputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+		parts := strings.FieldsFunc(input, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, word := range append([]string{input}, parts...) {
+			if len([]rune(word)) < 3 {
+				continue
+			}
+			if _, ok := dict[word]; !ok {
+				dict[word] = rank
+				rank++
+			}
+		}
+	}
+	return dict
+}
+
+func loadRankedList(data []byte) map[string]int {
+	ranked := make(map[string]int)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	rank := 1
+	for scanner.Scan() {
+		word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if word == "" {
+			continue
+		}
+		if _, ok := ranked[word]; !ok {
+			ranked[word] = rank
+			rank++
+		}
+	}
+	return ranked
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+func unl33t(s string) string {
+	return strings.Map(func(r rune) rune {
+		if sub, ok := l33tSubstitutions[r]; ok {
+			return sub
+		}
+		return r
+	}, s)
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
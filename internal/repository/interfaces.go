@@ -3,29 +3,155 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"time"
 )
 
+// ErrDuplicateEmail is returned by UserRepository.Create when another user
+// already has the email address
+var ErrDuplicateEmail = errors.New("email already registered")
+
 // User represents the user model
 type User struct {
-	ID                 int
-	Email              string
-	PasswordHash       string
-	FirstName          string
-	LastName           string
-	EmailVerified      bool
-	VerificationToken  *string
-	VerificationExpiry *time.Time
-	CreatedAt          time.Time
-	UpdatedAt          time.Time
-}
-
-// Session represents the session model
+	ID                  int
+	Email               string
+	PasswordHash        string
+	FirstName           string
+	LastName            string
+	EmailVerified       bool
+	VerificationToken   *string // SHA-256 hex digest of the emailed token
+	VerificationExpiry  *time.Time
+	PendingEmail        *string // new address awaiting confirmation
+	EmailChangeToken    *string // SHA-256 hex digest of the token sent to PendingEmail
+	EmailCancelToken    *string // SHA-256 hex digest of the token sent to Email
+	EmailChangeExpiry   *time.Time
+	DeletionScheduledAt *time.Time // when the account will be deleted, if the user asked
+	Role                string
+	DisabledAt          *time.Time
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
+// UserFilter narrows and pages a user listing. Results are ordered by ID;
+// pass the last ID of one page as AfterID to fetch the next.
+type UserFilter struct {
+	Query   string // matched against email and name
+	Role    string // exact role name; empty matches every role
+	AfterID int
+	Limit   int
+}
+
+// Session represents the session model. ID is the SHA-256 hex digest of the
+// session token; the token itself is never stored.
 type Session struct {
-	ID        string
-	UserID    int
-	ExpiresAt time.Time
+	ID                string
+	Token             string // raw token for the cookie; not persisted
+	UserID            int
+	IPAddress         string
+	UserAgent         string
+	RememberMe        bool
+	ExpiresAt         time.Time
+	AbsoluteExpiresAt time.Time
+	LastSeenAt        time.Time
+	CreatedAt         time.Time
+}
+
+// APIToken represents a personal access token. Only the SHA-256 hex digest
+// of the token is stored; the token itself is shown to the user once.
+type APIToken struct {
+	ID         int
+	UserID     int
+	Name       string
+	TokenHash  string
+	Scopes     []string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// AuditEvent is one entry in the append-only audit log. Before and After are
+// JSON snapshots of whatever the event changed, and are nil when not
+// applicable. ActorUserID is who did it; SubjectUserID is whose account it
+// concerns.
+type AuditEvent struct {
+	ID            int64
+	Type          string
+	ActorUserID   *int
+	SubjectUserID *int
+	IPAddress     string
+	UserAgent     string
+	RequestID     string
+	Before        json.RawMessage
+	After         json.RawMessage
+	Metadata      map[string]string
+	CreatedAt     time.Time
+}
+
+// AuditFilter narrows and pages an audit log search. Results are newest
+// first; pass the last ID of one page as BeforeID to fetch the next.
+type AuditFilter struct {
+	UserID        int    // matches either the actor or the subject
+	SubjectUserID int    // matches the subject only
+	Type          string // event type prefix, e.g. "auth." or "auth.login.failed"
+	RequestID     string
+	Since         *time.Time
+	Until         *time.Time
+	BeforeID      int64
+	Limit         int
+}
+
+// Outbox message statuses
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxDead    = "dead"
+)
+
+// OutboxMessage is an email waiting to be delivered, or the record of one
+// that was. Bodies are cleared once the message is sent or given up on,
+// since they can hold single-use links.
+type OutboxMessage struct {
+	ID            int64
+	Kind          string // what the email is for, e.g. "verification"
+	UserID        *int   // the user it was sent for, if any
+	Recipient     string
+	Subject       string
+	HTMLBody      string
+	TextBody      string
+	Status        string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	SentAt        *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// OutboxFilter narrows and pages an outbox listing. Results are newest
+// first; pass the last ID of one page as BeforeID to fetch the next.
+type OutboxFilter struct {
+	Status    string // exact status; empty matches every status
+	Recipient string // exact address, ignoring case
+	UserID    int    // the user emails were sent for; zero matches every email
+	BeforeID  int64
+	Limit     int
+}
+
+// Email suppression reasons
+const (
+	SuppressionBounce    = "bounce"
+	SuppressionComplaint = "complaint"
+)
+
+// EmailSuppression is an address email must not be sent to, because mail
+// to it bounced permanently or its owner reported it as spam
+type EmailSuppression struct {
+	Email     string // always lower case
+	Reason    string
+	Detail    string // e.g. the bounce's diagnostic code
 	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
 // UserRepository defines all user-related database operations
@@ -36,18 +162,125 @@ type UserRepository interface {
 	FindByVerificationToken(ctx context.Context, token string) (*User, error)
 	UpdateVerificationStatus(ctx context.Context, userID int, verified bool) error
 	SetVerificationToken(ctx context.Context, userID int, token string, expiry time.Time) error
+	UpdatePasswordHash(ctx context.Context, userID int, passwordHash string) error
+	List(ctx context.Context, filter UserFilter) ([]*User, error)
+	SetDisabled(ctx context.Context, userID int, disabled bool) error
+	SetRole(ctx context.Context, userID int, role string) error
+	// UpdateProfile only applies if the user's updated_at still equals
+	// expectedUpdatedAt, and returns sql.ErrNoRows otherwise
+	UpdateProfile(ctx context.Context, userID int, firstName, lastName string, expectedUpdatedAt time.Time) error
+	FindByEmailChangeToken(ctx context.Context, token string) (*User, error)
+	FindByEmailCancelToken(ctx context.Context, token string) (*User, error)
+	// SetPendingEmail starts an email change, replacing any in progress
+	SetPendingEmail(ctx context.Context, userID int, email, token, cancelToken string, expiry time.Time) error
+	// ConfirmPendingEmail makes the pending email the user's verified email,
+	// if token is still the change's token and it hasn't expired. It returns
+	// ErrDuplicateEmail if another user has the address, and sql.ErrNoRows
+	// if no such change is pending.
+	ConfirmPendingEmail(ctx context.Context, userID int, token string) error
+	ClearPendingEmail(ctx context.Context, userID int) error
+	// ScheduleDeletion sets when the user is deleted, or cancels the
+	// deletion if at is nil
+	ScheduleDeletion(ctx context.Context, userID int, at *time.Time) error
+	// ListDueForDeletion returns up to limit users whose deletion was
+	// scheduled for before, earliest first
+	ListDueForDeletion(ctx context.Context, before time.Time, limit int) ([]*User, error)
+	// Delete removes the user along with their sessions and API tokens
+	Delete(ctx context.Context, userID int) error
 }
 
 // SessionRepository defines all session-related database operations
 type SessionRepository interface {
 	Create(ctx context.Context, session *Session) error
 	FindByID(ctx context.Context, id string) (*Session, error)
+	ListByUserID(ctx context.Context, userID int) ([]*Session, error)
+	UpdateActivity(ctx context.Context, id string, lastSeen, expiresAt time.Time) error
+	Rotate(ctx context.Context, oldID, newID string) error
 	Delete(ctx context.Context, id string) error
-	DeleteExpired(ctx context.Context) error
+	DeleteForUser(ctx context.Context, userID int, id string) error
+	DeleteByUserID(ctx context.Context, userID int, exceptID string) error
+	// DeleteExpired returns the number of sessions deleted
+	DeleteExpired(ctx context.Context) (int64, error)
+	CountActive(ctx context.Context) (int, error)
+}
+
+// RoleRepository defines all role and permission database operations
+type RoleRepository interface {
+	PermissionsForRole(ctx context.Context, role string) ([]string, error)
+}
+
+// APITokenRepository defines all API token database operations
+type APITokenRepository interface {
+	Create(ctx context.Context, token *APIToken) error
+	FindByHash(ctx context.Context, tokenHash string) (*APIToken, error)
+	ListByUserID(ctx context.Context, userID int) ([]*APIToken, error)
+	UpdateLastUsed(ctx context.Context, id int, lastUsed time.Time) error
+	DeleteForUser(ctx context.Context, userID int, id int) error
+}
+
+// AuditRepository defines all audit log database operations. The log is
+// append-only: events can't be deleted, and the only change allowed is
+// RedactUser's.
+type AuditRepository interface {
+	Create(ctx context.Context, event *AuditEvent) error
+	List(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error)
+	// RedactUser blanks the user's personal data in the log, for when they
+	// are deleted: the IP address, user agent, snapshots and metadata of
+	// every event whose subject is the user, and the IP address and user
+	// agent of every event they caused. It returns how many events it
+	// changed.
+	RedactUser(ctx context.Context, userID int) (int64, error)
+}
+
+// OutboxRepository defines all email outbox database operations
+type OutboxRepository interface {
+	// Enqueue adds a pending message that is due at once
+	Enqueue(ctx context.Context, msg *OutboxMessage) error
+	// ClaimDue returns up to limit pending messages that are due, picking
+	// the longest waiting first. It counts an attempt on each and pushes its
+	// next attempt to leaseUntil, so no other worker claims it meanwhile.
+	ClaimDue(ctx context.Context, limit int, leaseUntil time.Time) ([]*OutboxMessage, error)
+	MarkSent(ctx context.Context, id int64, sentAt time.Time) error
+	// MarkFailed records a failed attempt and when to try again
+	MarkFailed(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error
+	// MarkDead gives up on a message
+	MarkDead(ctx context.Context, id int64, lastError string) error
+	List(ctx context.Context, filter OutboxFilter) ([]*OutboxMessage, error)
+	// DeleteByUserID deletes every message sent for the user, whatever
+	// address it went to, and returns how many it deleted
+	DeleteByUserID(ctx context.Context, userID int) (int64, error)
+}
+
+// SuppressionRepository defines all email suppression database operations.
+// Addresses are matched ignoring case.
+type SuppressionRepository interface {
+	// Add suppresses an address, or replaces the reason and detail of an
+	// existing suppression
+	Add(ctx context.Context, suppression *EmailSuppression) error
+	// Find returns the address's suppression, or nil if there is none
+	Find(ctx context.Context, email string) (*EmailSuppression, error)
+	// Delete lifts a suppression
+	Delete(ctx context.Context, email string) error
+}
+
+// TxManager runs work that must succeed or fail as a whole
+type TxManager interface {
+	// WithinTx calls fn with repositories bound to a single transaction,
+	// committing if fn returns nil and rolling back otherwise. Calling it
+	// again with the ctx fn was given joins the outer transaction rather
+	// than starting another. A transaction that fails to serialize is
+	// retried from the start, so fn may run more than once.
+	WithinTx(ctx context.Context, fn func(ctx context.Context, tx *Repository) error) error
 }
 
 // Repository holds all repositories
 type Repository struct {
-	User    UserRepository
-	Session SessionRepository
+	User        UserRepository
+	Session     SessionRepository
+	Role        RoleRepository
+	APIToken    APITokenRepository
+	Audit       AuditRepository
+	Outbox      OutboxRepository
+	Suppression SuppressionRepository
+	Tx          TxManager
 }
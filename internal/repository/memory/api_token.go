@@ -0,0 +1,130 @@
+// internal/repository/memory/api_token.go
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"option-manager/internal/repository"
+	"sort"
+	"sync"
+	"time"
+)
+
+type APITokenRepo struct {
+	mu     sync.Mutex
+	tokens map[int]*repository.APIToken
+	nextID int
+}
+
+func NewAPITokenRepo() *APITokenRepo {
+	return &APITokenRepo{
+		tokens: make(map[int]*repository.APIToken),
+		nextID: 1,
+	}
+}
+
+func copyAPIToken(token *repository.APIToken) *repository.APIToken {
+	c := *token
+	c.Scopes = append([]string(nil), token.Scopes...)
+	if token.ExpiresAt != nil {
+		expiresAt := *token.ExpiresAt
+		c.ExpiresAt = &expiresAt
+	}
+	if token.LastUsedAt != nil {
+		lastUsedAt := *token.LastUsedAt
+		c.LastUsedAt = &lastUsedAt
+	}
+	return &c
+}
+
+func (r *APITokenRepo) Create(ctx context.Context, token *repository.APIToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.tokens {
+		if existing.TokenHash == token.TokenHash {
+			return errDuplicateKey
+		}
+	}
+
+	token.ID = r.nextID
+	token.LastUsedAt = nil
+	token.CreatedAt = now()
+	r.nextID++
+
+	r.tokens[token.ID] = copyAPIToken(token)
+	return nil
+}
+
+func (r *APITokenRepo) FindByHash(ctx context.Context, tokenHash string) (*repository.APIToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := time.Now()
+	for _, token := range r.tokens {
+		if token.TokenHash != tokenHash {
+			continue
+		}
+		if token.ExpiresAt != nil && !token.ExpiresAt.After(current) {
+			return nil, nil
+		}
+		return copyAPIToken(token), nil
+	}
+	return nil, nil
+}
+
+func (r *APITokenRepo) ListByUserID(ctx context.Context, userID int) ([]*repository.APIToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var tokens []*repository.APIToken
+	for _, token := range r.tokens {
+		if token.UserID == userID {
+			tokens = append(tokens, copyAPIToken(token))
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+		}
+		return tokens[i].ID > tokens[j].ID
+	})
+	return tokens, nil
+}
+
+func (r *APITokenRepo) UpdateLastUsed(ctx context.Context, id int, lastUsed time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	token.LastUsedAt = &lastUsed
+	return nil
+}
+
+func (r *APITokenRepo) DeleteForUser(ctx context.Context, userID int, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[id]
+	if !ok || token.UserID != userID {
+		return sql.ErrNoRows
+	}
+	delete(r.tokens, id)
+	return nil
+}
+
+// deleteUser removes the user's tokens, as the foreign key does when a user
+// is deleted
+func (r *APITokenRepo) deleteUser(userID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, token := range r.tokens {
+		if token.UserID == userID {
+			delete(r.tokens, id)
+		}
+	}
+}
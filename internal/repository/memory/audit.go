@@ -0,0 +1,126 @@
+// internal/repository/memory/audit.go
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"option-manager/internal/repository"
+	"strings"
+	"sync"
+)
+
+// AuditRepo is append-only like its Postgres counterpart: events can be
+// added and listed, and redacted, but never otherwise changed
+type AuditRepo struct {
+	mu     sync.Mutex
+	events []*repository.AuditEvent
+}
+
+func NewAuditRepo() *AuditRepo {
+	return &AuditRepo{}
+}
+
+func copyAuditEvent(event *repository.AuditEvent) *repository.AuditEvent {
+	c := *event
+	if event.ActorUserID != nil {
+		id := *event.ActorUserID
+		c.ActorUserID = &id
+	}
+	if event.SubjectUserID != nil {
+		id := *event.SubjectUserID
+		c.SubjectUserID = &id
+	}
+	if len(event.Before) > 0 {
+		c.Before = append(json.RawMessage(nil), event.Before...)
+	} else {
+		c.Before = nil
+	}
+	if len(event.After) > 0 {
+		c.After = append(json.RawMessage(nil), event.After...)
+	} else {
+		c.After = nil
+	}
+	c.Metadata = make(map[string]string, len(event.Metadata))
+	for key, value := range event.Metadata {
+		c.Metadata[key] = value
+	}
+	return &c
+}
+
+func (r *AuditRepo) Create(ctx context.Context, event *repository.AuditEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	event.ID = int64(len(r.events) + 1)
+	event.CreatedAt = now()
+	r.events = append(r.events, copyAuditEvent(event))
+	return nil
+}
+
+func (r *AuditRepo) List(ctx context.Context, filter repository.AuditFilter) ([]*repository.AuditEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	matches := func(event *repository.AuditEvent) bool {
+		if filter.UserID != 0 &&
+			!(event.ActorUserID != nil && *event.ActorUserID == filter.UserID) &&
+			!(event.SubjectUserID != nil && *event.SubjectUserID == filter.UserID) {
+			return false
+		}
+		if filter.SubjectUserID != 0 && (event.SubjectUserID == nil || *event.SubjectUserID != filter.SubjectUserID) {
+			return false
+		}
+		if filter.Type != "" && !strings.HasPrefix(event.Type, filter.Type) {
+			return false
+		}
+		if filter.RequestID != "" && event.RequestID != filter.RequestID {
+			return false
+		}
+		if filter.Since != nil && event.CreatedAt.Before(*filter.Since) {
+			return false
+		}
+		if filter.Until != nil && !event.CreatedAt.Before(*filter.Until) {
+			return false
+		}
+		if filter.BeforeID != 0 && event.ID >= filter.BeforeID {
+			return false
+		}
+		return true
+	}
+
+	// Events are stored in ID order; walk backwards for newest first
+	var events []*repository.AuditEvent
+	for i := len(r.events) - 1; i >= 0 && len(events) < filter.Limit; i-- {
+		if matches(r.events[i]) {
+			events = append(events, copyAuditEvent(r.events[i]))
+		}
+	}
+	return events, nil
+}
+
+func (r *AuditRepo) RedactUser(ctx context.Context, userID int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Replace rather than change events, so a snapshot still holds the
+	// originals
+	var redacted int64
+	for i, event := range r.events {
+		about := event.SubjectUserID != nil && *event.SubjectUserID == userID
+		by := event.ActorUserID != nil && *event.ActorUserID == userID
+		if !about && !by {
+			continue
+		}
+		c := copyAuditEvent(event)
+		c.IPAddress = ""
+		c.UserAgent = ""
+		if about {
+			c.Before = nil
+			c.After = nil
+			c.Metadata = map[string]string{}
+		}
+		r.events[i] = c
+		redacted++
+	}
+	return redacted, nil
+}
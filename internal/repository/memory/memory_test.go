@@ -0,0 +1,14 @@
+package memory_test
+
+import (
+	"option-manager/internal/repository"
+	"option-manager/internal/repository/memory"
+	"option-manager/internal/repository/repotest"
+	"testing"
+)
+
+func TestContract(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) *repository.Repository {
+		return memory.NewRepository()
+	})
+}
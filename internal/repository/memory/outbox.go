@@ -0,0 +1,171 @@
+// internal/repository/memory/outbox.go
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"option-manager/internal/repository"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type OutboxRepo struct {
+	mu       sync.Mutex
+	messages map[int64]*repository.OutboxMessage
+	nextID   int64
+}
+
+func NewOutboxRepo() *OutboxRepo {
+	return &OutboxRepo{
+		messages: make(map[int64]*repository.OutboxMessage),
+		nextID:   1,
+	}
+}
+
+func copyOutboxMessage(msg *repository.OutboxMessage) *repository.OutboxMessage {
+	c := *msg
+	if msg.UserID != nil {
+		userID := *msg.UserID
+		c.UserID = &userID
+	}
+	if msg.SentAt != nil {
+		sentAt := *msg.SentAt
+		c.SentAt = &sentAt
+	}
+	return &c
+}
+
+func (r *OutboxRepo) Enqueue(ctx context.Context, msg *repository.OutboxMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	created := now()
+	msg.ID = r.nextID
+	msg.Status = repository.OutboxPending
+	msg.Attempts = 0
+	msg.LastError = ""
+	msg.NextAttemptAt = created
+	msg.SentAt = nil
+	msg.CreatedAt = created
+	msg.UpdatedAt = created
+	r.nextID++
+
+	r.messages[msg.ID] = copyOutboxMessage(msg)
+	return nil
+}
+
+func (r *OutboxRepo) ClaimDue(ctx context.Context, limit int, leaseUntil time.Time) ([]*repository.OutboxMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := now()
+	var due []*repository.OutboxMessage
+	for _, msg := range r.messages {
+		if msg.Status == repository.OutboxPending && !msg.NextAttemptAt.After(current) {
+			due = append(due, msg)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+		}
+		return due[i].ID < due[j].ID
+	})
+	if len(due) > limit {
+		due = due[:max(limit, 0)]
+	}
+
+	claimed := make([]*repository.OutboxMessage, 0, len(due))
+	for _, msg := range due {
+		msg.Attempts++
+		msg.NextAttemptAt = leaseUntil
+		msg.UpdatedAt = current
+		claimed = append(claimed, copyOutboxMessage(msg))
+	}
+	return claimed, nil
+}
+
+// update applies fn to the stored message and bumps updated_at, or returns
+// sql.ErrNoRows like the Postgres repository
+func (r *OutboxRepo) update(id int64, fn func(msg *repository.OutboxMessage)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	msg, ok := r.messages[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	fn(msg)
+	msg.UpdatedAt = now()
+	return nil
+}
+
+func (r *OutboxRepo) MarkSent(ctx context.Context, id int64, sentAt time.Time) error {
+	return r.update(id, func(msg *repository.OutboxMessage) {
+		msg.Status = repository.OutboxSent
+		msg.SentAt = &sentAt
+		msg.LastError = ""
+		msg.HTMLBody = ""
+		msg.TextBody = ""
+	})
+}
+
+func (r *OutboxRepo) MarkFailed(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error {
+	return r.update(id, func(msg *repository.OutboxMessage) {
+		msg.LastError = lastError
+		msg.NextAttemptAt = nextAttemptAt
+	})
+}
+
+func (r *OutboxRepo) MarkDead(ctx context.Context, id int64, lastError string) error {
+	return r.update(id, func(msg *repository.OutboxMessage) {
+		msg.Status = repository.OutboxDead
+		msg.LastError = lastError
+		msg.HTMLBody = ""
+		msg.TextBody = ""
+	})
+}
+
+func (r *OutboxRepo) List(ctx context.Context, filter repository.OutboxFilter) ([]*repository.OutboxMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var messages []*repository.OutboxMessage
+	for _, msg := range r.messages {
+		if filter.Status != "" && msg.Status != filter.Status {
+			continue
+		}
+		if filter.Recipient != "" && !strings.EqualFold(msg.Recipient, filter.Recipient) {
+			continue
+		}
+		if filter.UserID != 0 && (msg.UserID == nil || *msg.UserID != filter.UserID) {
+			continue
+		}
+		if filter.BeforeID != 0 && msg.ID >= filter.BeforeID {
+			continue
+		}
+		messages = append(messages, copyOutboxMessage(msg))
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID > messages[j].ID })
+
+	if len(messages) > filter.Limit {
+		messages = messages[:max(filter.Limit, 0)]
+	}
+	return messages, nil
+}
+
+func (r *OutboxRepo) DeleteByUserID(ctx context.Context, userID int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	for id, msg := range r.messages {
+		if msg.UserID != nil && *msg.UserID == userID {
+			delete(r.messages, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,42 @@
+// internal/repository/memory/repository.go
+
+// Package memory implements the repository interfaces in memory, for tests
+// that don't need a database. It mirrors the Postgres implementation's
+// behaviour, which the shared contract tests in repotest check.
+package memory
+
+import (
+	"option-manager/internal/repository"
+	"time"
+)
+
+func NewRepository() *repository.Repository {
+	users := NewUserRepo()
+	sessions := NewSessionRepo()
+	tokens := NewAPITokenRepo()
+	audit := NewAuditRepo()
+	outbox := NewOutboxRepo()
+	suppressions := NewSuppressionRepo()
+
+	repo := &repository.Repository{
+		User:        users,
+		Session:     sessions,
+		Role:        NewRoleRepo(),
+		APIToken:    tokens,
+		Audit:       audit,
+		Outbox:      outbox,
+		Suppression: suppressions,
+	}
+	users.onDelete = []func(userID int){sessions.deleteUser, tokens.deleteUser}
+	repo.Tx = &TxManager{
+		repo:  repo,
+		repos: []snapshotter{users, sessions, tokens, audit, outbox, suppressions},
+	}
+	return repo
+}
+
+// now matches the precision Postgres stores timestamps with, so values
+// round-trip the same way in both implementations
+func now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
@@ -0,0 +1,43 @@
+// internal/repository/memory/role.go
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// errDuplicateKey stands in for a primary key or unique constraint failure
+var errDuplicateKey = errors.New("duplicate key")
+
+// rolePermissions is the role_permissions table as seeded by the migrations
+var rolePermissions = map[string][]string{
+	"user": nil,
+	"support": {
+		"admin.access",
+		"email.read",
+		"users.read",
+		"users.resend_verification",
+	},
+	"admin": {
+		"admin.access",
+		"audit.read",
+		"email.read",
+		"users.disable",
+		"users.read",
+		"users.resend_verification",
+		"users.sessions.revoke",
+	},
+}
+
+type RoleRepo struct{}
+
+func NewRoleRepo() *RoleRepo {
+	return &RoleRepo{}
+}
+
+func (r *RoleRepo) PermissionsForRole(ctx context.Context, role string) ([]string, error) {
+	permissions := append([]string(nil), rolePermissions[role]...)
+	sort.Strings(permissions)
+	return permissions, nil
+}
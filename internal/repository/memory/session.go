@@ -0,0 +1,181 @@
+// internal/repository/memory/session.go
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"option-manager/internal/repository"
+	"sort"
+	"sync"
+	"time"
+)
+
+type SessionRepo struct {
+	mu       sync.Mutex
+	sessions map[string]*repository.Session
+}
+
+func NewSessionRepo() *SessionRepo {
+	return &SessionRepo{
+		sessions: make(map[string]*repository.Session),
+	}
+}
+
+// copySession returns a stored session as Postgres would load it, without
+// the raw token, which is never persisted
+func copySession(session *repository.Session) *repository.Session {
+	c := *session
+	c.Token = ""
+	return &c
+}
+
+func (r *SessionRepo) Create(ctx context.Context, session *repository.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.sessions[session.ID]; ok {
+		return errDuplicateKey
+	}
+
+	created := now()
+	session.LastSeenAt = created
+	session.CreatedAt = created
+	r.sessions[session.ID] = copySession(session)
+	return nil
+}
+
+func (r *SessionRepo) FindByID(ctx context.Context, id string) (*repository.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.sessions[id]
+	if !ok || !session.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+	return copySession(session), nil
+}
+
+func (r *SessionRepo) ListByUserID(ctx context.Context, userID int) ([]*repository.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := time.Now()
+	var sessions []*repository.Session
+	for _, session := range r.sessions {
+		if session.UserID == userID && session.ExpiresAt.After(current) {
+			sessions = append(sessions, copySession(session))
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+func (r *SessionRepo) UpdateActivity(ctx context.Context, id string, lastSeen, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.sessions[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if expiresAt.After(session.AbsoluteExpiresAt) {
+		expiresAt = session.AbsoluteExpiresAt
+	}
+	session.LastSeenAt = lastSeen
+	session.ExpiresAt = expiresAt
+	return nil
+}
+
+func (r *SessionRepo) Rotate(ctx context.Context, oldID, newID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, ok := r.sessions[oldID]
+	if !ok || !session.ExpiresAt.After(time.Now()) {
+		return sql.ErrNoRows
+	}
+	if _, ok := r.sessions[newID]; ok {
+		return errDuplicateKey
+	}
+
+	delete(r.sessions, oldID)
+	session.ID = newID
+	r.sessions[newID] = session
+	return nil
+}
+
+func (r *SessionRepo) Delete(ctx context.Context, id string) error {
+	return r.deleteWhere(func(session *repository.Session) bool {
+		return session.ID == id
+	}, true)
+}
+
+func (r *SessionRepo) DeleteForUser(ctx context.Context, userID int, id string) error {
+	return r.deleteWhere(func(session *repository.Session) bool {
+		return session.ID == id && session.UserID == userID
+	}, true)
+}
+
+func (r *SessionRepo) DeleteByUserID(ctx context.Context, userID int, exceptID string) error {
+	return r.deleteWhere(func(session *repository.Session) bool {
+		return session.UserID == userID && session.ID != exceptID
+	}, false)
+}
+
+func (r *SessionRepo) DeleteExpired(ctx context.Context) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := time.Now()
+	var deleted int64
+	for id, session := range r.sessions {
+		if !session.ExpiresAt.After(current) {
+			delete(r.sessions, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// deleteWhere deletes matching sessions. With mustMatch it returns
+// sql.ErrNoRows when nothing matched.
+func (r *SessionRepo) deleteWhere(match func(*repository.Session) bool, mustMatch bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := 0
+	for id, session := range r.sessions {
+		if match(session) {
+			delete(r.sessions, id)
+			deleted++
+		}
+	}
+	if mustMatch && deleted == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *SessionRepo) CountActive(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := time.Now()
+	count := 0
+	for _, session := range r.sessions {
+		if session.ExpiresAt.After(current) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// deleteUser removes the user's sessions, as the foreign key does when a
+// user is deleted
+func (r *SessionRepo) deleteUser(userID int) {
+	r.deleteWhere(func(session *repository.Session) bool {
+		return session.UserID == userID
+	}, false)
+}
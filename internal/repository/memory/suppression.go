@@ -0,0 +1,68 @@
+// internal/repository/memory/suppression.go
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"option-manager/internal/repository"
+	"strings"
+	"sync"
+)
+
+type SuppressionRepo struct {
+	mu           sync.Mutex
+	suppressions map[string]repository.EmailSuppression
+}
+
+func NewSuppressionRepo() *SuppressionRepo {
+	return &SuppressionRepo{
+		suppressions: make(map[string]repository.EmailSuppression),
+	}
+}
+
+func (r *SuppressionRepo) Add(ctx context.Context, suppression *repository.EmailSuppression) error {
+	// Postgres enforces this with a check constraint
+	if suppression.Reason != repository.SuppressionBounce && suppression.Reason != repository.SuppressionComplaint {
+		return fmt.Errorf("invalid suppression reason %q", suppression.Reason)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	email := strings.ToLower(suppression.Email)
+	updated := now()
+	created := updated
+	if existing, ok := r.suppressions[email]; ok {
+		created = existing.CreatedAt
+	}
+
+	suppression.Email = email
+	suppression.CreatedAt = created
+	suppression.UpdatedAt = updated
+	r.suppressions[email] = *suppression
+	return nil
+}
+
+func (r *SuppressionRepo) Find(ctx context.Context, email string) (*repository.EmailSuppression, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	suppression, ok := r.suppressions[strings.ToLower(email)]
+	if !ok {
+		return nil, nil
+	}
+	return &suppression, nil
+}
+
+func (r *SuppressionRepo) Delete(ctx context.Context, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	email = strings.ToLower(email)
+	if _, ok := r.suppressions[email]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(r.suppressions, email)
+	return nil
+}
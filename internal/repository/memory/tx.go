@@ -0,0 +1,148 @@
+// internal/repository/memory/tx.go
+package memory
+
+import (
+	"context"
+	"option-manager/internal/repository"
+	"sync"
+)
+
+// snapshotter is a repository whose contents can be saved and put back
+type snapshotter interface {
+	// snapshot saves the repository's contents and returns a function that
+	// restores them
+	snapshot() (restore func())
+}
+
+// TxManager runs transactions one at a time, restoring every repository to
+// how it was before if fn fails. There is no isolation: other callers see
+// writes before they commit, and a rollback undoes their writes too.
+type TxManager struct {
+	mu    sync.Mutex
+	repo  *repository.Repository
+	repos []snapshotter
+}
+
+type txKey struct {
+	m *TxManager
+}
+
+func (m *TxManager) WithinTx(ctx context.Context, fn func(ctx context.Context, tx *repository.Repository) error) error {
+	if tx, ok := ctx.Value(txKey{m}).(*repository.Repository); ok {
+		return fn(ctx, tx)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	restores := make([]func(), len(m.repos))
+	for i, repo := range m.repos {
+		restores[i] = repo.snapshot()
+	}
+	rollback := func() {
+		for _, restore := range restores {
+			restore()
+		}
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, txKey{m}, m.repo), m.repo); err != nil {
+		rollback()
+		return err
+	}
+	return nil
+}
+
+func (r *UserRepo) snapshot() func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved := make(map[int]*repository.User, len(r.users))
+	for id, user := range r.users {
+		saved[id] = copyUser(user)
+	}
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.users = saved
+	}
+}
+
+func (r *SessionRepo) snapshot() func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved := make(map[string]*repository.Session, len(r.sessions))
+	for id, session := range r.sessions {
+		saved[id] = copySession(session)
+	}
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.sessions = saved
+	}
+}
+
+func (r *APITokenRepo) snapshot() func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved := make(map[int]*repository.APIToken, len(r.tokens))
+	for id, token := range r.tokens {
+		saved[id] = copyAPIToken(token)
+	}
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.tokens = saved
+	}
+}
+
+func (r *OutboxRepo) snapshot() func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved := make(map[int64]*repository.OutboxMessage, len(r.messages))
+	for id, msg := range r.messages {
+		saved[id] = copyOutboxMessage(msg)
+	}
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.messages = saved
+	}
+}
+
+func (r *SuppressionRepo) snapshot() func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved := make(map[string]repository.EmailSuppression, len(r.suppressions))
+	for email, suppression := range r.suppressions {
+		saved[email] = suppression
+	}
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.suppressions = saved
+	}
+}
+
+// Events are never changed in place, only appended or replaced, so saving
+// the pointers is enough
+func (r *AuditRepo) snapshot() func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved := append([]*repository.AuditEvent(nil), r.events...)
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.events = saved
+	}
+}
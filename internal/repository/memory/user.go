@@ -0,0 +1,334 @@
+// internal/repository/memory/user.go
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"option-manager/internal/repository"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type UserRepo struct {
+	mu     sync.Mutex
+	users  map[int]*repository.User
+	nextID int
+	// onDelete stands in for the foreign keys that cascade when a user is
+	// deleted
+	onDelete []func(userID int)
+}
+
+func NewUserRepo() *UserRepo {
+	return &UserRepo{
+		users:  make(map[int]*repository.User),
+		nextID: 1,
+	}
+}
+
+// copyUser returns a copy that shares nothing with the stored user
+func copyUser(user *repository.User) *repository.User {
+	c := *user
+	if user.VerificationToken != nil {
+		token := *user.VerificationToken
+		c.VerificationToken = &token
+	}
+	if user.VerificationExpiry != nil {
+		expiry := *user.VerificationExpiry
+		c.VerificationExpiry = &expiry
+	}
+	c.PendingEmail = copyString(user.PendingEmail)
+	c.EmailChangeToken = copyString(user.EmailChangeToken)
+	c.EmailCancelToken = copyString(user.EmailCancelToken)
+	if user.EmailChangeExpiry != nil {
+		expiry := *user.EmailChangeExpiry
+		c.EmailChangeExpiry = &expiry
+	}
+	if user.DeletionScheduledAt != nil {
+		scheduledAt := *user.DeletionScheduledAt
+		c.DeletionScheduledAt = &scheduledAt
+	}
+	if user.DisabledAt != nil {
+		disabledAt := *user.DisabledAt
+		c.DisabledAt = &disabledAt
+	}
+	return &c
+}
+
+func (r *UserRepo) Create(ctx context.Context, user *repository.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.users {
+		if existing.Email == user.Email {
+			return repository.ErrDuplicateEmail
+		}
+	}
+
+	created := now()
+	user.ID = r.nextID
+	user.Role = "user"
+	user.DisabledAt = nil
+	user.CreatedAt = created
+	user.UpdatedAt = created
+	r.nextID++
+
+	r.users[user.ID] = copyUser(user)
+	return nil
+}
+
+func (r *UserRepo) FindByID(ctx context.Context, id int) (*repository.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, nil
+	}
+	return copyUser(user), nil
+}
+
+func (r *UserRepo) FindByEmail(ctx context.Context, email string) (*repository.User, error) {
+	return r.findBy(func(user *repository.User) bool { return user.Email == email }), nil
+}
+
+func (r *UserRepo) FindByVerificationToken(ctx context.Context, token string) (*repository.User, error) {
+	return r.findBy(func(user *repository.User) bool {
+		return user.VerificationToken != nil && *user.VerificationToken == token
+	}), nil
+}
+
+func (r *UserRepo) findBy(match func(*repository.User) bool) *repository.User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if match(user) {
+			return copyUser(user)
+		}
+	}
+	return nil
+}
+
+// update applies fn to the stored user and bumps updated_at, or returns
+// sql.ErrNoRows like the Postgres repository
+func (r *UserRepo) update(userID int, fn func(user *repository.User)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	fn(user)
+	user.UpdatedAt = now()
+	return nil
+}
+
+func (r *UserRepo) UpdateVerificationStatus(ctx context.Context, userID int, verified bool) error {
+	return r.update(userID, func(user *repository.User) {
+		user.EmailVerified = verified
+		user.VerificationToken = nil
+		user.VerificationExpiry = nil
+	})
+}
+
+func (r *UserRepo) SetVerificationToken(ctx context.Context, userID int, token string, expiry time.Time) error {
+	return r.update(userID, func(user *repository.User) {
+		user.VerificationToken = &token
+		user.VerificationExpiry = &expiry
+	})
+}
+
+func (r *UserRepo) UpdatePasswordHash(ctx context.Context, userID int, passwordHash string) error {
+	return r.update(userID, func(user *repository.User) {
+		user.PasswordHash = passwordHash
+	})
+}
+
+func (r *UserRepo) List(ctx context.Context, filter repository.UserFilter) ([]*repository.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	query := strings.ToLower(filter.Query)
+	matches := func(user *repository.User) bool {
+		if user.ID <= filter.AfterID {
+			return false
+		}
+		if filter.Role != "" && user.Role != filter.Role {
+			return false
+		}
+		if query == "" {
+			return true
+		}
+		return strings.Contains(strings.ToLower(user.Email), query) ||
+			strings.Contains(strings.ToLower(user.FirstName), query) ||
+			strings.Contains(strings.ToLower(user.LastName), query)
+	}
+
+	var users []*repository.User
+	for _, user := range r.users {
+		if matches(user) {
+			users = append(users, copyUser(user))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	if len(users) > filter.Limit {
+		users = users[:max(filter.Limit, 0)]
+	}
+	return users, nil
+}
+
+func (r *UserRepo) SetDisabled(ctx context.Context, userID int, disabled bool) error {
+	return r.update(userID, func(user *repository.User) {
+		switch {
+		case !disabled:
+			user.DisabledAt = nil
+		case user.DisabledAt == nil:
+			disabledAt := now()
+			user.DisabledAt = &disabledAt
+		}
+	})
+}
+
+func (r *UserRepo) SetRole(ctx context.Context, userID int, role string) error {
+	// users.role references the roles table
+	if _, ok := rolePermissions[role]; !ok {
+		return fmt.Errorf("role %q does not exist", role)
+	}
+	return r.update(userID, func(user *repository.User) {
+		user.Role = role
+	})
+}
+
+func (r *UserRepo) UpdateProfile(ctx context.Context, userID int, firstName, lastName string, expectedUpdatedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userID]
+	if !ok || !user.UpdatedAt.Equal(expectedUpdatedAt) {
+		return sql.ErrNoRows
+	}
+	user.FirstName = firstName
+	user.LastName = lastName
+	user.UpdatedAt = now()
+	return nil
+}
+
+func copyString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	return &c
+}
+
+func (r *UserRepo) FindByEmailChangeToken(ctx context.Context, token string) (*repository.User, error) {
+	return r.findBy(func(user *repository.User) bool {
+		return user.EmailChangeToken != nil && *user.EmailChangeToken == token
+	}), nil
+}
+
+func (r *UserRepo) FindByEmailCancelToken(ctx context.Context, token string) (*repository.User, error) {
+	return r.findBy(func(user *repository.User) bool {
+		return user.EmailCancelToken != nil && *user.EmailCancelToken == token
+	}), nil
+}
+
+func (r *UserRepo) SetPendingEmail(ctx context.Context, userID int, email, token, cancelToken string, expiry time.Time) error {
+	return r.update(userID, func(user *repository.User) {
+		user.PendingEmail = &email
+		user.EmailChangeToken = &token
+		user.EmailCancelToken = &cancelToken
+		user.EmailChangeExpiry = &expiry
+	})
+}
+
+func (r *UserRepo) ConfirmPendingEmail(ctx context.Context, userID int, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userID]
+	if !ok || user.PendingEmail == nil || user.EmailChangeToken == nil || *user.EmailChangeToken != token {
+		return sql.ErrNoRows
+	}
+	if user.EmailChangeExpiry == nil || !user.EmailChangeExpiry.After(now()) {
+		return sql.ErrNoRows
+	}
+	for _, existing := range r.users {
+		if existing.ID != userID && existing.Email == *user.PendingEmail {
+			return repository.ErrDuplicateEmail
+		}
+	}
+
+	user.Email = *user.PendingEmail
+	user.EmailVerified = true
+	user.VerificationToken = nil
+	user.VerificationExpiry = nil
+	clearPendingEmail(user)
+	user.UpdatedAt = now()
+	return nil
+}
+
+func (r *UserRepo) ClearPendingEmail(ctx context.Context, userID int) error {
+	return r.update(userID, clearPendingEmail)
+}
+
+func clearPendingEmail(user *repository.User) {
+	user.PendingEmail = nil
+	user.EmailChangeToken = nil
+	user.EmailCancelToken = nil
+	user.EmailChangeExpiry = nil
+}
+
+func (r *UserRepo) ScheduleDeletion(ctx context.Context, userID int, at *time.Time) error {
+	return r.update(userID, func(user *repository.User) {
+		if at == nil {
+			user.DeletionScheduledAt = nil
+			return
+		}
+		scheduledAt := at.Truncate(time.Microsecond)
+		user.DeletionScheduledAt = &scheduledAt
+	})
+}
+
+func (r *UserRepo) ListDueForDeletion(ctx context.Context, before time.Time, limit int) ([]*repository.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var users []*repository.User
+	for _, user := range r.users {
+		if user.DeletionScheduledAt != nil && !user.DeletionScheduledAt.After(before) {
+			users = append(users, copyUser(user))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].DeletionScheduledAt.Equal(*users[j].DeletionScheduledAt) {
+			return users[i].DeletionScheduledAt.Before(*users[j].DeletionScheduledAt)
+		}
+		return users[i].ID < users[j].ID
+	})
+
+	if len(users) > limit {
+		users = users[:max(limit, 0)]
+	}
+	return users, nil
+}
+
+func (r *UserRepo) Delete(ctx context.Context, userID int) error {
+	r.mu.Lock()
+	if _, ok := r.users[userID]; !ok {
+		r.mu.Unlock()
+		return sql.ErrNoRows
+	}
+	delete(r.users, userID)
+	r.mu.Unlock()
+
+	for _, cascade := range r.onDelete {
+		cascade(userID)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+// internal/repository/postgres/api_token.go
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"option-manager/internal/repository"
+	"strings"
+	"time"
+)
+
+const apiTokenColumns = `
+            id, user_id, name, token_hash, scopes,
+            expires_at, last_used_at, created_at`
+
+func scanAPIToken(row rowScanner) (*repository.APIToken, error) {
+	token := &repository.APIToken{}
+	var scopes string
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Name,
+		&token.TokenHash,
+		&scopes,
+		&token.ExpiresAt,
+		&token.LastUsedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	token.Scopes = strings.Fields(scopes)
+	return token, nil
+}
+
+type APITokenRepo struct {
+	db dbtx
+}
+
+func NewAPITokenRepo(db *sql.DB) *APITokenRepo {
+	return &APITokenRepo{db: traced(db)}
+}
+
+func (r *APITokenRepo) Create(ctx context.Context, token *repository.APIToken) error {
+	query := `
+        INSERT INTO api_tokens (user_id, name, token_hash, scopes, expires_at)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id, created_at`
+
+	return r.db.QueryRowContext(
+		ctx,
+		query,
+		token.UserID,
+		token.Name,
+		token.TokenHash,
+		strings.Join(token.Scopes, " "),
+		token.ExpiresAt,
+	).Scan(&token.ID, &token.CreatedAt)
+}
+
+func (r *APITokenRepo) FindByHash(ctx context.Context, tokenHash string) (*repository.APIToken, error) {
+	query := `
+        SELECT` + apiTokenColumns + `
+        FROM api_tokens
+        WHERE token_hash = $1
+          AND (expires_at IS NULL OR expires_at > NOW())`
+
+	token, err := scanAPIToken(r.db.QueryRowContext(ctx, query, tokenHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (r *APITokenRepo) ListByUserID(ctx context.Context, userID int) ([]*repository.APIToken, error) {
+	query := `
+        SELECT` + apiTokenColumns + `
+        FROM api_tokens
+        WHERE user_id = $1
+        ORDER BY created_at DESC, id DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*repository.APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (r *APITokenRepo) UpdateLastUsed(ctx context.Context, id int, lastUsed time.Time) error {
+	query := `UPDATE api_tokens SET last_used_at = $2 WHERE id = $1`
+
+	result, err := r.db.ExecContext(ctx, query, id, lastUsed)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *APITokenRepo) DeleteForUser(ctx context.Context, userID int, id int) error {
+	query := `DELETE FROM api_tokens WHERE id = $1 AND user_id = $2`
+
+	result, err := r.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
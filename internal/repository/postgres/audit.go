@@ -0,0 +1,166 @@
+// internal/repository/postgres/audit.go
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"option-manager/internal/repository"
+)
+
+const auditEventColumns = `
+            id, event_type, actor_user_id, subject_user_id, ip_address,
+            user_agent, request_id, before_state, after_state, metadata,
+            created_at`
+
+func scanAuditEvent(row rowScanner) (*repository.AuditEvent, error) {
+	event := &repository.AuditEvent{}
+	var actorUserID, subjectUserID sql.NullInt64
+	var before, after, metadata []byte
+	err := row.Scan(
+		&event.ID,
+		&event.Type,
+		&actorUserID,
+		&subjectUserID,
+		&event.IPAddress,
+		&event.UserAgent,
+		&event.RequestID,
+		&before,
+		&after,
+		&metadata,
+		&event.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if actorUserID.Valid {
+		id := int(actorUserID.Int64)
+		event.ActorUserID = &id
+	}
+	if subjectUserID.Valid {
+		id := int(subjectUserID.Int64)
+		event.SubjectUserID = &id
+	}
+	if before != nil {
+		event.Before = json.RawMessage(before)
+	}
+	if after != nil {
+		event.After = json.RawMessage(after)
+	}
+	if err := json.Unmarshal(metadata, &event.Metadata); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+type AuditRepo struct {
+	db dbtx
+}
+
+func NewAuditRepo(db *sql.DB) *AuditRepo {
+	return &AuditRepo{db: traced(db)}
+}
+
+func (r *AuditRepo) Create(ctx context.Context, event *repository.AuditEvent) error {
+	query := `
+        INSERT INTO audit_events (
+            event_type, actor_user_id, subject_user_id, ip_address,
+            user_agent, request_id, before_state, after_state, metadata
+        )
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        RETURNING id, created_at`
+
+	metadata := event.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	return r.db.QueryRowContext(
+		ctx,
+		query,
+		event.Type,
+		event.ActorUserID,
+		event.SubjectUserID,
+		event.IPAddress,
+		event.UserAgent,
+		event.RequestID,
+		nullableJSON(event.Before),
+		nullableJSON(event.After),
+		metadataJSON,
+	).Scan(&event.ID, &event.CreatedAt)
+}
+
+func (r *AuditRepo) List(ctx context.Context, filter repository.AuditFilter) ([]*repository.AuditEvent, error) {
+	query := `
+        SELECT` + auditEventColumns + `
+        FROM audit_events
+        WHERE ($1 = 0 OR actor_user_id = $1 OR subject_user_id = $1)
+          AND ($2 = '' OR event_type LIKE $2 || '%' ESCAPE '\')
+          AND ($3 = '' OR request_id = $3)
+          AND ($4::timestamptz IS NULL OR created_at >= $4)
+          AND ($5::timestamptz IS NULL OR created_at < $5)
+          AND ($6::bigint = 0 OR id < $6)
+          AND ($8::int = 0 OR subject_user_id = $8)
+        ORDER BY id DESC
+        LIMIT $7`
+
+	rows, err := r.db.QueryContext(
+		ctx,
+		query,
+		filter.UserID,
+		likeEscaper.Replace(filter.Type),
+		filter.RequestID,
+		filter.Since,
+		filter.Until,
+		filter.BeforeID,
+		filter.Limit,
+		filter.SubjectUserID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*repository.AuditEvent
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// nullableJSON stores an empty snapshot as NULL rather than invalid JSON
+func nullableJSON(raw json.RawMessage) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	return []byte(raw)
+}
+
+func (r *AuditRepo) RedactUser(ctx context.Context, userID int) (int64, error) {
+	// The append-only trigger lets exactly this update through. Snapshots of
+	// another user's account, changed by this one, are that user's data and
+	// are kept.
+	query := `
+        UPDATE audit_events
+        SET ip_address = '',
+            user_agent = '',
+            before_state = CASE WHEN subject_user_id = $1 THEN NULL ELSE before_state END,
+            after_state = CASE WHEN subject_user_id = $1 THEN NULL ELSE after_state END,
+            metadata = CASE WHEN subject_user_id = $1 THEN '{}'::jsonb ELSE metadata END
+        WHERE subject_user_id = $1 OR actor_user_id = $1`
+
+	result, err := r.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,44 @@
+package postgres_test
+
+import (
+	"database/sql"
+	"option-manager/internal/config"
+	"option-manager/internal/database"
+	"option-manager/internal/repository"
+	"option-manager/internal/repository/postgres"
+	"option-manager/internal/repository/repotest"
+	"os"
+	"testing"
+
+	_ "github.com/lib/pq"
+)
+
+// TestContract runs the repository contract tests against the database in
+// TEST_DATABASE_URL, migrating it first. The tests only add rows, so any
+// development database will do.
+func TestContract(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	migrator, err := database.NewMigrator(config.DriverPostgres, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = migrator.Up()
+	migrator.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repotest.Run(t, func(t *testing.T) *repository.Repository {
+		return postgres.NewRepository(db)
+	})
+}
@@ -7,8 +7,21 @@ import (
 )
 
 func NewRepository(db *sql.DB) *repository.Repository {
+	return newRepository(db, NewTxManager(db))
+}
+
+// newRepository builds the repositories on db, which is either the pool or
+// a transaction
+func newRepository(db dbtx, tx repository.TxManager) *repository.Repository {
+	t := traced(db)
 	return &repository.Repository{
-		User:    NewUserRepo(db),
-		Session: NewSessionRepo(db),
+		User:        &UserRepo{db: t},
+		Session:     &SessionRepo{db: t},
+		Role:        &RoleRepo{db: t},
+		APIToken:    &APITokenRepo{db: t},
+		Audit:       &AuditRepo{db: t},
+		Outbox:      &OutboxRepo{db: t},
+		Suppression: &SuppressionRepo{db: t},
+		Tx:          tx,
 	}
 }
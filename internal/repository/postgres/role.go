@@ -0,0 +1,39 @@
+// internal/repository/postgres/role.go
+package postgres
+
+import (
+	"context"
+	"database/sql"
+)
+
+type RoleRepo struct {
+	db dbtx
+}
+
+func NewRoleRepo(db *sql.DB) *RoleRepo {
+	return &RoleRepo{db: traced(db)}
+}
+
+func (r *RoleRepo) PermissionsForRole(ctx context.Context, role string) ([]string, error) {
+	query := `
+        SELECT permission_name
+        FROM role_permissions
+        WHERE role_name = $1
+        ORDER BY permission_name`
+
+	rows, err := r.db.QueryContext(ctx, query, role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []string
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
@@ -5,29 +5,64 @@ import (
 	"context"
 	"database/sql"
 	"option-manager/internal/repository"
+	"time"
 )
 
+const sessionColumns = `
+            id, user_id, ip_address, user_agent, remember_me,
+            expires_at, absolute_expires_at, last_seen_at, created_at`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSession(row rowScanner) (*repository.Session, error) {
+	session := &repository.Session{}
+	err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.IPAddress,
+		&session.UserAgent,
+		&session.RememberMe,
+		&session.ExpiresAt,
+		&session.AbsoluteExpiresAt,
+		&session.LastSeenAt,
+		&session.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
 type SessionRepo struct {
-	db *sql.DB
+	db dbtx
 }
 
 func NewSessionRepo(db *sql.DB) *SessionRepo {
-	return &SessionRepo{db: db}
+	return &SessionRepo{db: traced(db)}
 }
 
 func (r *SessionRepo) Create(ctx context.Context, session *repository.Session) error {
 	query := `
-        INSERT INTO sessions (id, user_id, expires_at)
-        VALUES ($1, $2, $3)
-        RETURNING created_at`
+        INSERT INTO sessions (
+            id, user_id, ip_address, user_agent, remember_me,
+            expires_at, absolute_expires_at
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING last_seen_at, created_at`
 
 	return r.db.QueryRowContext(
 		ctx,
 		query,
 		session.ID,
 		session.UserID,
+		session.IPAddress,
+		session.UserAgent,
+		session.RememberMe,
 		session.ExpiresAt,
-	).Scan(&session.CreatedAt)
+		session.AbsoluteExpiresAt,
+	).Scan(&session.LastSeenAt, &session.CreatedAt)
 }
 
 func (r *SessionRepo) Delete(ctx context.Context, id string) error {
@@ -49,27 +84,99 @@ func (r *SessionRepo) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
-func (r *SessionRepo) DeleteExpired(ctx context.Context) error {
-	query := `DELETE FROM sessions WHERE expires_at <= NOW()`
+func (r *SessionRepo) DeleteForUser(ctx context.Context, userID int, id string) error {
+	query := `DELETE FROM sessions WHERE id = $1 AND user_id = $2`
+
+	result, err := r.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *SessionRepo) DeleteByUserID(ctx context.Context, userID int, exceptID string) error {
+	query := `DELETE FROM sessions WHERE user_id = $1 AND id <> $2`
 
-	_, err := r.db.ExecContext(ctx, query)
+	_, err := r.db.ExecContext(ctx, query, userID, exceptID)
 	return err
 }
 
+func (r *SessionRepo) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `DELETE FROM sessions WHERE expires_at <= NOW()`
+
+	result, err := r.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *SessionRepo) CountActive(ctx context.Context) (int, error) {
+	query := `SELECT COUNT(*) FROM sessions WHERE expires_at > NOW()`
+
+	var count int
+	err := r.db.QueryRowContext(ctx, query).Scan(&count)
+	return count, err
+}
+
+func (r *SessionRepo) UpdateActivity(ctx context.Context, id string, lastSeen, expiresAt time.Time) error {
+	query := `
+        UPDATE sessions
+        SET last_seen_at = $2,
+            expires_at = LEAST($3, absolute_expires_at)
+        WHERE id = $1`
+
+	result, err := r.db.ExecContext(ctx, query, id, lastSeen, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *SessionRepo) Rotate(ctx context.Context, oldID, newID string) error {
+	query := `UPDATE sessions SET id = $2 WHERE id = $1 AND expires_at > NOW()`
+
+	result, err := r.db.ExecContext(ctx, query, oldID, newID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
 func (r *SessionRepo) FindByID(ctx context.Context, id string) (*repository.Session, error) {
-	session := &repository.Session{}
 	query := `
-        SELECT id, user_id, expires_at, created_at
+        SELECT` + sessionColumns + `
         FROM sessions
         WHERE id = $1 AND expires_at > NOW()`
 
-	err := r.db.QueryRowContext(ctx, query, id).Scan(
-		&session.ID,
-		&session.UserID,
-		&session.ExpiresAt,
-		&session.CreatedAt,
-	)
-
+	session, err := scanSession(r.db.QueryRowContext(ctx, query, id))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -78,3 +185,27 @@ func (r *SessionRepo) FindByID(ctx context.Context, id string) (*repository.Sess
 	}
 	return session, nil
 }
+
+func (r *SessionRepo) ListByUserID(ctx context.Context, userID int) ([]*repository.Session, error) {
+	query := `
+        SELECT` + sessionColumns + `
+        FROM sessions
+        WHERE user_id = $1 AND expires_at > NOW()
+        ORDER BY last_seen_at DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*repository.Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
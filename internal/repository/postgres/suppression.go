@@ -0,0 +1,78 @@
+// internal/repository/postgres/suppression.go
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"option-manager/internal/repository"
+	"strings"
+)
+
+type SuppressionRepo struct {
+	db dbtx
+}
+
+func NewSuppressionRepo(db *sql.DB) *SuppressionRepo {
+	return &SuppressionRepo{db: traced(db)}
+}
+
+func (r *SuppressionRepo) Add(ctx context.Context, suppression *repository.EmailSuppression) error {
+	query := `
+        INSERT INTO email_suppressions (email, reason, detail)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (email) DO UPDATE
+        SET reason = EXCLUDED.reason,
+            detail = EXCLUDED.detail,
+            updated_at = NOW()
+        RETURNING email, created_at, updated_at`
+
+	return r.db.QueryRowContext(
+		ctx,
+		query,
+		strings.ToLower(suppression.Email),
+		suppression.Reason,
+		suppression.Detail,
+	).Scan(&suppression.Email, &suppression.CreatedAt, &suppression.UpdatedAt)
+}
+
+func (r *SuppressionRepo) Find(ctx context.Context, email string) (*repository.EmailSuppression, error) {
+	query := `
+        SELECT email, reason, detail, created_at, updated_at
+        FROM email_suppressions
+        WHERE email = $1`
+
+	suppression := &repository.EmailSuppression{}
+	err := r.db.QueryRowContext(ctx, query, strings.ToLower(email)).Scan(
+		&suppression.Email,
+		&suppression.Reason,
+		&suppression.Detail,
+		&suppression.CreatedAt,
+		&suppression.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return suppression, nil
+}
+
+func (r *SuppressionRepo) Delete(ctx context.Context, email string) error {
+	query := `DELETE FROM email_suppressions WHERE email = $1`
+
+	result, err := r.db.ExecContext(ctx, query, strings.ToLower(email))
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+// internal/repository/postgres/tx.go
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"option-manager/internal/repository"
+	"option-manager/internal/tracing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxTxAttempts bounds how often a transaction that failed to serialize is
+// retried
+const maxTxAttempts = 3
+
+// Postgres error codes for transactions that lost a race with another and
+// can succeed if run again
+const (
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+)
+
+// TxManager runs transactions at Postgres's default isolation level, read
+// committed
+type TxManager struct {
+	db *sql.DB
+}
+
+func NewTxManager(db *sql.DB) *TxManager {
+	return &TxManager{db: db}
+}
+
+// txKey marks a context as inside one of m's transactions. It holds the
+// manager so that a transaction on another database isn't joined by mistake.
+type txKey struct {
+	m *TxManager
+}
+
+func (m *TxManager) WithinTx(ctx context.Context, fn func(ctx context.Context, tx *repository.Repository) error) error {
+	if tx, ok := ctx.Value(txKey{m}).(*repository.Repository); ok {
+		return fn(ctx, tx)
+	}
+
+	ctx, span := tracing.Start(ctx, "postgres transaction")
+	defer span.End()
+
+	var err error
+	for attempt := 1; attempt <= maxTxAttempts; attempt++ {
+		err = m.run(ctx, fn)
+		if !retryable(err) || attempt == maxTxAttempts {
+			break
+		}
+
+		slog.WarnContext(ctx, "retrying transaction", "attempt", attempt, "error", err)
+		select {
+		case <-time.After(time.Duration(attempt) * 10 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (m *TxManager) run(ctx context.Context, fn func(ctx context.Context, tx *repository.Repository) error) error {
+	sqlTx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			sqlTx.Rollback()
+			panic(p)
+		}
+	}()
+
+	tx := newRepository(sqlTx, m)
+	if err := fn(context.WithValue(ctx, txKey{m}, tx), tx); err != nil {
+		sqlTx.Rollback()
+		return err
+	}
+	return sqlTx.Commit()
+}
+
+func retryable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && (pqErr.Code == serializationFailure || pqErr.Code == deadlockDetected)
+}
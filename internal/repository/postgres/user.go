@@ -4,27 +4,67 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"option-manager/internal/repository"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
+// uniqueViolation is Postgres's error code for a unique constraint failure
+const uniqueViolation = "23505"
+
+const userColumns = `
+            id, email, password_hash, first_name, last_name,
+            email_verified, verification_token, verification_expires_at,
+            pending_email, email_change_token, email_cancel_token, email_change_expires_at,
+            deletion_scheduled_at, role, disabled_at, created_at, updated_at`
+
+func scanUser(row rowScanner) (*repository.User, error) {
+	user := &repository.User{}
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.PasswordHash,
+		&user.FirstName,
+		&user.LastName,
+		&user.EmailVerified,
+		&user.VerificationToken,
+		&user.VerificationExpiry,
+		&user.PendingEmail,
+		&user.EmailChangeToken,
+		&user.EmailCancelToken,
+		&user.EmailChangeExpiry,
+		&user.DeletionScheduledAt,
+		&user.Role,
+		&user.DisabledAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
 type UserRepo struct {
-	db *sql.DB
+	db dbtx
 }
 
 func NewUserRepo(db *sql.DB) *UserRepo {
-	return &UserRepo{db: db}
+	return &UserRepo{db: traced(db)}
 }
 
 func (r *UserRepo) Create(ctx context.Context, user *repository.User) error {
 	query := `
         INSERT INTO users (
-            email, password_hash, first_name, last_name, 
+            email, password_hash, first_name, last_name,
             email_verified, verification_token, verification_expires_at
         ) VALUES ($1, $2, $3, $4, $5, $6, $7)
-        RETURNING id, created_at, updated_at`
+        RETURNING id, role, created_at, updated_at`
 
-	return r.db.QueryRowContext(
+	err := r.db.QueryRowContext(
 		ctx,
 		query,
 		user.Email,
@@ -34,32 +74,22 @@ func (r *UserRepo) Create(ctx context.Context, user *repository.User) error {
 		user.EmailVerified,
 		user.VerificationToken,
 		user.VerificationExpiry,
-	).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	).Scan(&user.ID, &user.Role, &user.CreatedAt, &user.UpdatedAt)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return repository.ErrDuplicateEmail
+	}
+	return err
 }
 
 func (r *UserRepo) FindByID(ctx context.Context, id int) (*repository.User, error) {
-	user := &repository.User{}
 	query := `
-        SELECT 
-            id, email, password_hash, first_name, last_name,
-            email_verified, verification_token, verification_expires_at,
-            created_at, updated_at
-        FROM users 
+        SELECT` + userColumns + `
+        FROM users
         WHERE id = $1`
 
-	err := r.db.QueryRowContext(ctx, query, id).Scan(
-		&user.ID,
-		&user.Email,
-		&user.PasswordHash,
-		&user.FirstName,
-		&user.LastName,
-		&user.EmailVerified,
-		&user.VerificationToken,
-		&user.VerificationExpiry,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
-
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, id))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -70,28 +100,12 @@ func (r *UserRepo) FindByID(ctx context.Context, id int) (*repository.User, erro
 }
 
 func (r *UserRepo) FindByVerificationToken(ctx context.Context, token string) (*repository.User, error) {
-	user := &repository.User{}
 	query := `
-        SELECT 
-            id, email, password_hash, first_name, last_name,
-            email_verified, verification_token, verification_expires_at,
-            created_at, updated_at
-        FROM users 
+        SELECT` + userColumns + `
+        FROM users
         WHERE verification_token = $1`
 
-	err := r.db.QueryRowContext(ctx, query, token).Scan(
-		&user.ID,
-		&user.Email,
-		&user.PasswordHash,
-		&user.FirstName,
-		&user.LastName,
-		&user.EmailVerified,
-		&user.VerificationToken,
-		&user.VerificationExpiry,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
-
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, token))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -103,7 +117,7 @@ func (r *UserRepo) FindByVerificationToken(ctx context.Context, token string) (*
 
 func (r *UserRepo) UpdateVerificationStatus(ctx context.Context, userID int, verified bool) error {
 	query := `
-        UPDATE users 
+        UPDATE users
         SET email_verified = $2,
             verification_token = NULL,
             verification_expires_at = NULL,
@@ -128,7 +142,7 @@ func (r *UserRepo) UpdateVerificationStatus(ctx context.Context, userID int, ver
 
 func (r *UserRepo) SetVerificationToken(ctx context.Context, userID int, token string, expiry time.Time) error {
 	query := `
-        UPDATE users 
+        UPDATE users
         SET verification_token = $2,
             verification_expires_at = $3,
             updated_at = NOW()
@@ -150,29 +164,157 @@ func (r *UserRepo) SetVerificationToken(ctx context.Context, userID int, token s
 	return nil
 }
 
+func (r *UserRepo) UpdatePasswordHash(ctx context.Context, userID int, passwordHash string) error {
+	query := `
+        UPDATE users
+        SET password_hash = $2,
+            updated_at = NOW()
+        WHERE id = $1`
+
+	result, err := r.db.ExecContext(ctx, query, userID, passwordHash)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
 func (r *UserRepo) FindByEmail(ctx context.Context, email string) (*repository.User, error) {
-	user := &repository.User{}
 	query := `
-        SELECT 
-            id, email, password_hash, first_name, last_name,
-            email_verified, verification_token, verification_expires_at,
-            created_at, updated_at
-        FROM users 
+        SELECT` + userColumns + `
+        FROM users
         WHERE email = $1`
 
-	err := r.db.QueryRowContext(ctx, query, email).Scan(
-		&user.ID,
-		&user.Email,
-		&user.PasswordHash,
-		&user.FirstName,
-		&user.LastName,
-		&user.EmailVerified,
-		&user.VerificationToken,
-		&user.VerificationExpiry,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, email))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// likeEscaper escapes LIKE wildcards, so a search for "50%" or "a_b" only
+// matches that text
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
 
+func (r *UserRepo) List(ctx context.Context, filter repository.UserFilter) ([]*repository.User, error) {
+	query := `
+        SELECT` + userColumns + `
+        FROM users
+        WHERE id > $1
+          AND ($2 = ''
+               OR email ILIKE '%' || $2 || '%' ESCAPE '\'
+               OR first_name ILIKE '%' || $2 || '%' ESCAPE '\'
+               OR last_name ILIKE '%' || $2 || '%' ESCAPE '\')
+          AND ($4 = '' OR role = $4)
+        ORDER BY id
+        LIMIT $3`
+
+	rows, err := r.db.QueryContext(ctx, query, filter.AfterID, likeEscaper.Replace(filter.Query), filter.Limit, filter.Role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*repository.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (r *UserRepo) SetDisabled(ctx context.Context, userID int, disabled bool) error {
+	query := `
+        UPDATE users
+        SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, NOW()) ELSE NULL END,
+            updated_at = NOW()
+        WHERE id = $1`
+
+	result, err := r.db.ExecContext(ctx, query, userID, disabled)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *UserRepo) SetRole(ctx context.Context, userID int, role string) error {
+	query := `
+        UPDATE users
+        SET role = $2,
+            updated_at = NOW()
+        WHERE id = $1`
+
+	result, err := r.db.ExecContext(ctx, query, userID, role)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *UserRepo) UpdateProfile(ctx context.Context, userID int, firstName, lastName string, expectedUpdatedAt time.Time) error {
+	query := `
+        UPDATE users
+        SET first_name = $2,
+            last_name = $3,
+            updated_at = NOW()
+        WHERE id = $1
+          AND updated_at = $4`
+
+	result, err := r.db.ExecContext(ctx, query, userID, firstName, lastName, expectedUpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *UserRepo) FindByEmailChangeToken(ctx context.Context, token string) (*repository.User, error) {
+	query := `
+        SELECT` + userColumns + `
+        FROM users
+        WHERE email_change_token = $1`
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, token))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -181,3 +323,176 @@ func (r *UserRepo) FindByEmail(ctx context.Context, email string) (*repository.U
 	}
 	return user, nil
 }
+
+func (r *UserRepo) FindByEmailCancelToken(ctx context.Context, token string) (*repository.User, error) {
+	query := `
+        SELECT` + userColumns + `
+        FROM users
+        WHERE email_cancel_token = $1`
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, token))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (r *UserRepo) SetPendingEmail(ctx context.Context, userID int, email, token, cancelToken string, expiry time.Time) error {
+	query := `
+        UPDATE users
+        SET pending_email = $2,
+            email_change_token = $3,
+            email_cancel_token = $4,
+            email_change_expires_at = $5,
+            updated_at = NOW()
+        WHERE id = $1`
+
+	result, err := r.db.ExecContext(ctx, query, userID, email, token, cancelToken, expiry)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *UserRepo) ConfirmPendingEmail(ctx context.Context, userID int, token string) error {
+	// Proving they own the new address also verifies the account
+	query := `
+        UPDATE users
+        SET email = pending_email,
+            email_verified = TRUE,
+            verification_token = NULL,
+            verification_expires_at = NULL,
+            pending_email = NULL,
+            email_change_token = NULL,
+            email_cancel_token = NULL,
+            email_change_expires_at = NULL,
+            updated_at = NOW()
+        WHERE id = $1
+          AND pending_email IS NOT NULL
+          AND email_change_token = $2
+          AND email_change_expires_at > NOW()`
+
+	result, err := r.db.ExecContext(ctx, query, userID, token)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return repository.ErrDuplicateEmail
+	}
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *UserRepo) ClearPendingEmail(ctx context.Context, userID int) error {
+	query := `
+        UPDATE users
+        SET pending_email = NULL,
+            email_change_token = NULL,
+            email_cancel_token = NULL,
+            email_change_expires_at = NULL,
+            updated_at = NOW()
+        WHERE id = $1`
+
+	result, err := r.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *UserRepo) ScheduleDeletion(ctx context.Context, userID int, at *time.Time) error {
+	query := `
+        UPDATE users
+        SET deletion_scheduled_at = $2,
+            updated_at = NOW()
+        WHERE id = $1`
+
+	result, err := r.db.ExecContext(ctx, query, userID, at)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *UserRepo) ListDueForDeletion(ctx context.Context, before time.Time, limit int) ([]*repository.User, error) {
+	query := `
+        SELECT` + userColumns + `
+        FROM users
+        WHERE deletion_scheduled_at IS NOT NULL
+          AND deletion_scheduled_at <= $1
+        ORDER BY deletion_scheduled_at, id
+        LIMIT $2`
+
+	rows, err := r.db.QueryContext(ctx, query, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*repository.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (r *UserRepo) Delete(ctx context.Context, userID int) error {
+	// Sessions and API tokens cascade
+	result, err := r.db.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
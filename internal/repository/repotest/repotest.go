@@ -0,0 +1,1374 @@
+// internal/repository/repotest/repotest.go
+
+// Package repotest is a contract test suite for repository implementations.
+// Every implementation runs the same suite so their behaviour can't drift.
+//
+// The suite only adds data, with unique emails, tokens and request IDs, so it
+// can run against a database that already has rows in it.
+package repotest
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"option-manager/internal/repository"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Run runs the contract tests. newRepo is called once per group of tests.
+func Run(t *testing.T, newRepo func(t *testing.T) *repository.Repository) {
+	t.Run("Users", func(t *testing.T) { testUsers(t, newRepo(t)) })
+	t.Run("Sessions", func(t *testing.T) { testSessions(t, newRepo(t)) })
+	t.Run("Roles", func(t *testing.T) { testRoles(t, newRepo(t)) })
+	t.Run("APITokens", func(t *testing.T) { testAPITokens(t, newRepo(t)) })
+	t.Run("Audit", func(t *testing.T) { testAudit(t, newRepo(t)) })
+	t.Run("Outbox", func(t *testing.T) { testOutbox(t, newRepo(t)) })
+	t.Run("Suppressions", func(t *testing.T) { testSuppressions(t, newRepo(t)) })
+	t.Run("Tx", func(t *testing.T) { testTx(t, newRepo(t)) })
+}
+
+// missingUserID is an ID no test database will have reached
+const missingUserID = 1 << 30
+
+func randomHex(t *testing.T, n int) string {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func createUser(t *testing.T, repo *repository.Repository, lastName string) *repository.User {
+	t.Helper()
+	user := &repository.User{
+		Email:        fmt.Sprintf("user-%s@example.com", randomHex(t, 8)),
+		PasswordHash: "hash",
+		FirstName:    "Test",
+		LastName:     lastName,
+	}
+	if err := repo.User.Create(context.Background(), user); err != nil {
+		t.Fatalf("Create user: %v", err)
+	}
+	return user
+}
+
+func findUser(t *testing.T, repo *repository.Repository, id int) *repository.User {
+	t.Helper()
+	user, err := repo.User.FindByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("FindByID(%d): %v", id, err)
+	}
+	if user == nil {
+		t.Fatalf("FindByID(%d) = nil, want user", id)
+	}
+	return user
+}
+
+func wantNoRows(t *testing.T, name string, err error) {
+	t.Helper()
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("%s: got error %v, want sql.ErrNoRows", name, err)
+	}
+}
+
+func testUsers(t *testing.T, repo *repository.Repository) {
+	ctx := context.Background()
+
+	t.Run("Create fills in generated fields", func(t *testing.T) {
+		user := createUser(t, repo, "Create")
+		if user.ID == 0 || user.CreatedAt.IsZero() || user.UpdatedAt.IsZero() {
+			t.Errorf("generated fields not set: %+v", user)
+		}
+		if user.Role != "user" {
+			t.Errorf("Role = %q, want user", user.Role)
+		}
+
+		found := findUser(t, repo, user.ID)
+		if found.Email != user.Email || found.FirstName != "Test" || found.LastName != "Create" {
+			t.Errorf("FindByID = %+v, want %+v", found, user)
+		}
+	})
+
+	t.Run("emails are unique", func(t *testing.T) {
+		user := createUser(t, repo, "Unique")
+		err := repo.User.Create(ctx, &repository.User{Email: user.Email, PasswordHash: "hash"})
+		if !errors.Is(err, repository.ErrDuplicateEmail) {
+			t.Errorf("Create with a taken email: got %v, want ErrDuplicateEmail", err)
+		}
+	})
+
+	t.Run("not found returns nil", func(t *testing.T) {
+		if user, err := repo.User.FindByID(ctx, missingUserID); user != nil || err != nil {
+			t.Errorf("FindByID = %v, %v; want nil, nil", user, err)
+		}
+		if user, err := repo.User.FindByEmail(ctx, "missing-"+randomHex(t, 8)+"@example.com"); user != nil || err != nil {
+			t.Errorf("FindByEmail = %v, %v; want nil, nil", user, err)
+		}
+		if user, err := repo.User.FindByVerificationToken(ctx, randomHex(t, 32)); user != nil || err != nil {
+			t.Errorf("FindByVerificationToken = %v, %v; want nil, nil", user, err)
+		}
+		if user, err := repo.User.FindByEmailChangeToken(ctx, randomHex(t, 32)); user != nil || err != nil {
+			t.Errorf("FindByEmailChangeToken = %v, %v; want nil, nil", user, err)
+		}
+		if user, err := repo.User.FindByEmailCancelToken(ctx, randomHex(t, 32)); user != nil || err != nil {
+			t.Errorf("FindByEmailCancelToken = %v, %v; want nil, nil", user, err)
+		}
+	})
+
+	t.Run("updates to a missing user return sql.ErrNoRows", func(t *testing.T) {
+		wantNoRows(t, "UpdateVerificationStatus", repo.User.UpdateVerificationStatus(ctx, missingUserID, true))
+		wantNoRows(t, "SetVerificationToken", repo.User.SetVerificationToken(ctx, missingUserID, "token", time.Now()))
+		wantNoRows(t, "UpdatePasswordHash", repo.User.UpdatePasswordHash(ctx, missingUserID, "hash"))
+		wantNoRows(t, "SetDisabled", repo.User.SetDisabled(ctx, missingUserID, true))
+		wantNoRows(t, "SetRole", repo.User.SetRole(ctx, missingUserID, "admin"))
+		wantNoRows(t, "UpdateProfile", repo.User.UpdateProfile(ctx, missingUserID, "A", "B", time.Now()))
+		wantNoRows(t, "SetPendingEmail", repo.User.SetPendingEmail(ctx, missingUserID, "new@example.com", "token", "cancel", time.Now()))
+		wantNoRows(t, "ConfirmPendingEmail", repo.User.ConfirmPendingEmail(ctx, missingUserID, "token"))
+		wantNoRows(t, "ClearPendingEmail", repo.User.ClearPendingEmail(ctx, missingUserID))
+		wantNoRows(t, "ScheduleDeletion", repo.User.ScheduleDeletion(ctx, missingUserID, nil))
+		wantNoRows(t, "Delete", repo.User.Delete(ctx, missingUserID))
+	})
+
+	t.Run("verification", func(t *testing.T) {
+		user := createUser(t, repo, "Verify")
+		token := randomHex(t, 32)
+		expiry := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+
+		if err := repo.User.SetVerificationToken(ctx, user.ID, token, expiry); err != nil {
+			t.Fatal(err)
+		}
+		found, err := repo.User.FindByVerificationToken(ctx, token)
+		if err != nil || found == nil || found.ID != user.ID {
+			t.Fatalf("FindByVerificationToken = %v, %v; want user %d", found, err, user.ID)
+		}
+		if found.VerificationExpiry == nil || !found.VerificationExpiry.Equal(expiry) {
+			t.Errorf("VerificationExpiry = %v, want %v", found.VerificationExpiry, expiry)
+		}
+
+		if err := repo.User.UpdateVerificationStatus(ctx, user.ID, true); err != nil {
+			t.Fatal(err)
+		}
+		found = findUser(t, repo, user.ID)
+		if !found.EmailVerified || found.VerificationToken != nil || found.VerificationExpiry != nil {
+			t.Errorf("after verifying: %+v, want verified with the token cleared", found)
+		}
+		if again, _ := repo.User.FindByVerificationToken(ctx, token); again != nil {
+			t.Error("verification token still matches after verifying")
+		}
+	})
+
+	t.Run("UpdatePasswordHash", func(t *testing.T) {
+		user := createUser(t, repo, "Password")
+		if err := repo.User.UpdatePasswordHash(ctx, user.ID, "new-hash"); err != nil {
+			t.Fatal(err)
+		}
+		if found := findUser(t, repo, user.ID); found.PasswordHash != "new-hash" {
+			t.Errorf("PasswordHash = %q, want new-hash", found.PasswordHash)
+		}
+	})
+
+	t.Run("List filters and pages", func(t *testing.T) {
+		marker := "List" + randomHex(t, 4)
+		var ids []int
+		for i := 0; i < 3; i++ {
+			ids = append(ids, createUser(t, repo, marker).ID)
+		}
+		if err := repo.User.SetRole(ctx, ids[1], "support"); err != nil {
+			t.Fatal(err)
+		}
+
+		listIDs := func(filter repository.UserFilter) []int {
+			t.Helper()
+			users, err := repo.User.List(ctx, filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []int
+			for _, user := range users {
+				got = append(got, user.ID)
+			}
+			return got
+		}
+
+		// The query matches case-insensitively
+		if got := listIDs(repository.UserFilter{Query: marker, Limit: 10}); !reflect.DeepEqual(got, ids) {
+			t.Errorf("List by query = %v, want %v", got, ids)
+		}
+		if got := listIDs(repository.UserFilter{Query: "LIST" + marker[4:], Limit: 10}); !reflect.DeepEqual(got, ids) {
+			t.Errorf("List by upper-case query = %v, want %v", got, ids)
+		}
+		if got := listIDs(repository.UserFilter{Query: marker, Limit: 2}); !reflect.DeepEqual(got, ids[:2]) {
+			t.Errorf("first page = %v, want %v", got, ids[:2])
+		}
+		if got := listIDs(repository.UserFilter{Query: marker, AfterID: ids[1], Limit: 2}); !reflect.DeepEqual(got, ids[2:]) {
+			t.Errorf("second page = %v, want %v", got, ids[2:])
+		}
+		if got := listIDs(repository.UserFilter{Query: marker, Role: "support", Limit: 10}); !reflect.DeepEqual(got, ids[1:2]) {
+			t.Errorf("List by role = %v, want %v", got, ids[1:2])
+		}
+	})
+
+	t.Run("List matches wildcards literally", func(t *testing.T) {
+		marker := randomHex(t, 4)
+		user := createUser(t, repo, `50%_off\`+marker)
+		createUser(t, repo, "50xyoff"+marker)
+
+		for _, query := range []string{`50%_off\` + marker, "%_off", `_off\`} {
+			users, err := repo.User.List(ctx, repository.UserFilter{Query: query, Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(users) != 1 || users[0].ID != user.ID {
+				t.Errorf("List(%q) returned %d users, want only user %d", query, len(users), user.ID)
+			}
+		}
+		if users, err := repo.User.List(ctx, repository.UserFilter{Query: "50_yoff" + marker, Limit: 10}); err != nil || len(users) != 0 {
+			t.Errorf("List with _ as a wildcard = %d users, %v; want none", len(users), err)
+		}
+	})
+
+	t.Run("SetDisabled", func(t *testing.T) {
+		user := createUser(t, repo, "Disable")
+		if err := repo.User.SetDisabled(ctx, user.ID, true); err != nil {
+			t.Fatal(err)
+		}
+		disabled := findUser(t, repo, user.ID)
+		if disabled.DisabledAt == nil {
+			t.Fatal("DisabledAt not set")
+		}
+
+		// Disabling again keeps the original time
+		if err := repo.User.SetDisabled(ctx, user.ID, true); err != nil {
+			t.Fatal(err)
+		}
+		if again := findUser(t, repo, user.ID); again.DisabledAt == nil || !again.DisabledAt.Equal(*disabled.DisabledAt) {
+			t.Errorf("DisabledAt = %v, want %v", again.DisabledAt, disabled.DisabledAt)
+		}
+
+		if err := repo.User.SetDisabled(ctx, user.ID, false); err != nil {
+			t.Fatal(err)
+		}
+		if enabled := findUser(t, repo, user.ID); enabled.DisabledAt != nil {
+			t.Errorf("DisabledAt = %v after enabling, want nil", enabled.DisabledAt)
+		}
+	})
+
+	t.Run("SetRole", func(t *testing.T) {
+		user := createUser(t, repo, "Role")
+		if err := repo.User.SetRole(ctx, user.ID, "admin"); err != nil {
+			t.Fatal(err)
+		}
+		if found := findUser(t, repo, user.ID); found.Role != "admin" {
+			t.Errorf("Role = %q, want admin", found.Role)
+		}
+		if err := repo.User.SetRole(ctx, user.ID, "no-such-role"); err == nil {
+			t.Error("SetRole with an unknown role succeeded")
+		}
+	})
+
+	t.Run("UpdateProfile checks updated_at", func(t *testing.T) {
+		user := createUser(t, repo, "Profile")
+		stale := user.UpdatedAt
+
+		if err := repo.User.UpdateProfile(ctx, user.ID, "New", "Name", stale); err != nil {
+			t.Fatalf("UpdateProfile with the current updated_at: %v", err)
+		}
+		found := findUser(t, repo, user.ID)
+		if found.FirstName != "New" || found.LastName != "Name" {
+			t.Errorf("name = %q %q, want New Name", found.FirstName, found.LastName)
+		}
+
+		wantNoRows(t, "UpdateProfile with a stale updated_at", repo.User.UpdateProfile(ctx, user.ID, "Other", "Name", stale.Add(-time.Second)))
+	})
+
+	t.Run("email change", func(t *testing.T) {
+		user := createUser(t, repo, "EmailChange")
+		wantNoRows(t, "ConfirmPendingEmail with nothing pending", repo.User.ConfirmPendingEmail(ctx, user.ID, "token"))
+
+		newEmail := fmt.Sprintf("new-%s@example.com", randomHex(t, 8))
+		token, cancelToken := randomHex(t, 32), randomHex(t, 32)
+		expiry := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+		if err := repo.User.SetPendingEmail(ctx, user.ID, newEmail, token, cancelToken, expiry); err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := repo.User.FindByEmailChangeToken(ctx, token)
+		if err != nil || found == nil || found.ID != user.ID {
+			t.Fatalf("FindByEmailChangeToken = %v, %v; want user %d", found, err, user.ID)
+		}
+		if found.Email != user.Email || found.PendingEmail == nil || *found.PendingEmail != newEmail {
+			t.Errorf("pending change = %q -> %v, want %q -> %q", found.Email, found.PendingEmail, user.Email, newEmail)
+		}
+		if found.EmailChangeExpiry == nil || !found.EmailChangeExpiry.Equal(expiry) {
+			t.Errorf("EmailChangeExpiry = %v, want %v", found.EmailChangeExpiry, expiry)
+		}
+		if found, err := repo.User.FindByEmailCancelToken(ctx, cancelToken); err != nil || found == nil || found.ID != user.ID {
+			t.Fatalf("FindByEmailCancelToken = %v, %v; want user %d", found, err, user.ID)
+		}
+
+		wantNoRows(t, "ConfirmPendingEmail with the cancel token", repo.User.ConfirmPendingEmail(ctx, user.ID, cancelToken))
+		if err := repo.User.ConfirmPendingEmail(ctx, user.ID, token); err != nil {
+			t.Fatal(err)
+		}
+		found = findUser(t, repo, user.ID)
+		if found.Email != newEmail || !found.EmailVerified {
+			t.Errorf("after confirming: email %q, verified %v; want %q, true", found.Email, found.EmailVerified, newEmail)
+		}
+		if found.PendingEmail != nil || found.EmailChangeToken != nil || found.EmailCancelToken != nil || found.EmailChangeExpiry != nil {
+			t.Errorf("after confirming: %+v, want the pending change cleared", found)
+		}
+		if again, _ := repo.User.FindByEmailChangeToken(ctx, token); again != nil {
+			t.Error("email change token still matches after confirming")
+		}
+	})
+
+	t.Run("email change replaced by a newer one", func(t *testing.T) {
+		user := createUser(t, repo, "EmailChangeReplaced")
+		oldToken := randomHex(t, 32)
+		if err := repo.User.SetPendingEmail(ctx, user.ID, fmt.Sprintf("old-%s@example.com", randomHex(t, 8)), oldToken, randomHex(t, 32), time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		newEmail := fmt.Sprintf("new-%s@example.com", randomHex(t, 8))
+		newToken := randomHex(t, 32)
+		if err := repo.User.SetPendingEmail(ctx, user.ID, newEmail, newToken, randomHex(t, 32), time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		// The first link mustn't confirm the address that replaced it
+		wantNoRows(t, "ConfirmPendingEmail with a replaced token", repo.User.ConfirmPendingEmail(ctx, user.ID, oldToken))
+		found := findUser(t, repo, user.ID)
+		if found.Email != user.Email || found.PendingEmail == nil || *found.PendingEmail != newEmail {
+			t.Errorf("after the replaced token: %q -> %v, want %q -> %q still pending", found.Email, found.PendingEmail, user.Email, newEmail)
+		}
+		if err := repo.User.ConfirmPendingEmail(ctx, user.ID, newToken); err != nil {
+			t.Fatalf("ConfirmPendingEmail with the current token: %v", err)
+		}
+	})
+
+	t.Run("expired email change", func(t *testing.T) {
+		user := createUser(t, repo, "EmailChangeExpired")
+		token := randomHex(t, 32)
+		if err := repo.User.SetPendingEmail(ctx, user.ID, fmt.Sprintf("late-%s@example.com", randomHex(t, 8)), token, randomHex(t, 32), time.Now().Add(-time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		wantNoRows(t, "ConfirmPendingEmail after expiry", repo.User.ConfirmPendingEmail(ctx, user.ID, token))
+		if found := findUser(t, repo, user.ID); found.Email != user.Email {
+			t.Errorf("email = %q after an expired confirmation, want %q", found.Email, user.Email)
+		}
+	})
+
+	t.Run("email change to a taken address", func(t *testing.T) {
+		user := createUser(t, repo, "EmailChangeTaken")
+		other := createUser(t, repo, "EmailChangeOther")
+		token := randomHex(t, 32)
+		if err := repo.User.SetPendingEmail(ctx, user.ID, other.Email, token, randomHex(t, 32), time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.User.ConfirmPendingEmail(ctx, user.ID, token); !errors.Is(err, repository.ErrDuplicateEmail) {
+			t.Errorf("ConfirmPendingEmail to a taken address: got %v, want ErrDuplicateEmail", err)
+		}
+
+		if err := repo.User.ClearPendingEmail(ctx, user.ID); err != nil {
+			t.Fatal(err)
+		}
+		found := findUser(t, repo, user.ID)
+		if found.Email != user.Email || found.PendingEmail != nil || found.EmailChangeToken != nil {
+			t.Errorf("after clearing: %+v, want the original email and nothing pending", found)
+		}
+	})
+
+	t.Run("ScheduleDeletion and ListDueForDeletion", func(t *testing.T) {
+		later := createUser(t, repo, "DeleteLater")
+		sooner := createUser(t, repo, "DeleteSooner")
+		notDue := createUser(t, repo, "DeleteNotDue")
+		cancelled := createUser(t, repo, "DeleteCancelled")
+
+		base := time.Now().Add(-time.Hour).Truncate(time.Microsecond)
+		schedule := func(user *repository.User, at time.Time) {
+			t.Helper()
+			if err := repo.User.ScheduleDeletion(ctx, user.ID, &at); err != nil {
+				t.Fatal(err)
+			}
+		}
+		schedule(later, base.Add(time.Minute))
+		schedule(sooner, base)
+		schedule(notDue, base.Add(2*time.Hour))
+		schedule(cancelled, base)
+		if err := repo.User.ScheduleDeletion(ctx, cancelled.ID, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		found := findUser(t, repo, sooner.ID)
+		if found.DeletionScheduledAt == nil || !found.DeletionScheduledAt.Equal(base) {
+			t.Errorf("DeletionScheduledAt = %v, want %v", found.DeletionScheduledAt, base)
+		}
+		if found := findUser(t, repo, cancelled.ID); found.DeletionScheduledAt != nil {
+			t.Errorf("DeletionScheduledAt after cancelling = %v, want nil", found.DeletionScheduledAt)
+		}
+
+		due, err := repo.User.ListDueForDeletion(ctx, time.Now(), 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Other tests' users may be due too
+		var got []int
+		for _, user := range due {
+			switch user.ID {
+			case later.ID, sooner.ID, notDue.ID, cancelled.ID:
+				got = append(got, user.ID)
+			}
+		}
+		if want := []int{sooner.ID, later.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("due = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Delete cascades", func(t *testing.T) {
+		user := createUser(t, repo, "Delete")
+		session := createSession(t, repo, user.ID, time.Hour)
+		token := &repository.APIToken{UserID: user.ID, Name: "test", TokenHash: randomHex(t, 32)}
+		if err := repo.APIToken.Create(ctx, token); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := repo.User.Delete(ctx, user.ID); err != nil {
+			t.Fatal(err)
+		}
+		if found, err := repo.User.FindByID(ctx, user.ID); found != nil || err != nil {
+			t.Errorf("FindByID after Delete = %v, %v; want nil, nil", found, err)
+		}
+		if found, err := repo.Session.FindByID(ctx, session.ID); found != nil || err != nil {
+			t.Errorf("session after Delete = %v, %v; want nil, nil", found, err)
+		}
+		if found, err := repo.APIToken.FindByHash(ctx, token.TokenHash); found != nil || err != nil {
+			t.Errorf("API token after Delete = %v, %v; want nil, nil", found, err)
+		}
+		wantNoRows(t, "Delete again", repo.User.Delete(ctx, user.ID))
+	})
+}
+
+func newSession(t *testing.T, userID int, expiresIn time.Duration) *repository.Session {
+	t.Helper()
+	current := time.Now().Truncate(time.Microsecond)
+	return &repository.Session{
+		ID:                randomHex(t, 32),
+		Token:             "raw-token",
+		UserID:            userID,
+		IPAddress:         "192.0.2.1",
+		UserAgent:         "test",
+		ExpiresAt:         current.Add(expiresIn),
+		AbsoluteExpiresAt: current.Add(24 * time.Hour),
+	}
+}
+
+func createSession(t *testing.T, repo *repository.Repository, userID int, expiresIn time.Duration) *repository.Session {
+	t.Helper()
+	session := newSession(t, userID, expiresIn)
+	if err := repo.Session.Create(context.Background(), session); err != nil {
+		t.Fatalf("Create session: %v", err)
+	}
+	return session
+}
+
+func testSessions(t *testing.T, repo *repository.Repository) {
+	ctx := context.Background()
+
+	t.Run("Create and FindByID", func(t *testing.T) {
+		user := createUser(t, repo, "Session")
+		session := createSession(t, repo, user.ID, time.Hour)
+		if session.CreatedAt.IsZero() || session.LastSeenAt.IsZero() {
+			t.Errorf("generated fields not set: %+v", session)
+		}
+
+		found, err := repo.Session.FindByID(ctx, session.ID)
+		if err != nil || found == nil {
+			t.Fatalf("FindByID = %v, %v; want session", found, err)
+		}
+		if found.UserID != user.ID || found.IPAddress != "192.0.2.1" || !found.ExpiresAt.Equal(session.ExpiresAt) {
+			t.Errorf("FindByID = %+v, want %+v", found, session)
+		}
+		if found.Token != "" {
+			t.Error("the raw token was stored")
+		}
+	})
+
+	t.Run("expired sessions are invisible", func(t *testing.T) {
+		user := createUser(t, repo, "Expired")
+		active := createSession(t, repo, user.ID, time.Hour)
+		expired := createSession(t, repo, user.ID, -time.Minute)
+
+		if found, err := repo.Session.FindByID(ctx, expired.ID); found != nil || err != nil {
+			t.Errorf("FindByID(expired) = %v, %v; want nil, nil", found, err)
+		}
+		sessions, err := repo.Session.ListByUserID(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sessions) != 1 || sessions[0].ID != active.ID {
+			t.Errorf("ListByUserID returned %d sessions, want only the active one", len(sessions))
+		}
+		wantNoRows(t, "Rotate(expired)", repo.Session.Rotate(ctx, expired.ID, randomHex(t, 32)))
+	})
+
+	t.Run("DeleteExpired and CountActive", func(t *testing.T) {
+		user := createUser(t, repo, "Purge")
+		before, err := repo.Session.CountActive(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		createSession(t, repo, user.ID, time.Hour)
+		expired := createSession(t, repo, user.ID, -time.Minute)
+
+		after, err := repo.Session.CountActive(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after != before+1 {
+			t.Errorf("CountActive went from %d to %d, want one more", before, after)
+		}
+
+		deleted, err := repo.Session.DeleteExpired(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted < 1 {
+			t.Errorf("DeleteExpired = %d, want at least 1", deleted)
+		}
+		// The expired row is gone, not just hidden
+		wantNoRows(t, "UpdateActivity after DeleteExpired", repo.Session.UpdateActivity(ctx, expired.ID, time.Now(), time.Now()))
+	})
+
+	t.Run("UpdateActivity caps expiry at the absolute lifetime", func(t *testing.T) {
+		user := createUser(t, repo, "Activity")
+		session := createSession(t, repo, user.ID, time.Hour)
+		lastSeen := time.Now().Add(time.Minute).Truncate(time.Microsecond)
+
+		if err := repo.Session.UpdateActivity(ctx, session.ID, lastSeen, session.AbsoluteExpiresAt.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		found, err := repo.Session.FindByID(ctx, session.ID)
+		if err != nil || found == nil {
+			t.Fatalf("FindByID = %v, %v", found, err)
+		}
+		if !found.ExpiresAt.Equal(session.AbsoluteExpiresAt) {
+			t.Errorf("ExpiresAt = %v, want the absolute expiry %v", found.ExpiresAt, session.AbsoluteExpiresAt)
+		}
+		if !found.LastSeenAt.Equal(lastSeen) {
+			t.Errorf("LastSeenAt = %v, want %v", found.LastSeenAt, lastSeen)
+		}
+
+		wantNoRows(t, "UpdateActivity(missing)", repo.Session.UpdateActivity(ctx, randomHex(t, 32), time.Now(), time.Now()))
+	})
+
+	t.Run("ListByUserID orders by last use", func(t *testing.T) {
+		user := createUser(t, repo, "Order")
+		older := createSession(t, repo, user.ID, time.Hour)
+		newer := createSession(t, repo, user.ID, time.Hour)
+		base := time.Now().Truncate(time.Microsecond)
+		if err := repo.Session.UpdateActivity(ctx, older.ID, base.Add(time.Minute), older.ExpiresAt); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Session.UpdateActivity(ctx, newer.ID, base.Add(2*time.Minute), newer.ExpiresAt); err != nil {
+			t.Fatal(err)
+		}
+
+		sessions, err := repo.Session.ListByUserID(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sessions) != 2 || sessions[0].ID != newer.ID || sessions[1].ID != older.ID {
+			t.Errorf("ListByUserID is not ordered most recently used first")
+		}
+	})
+
+	t.Run("Rotate", func(t *testing.T) {
+		user := createUser(t, repo, "Rotate")
+		session := createSession(t, repo, user.ID, time.Hour)
+		newID := randomHex(t, 32)
+
+		if err := repo.Session.Rotate(ctx, session.ID, newID); err != nil {
+			t.Fatal(err)
+		}
+		if old, _ := repo.Session.FindByID(ctx, session.ID); old != nil {
+			t.Error("the old ID still finds the session")
+		}
+		if rotated, _ := repo.Session.FindByID(ctx, newID); rotated == nil || rotated.UserID != user.ID {
+			t.Errorf("FindByID(new ID) = %v, want the session", rotated)
+		}
+		wantNoRows(t, "Rotate(missing)", repo.Session.Rotate(ctx, randomHex(t, 32), randomHex(t, 32)))
+	})
+
+	t.Run("deletes", func(t *testing.T) {
+		user := createUser(t, repo, "Delete")
+		other := createUser(t, repo, "Delete")
+		a := createSession(t, repo, user.ID, time.Hour)
+		b := createSession(t, repo, user.ID, time.Hour)
+		c := createSession(t, repo, user.ID, time.Hour)
+		keep := createSession(t, repo, other.ID, time.Hour)
+
+		if err := repo.Session.Delete(ctx, a.ID); err != nil {
+			t.Fatal(err)
+		}
+		wantNoRows(t, "Delete(missing)", repo.Session.Delete(ctx, a.ID))
+
+		wantNoRows(t, "DeleteForUser(someone else's session)", repo.Session.DeleteForUser(ctx, other.ID, b.ID))
+		if err := repo.Session.DeleteForUser(ctx, user.ID, b.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		d := createSession(t, repo, user.ID, time.Hour)
+		if err := repo.Session.DeleteByUserID(ctx, user.ID, d.ID); err != nil {
+			t.Fatal(err)
+		}
+		sessions, err := repo.Session.ListByUserID(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sessions) != 1 || sessions[0].ID != d.ID {
+			t.Errorf("DeleteByUserID left %d sessions, want only the excepted one", len(sessions))
+		}
+		if found, _ := repo.Session.FindByID(ctx, c.ID); found != nil {
+			t.Error("DeleteByUserID kept a session it should have deleted")
+		}
+		if found, _ := repo.Session.FindByID(ctx, keep.ID); found == nil {
+			t.Error("DeleteByUserID deleted another user's session")
+		}
+
+		// With no exception every session goes, and nothing matching is fine
+		if err := repo.Session.DeleteByUserID(ctx, user.ID, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Session.DeleteByUserID(ctx, user.ID, ""); err != nil {
+			t.Errorf("DeleteByUserID with nothing to delete: %v", err)
+		}
+	})
+}
+
+func testRoles(t *testing.T, repo *repository.Repository) {
+	ctx := context.Background()
+
+	admin, err := repo.Role.PermissionsForRole(ctx, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"admin.access",
+		"audit.read",
+		"email.read",
+		"users.disable",
+		"users.read",
+		"users.resend_verification",
+		"users.sessions.revoke",
+	}
+	if !reflect.DeepEqual(admin, want) {
+		t.Errorf("admin permissions = %v, want %v", admin, want)
+	}
+
+	for _, role := range []string{"user", "no-such-role"} {
+		permissions, err := repo.Role.PermissionsForRole(ctx, role)
+		if err != nil || len(permissions) != 0 {
+			t.Errorf("PermissionsForRole(%q) = %v, %v; want none", role, permissions, err)
+		}
+	}
+}
+
+func testAPITokens(t *testing.T, repo *repository.Repository) {
+	ctx := context.Background()
+
+	createToken := func(t *testing.T, userID int, expiresAt *time.Time) *repository.APIToken {
+		t.Helper()
+		token := &repository.APIToken{
+			UserID:    userID,
+			Name:      "test",
+			TokenHash: randomHex(t, 32),
+			Scopes:    []string{"read", "write"},
+			ExpiresAt: expiresAt,
+		}
+		if err := repo.APIToken.Create(ctx, token); err != nil {
+			t.Fatalf("Create token: %v", err)
+		}
+		return token
+	}
+
+	t.Run("Create and FindByHash", func(t *testing.T) {
+		user := createUser(t, repo, "Token")
+		token := createToken(t, user.ID, nil)
+		if token.ID == 0 || token.CreatedAt.IsZero() {
+			t.Errorf("generated fields not set: %+v", token)
+		}
+
+		found, err := repo.APIToken.FindByHash(ctx, token.TokenHash)
+		if err != nil || found == nil {
+			t.Fatalf("FindByHash = %v, %v; want token", found, err)
+		}
+		if found.ID != token.ID || found.UserID != user.ID || !reflect.DeepEqual(found.Scopes, token.Scopes) {
+			t.Errorf("FindByHash = %+v, want %+v", found, token)
+		}
+
+		if missing, err := repo.APIToken.FindByHash(ctx, randomHex(t, 32)); missing != nil || err != nil {
+			t.Errorf("FindByHash(missing) = %v, %v; want nil, nil", missing, err)
+		}
+	})
+
+	t.Run("expired tokens are not found", func(t *testing.T) {
+		user := createUser(t, repo, "Token")
+		past := time.Now().Add(-time.Minute)
+		token := createToken(t, user.ID, &past)
+		if found, err := repo.APIToken.FindByHash(ctx, token.TokenHash); found != nil || err != nil {
+			t.Errorf("FindByHash(expired) = %v, %v; want nil, nil", found, err)
+		}
+	})
+
+	t.Run("ListByUserID, UpdateLastUsed and DeleteForUser", func(t *testing.T) {
+		user := createUser(t, repo, "Token")
+		other := createUser(t, repo, "Token")
+		first := createToken(t, user.ID, nil)
+		second := createToken(t, user.ID, nil)
+
+		tokens, err := repo.APIToken.ListByUserID(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tokens) != 2 || tokens[0].ID != second.ID || tokens[1].ID != first.ID {
+			t.Errorf("ListByUserID is not newest first")
+		}
+
+		lastUsed := time.Now().Truncate(time.Microsecond)
+		if err := repo.APIToken.UpdateLastUsed(ctx, first.ID, lastUsed); err != nil {
+			t.Fatal(err)
+		}
+		found, _ := repo.APIToken.FindByHash(ctx, first.TokenHash)
+		if found == nil || found.LastUsedAt == nil || !found.LastUsedAt.Equal(lastUsed) {
+			t.Errorf("LastUsedAt not updated: %+v", found)
+		}
+		wantNoRows(t, "UpdateLastUsed(missing)", repo.APIToken.UpdateLastUsed(ctx, missingUserID, lastUsed))
+
+		wantNoRows(t, "DeleteForUser(someone else's token)", repo.APIToken.DeleteForUser(ctx, other.ID, first.ID))
+		if err := repo.APIToken.DeleteForUser(ctx, user.ID, first.ID); err != nil {
+			t.Fatal(err)
+		}
+		if found, _ := repo.APIToken.FindByHash(ctx, first.TokenHash); found != nil {
+			t.Error("deleted token is still found")
+		}
+	})
+}
+
+func testAudit(t *testing.T, repo *repository.Repository) {
+	ctx := context.Background()
+	user := createUser(t, repo, "Audit")
+	requestID := randomHex(t, 8)
+
+	record := func(t *testing.T, eventType string, after json.RawMessage, metadata map[string]string) *repository.AuditEvent {
+		t.Helper()
+		event := &repository.AuditEvent{
+			Type:          eventType,
+			SubjectUserID: &user.ID,
+			RequestID:     requestID,
+			After:         after,
+			Metadata:      metadata,
+		}
+		if err := repo.Audit.Create(ctx, event); err != nil {
+			t.Fatalf("Create event: %v", err)
+		}
+		return event
+	}
+
+	login := record(t, "auth.login.succeeded", nil, nil)
+	changed := record(t, "user.profile_updated", json.RawMessage(`{"first_name":"New"}`), map[string]string{"field": "name"})
+	logout := record(t, "auth.logout", nil, nil)
+
+	list := func(t *testing.T, filter repository.AuditFilter) []*repository.AuditEvent {
+		t.Helper()
+		if filter.Limit == 0 {
+			filter.Limit = 10
+		}
+		events, err := repo.Audit.List(ctx, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return events
+	}
+	ids := func(events []*repository.AuditEvent) []int64 {
+		var got []int64
+		for _, event := range events {
+			got = append(got, event.ID)
+		}
+		return got
+	}
+
+	t.Run("List is newest first and filters", func(t *testing.T) {
+		if got, want := ids(list(t, repository.AuditFilter{RequestID: requestID})), []int64{logout.ID, changed.ID, login.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("by request ID = %v, want %v", got, want)
+		}
+		if got, want := ids(list(t, repository.AuditFilter{RequestID: requestID, Type: "auth."})), []int64{logout.ID, login.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("by type prefix = %v, want %v", got, want)
+		}
+		// The prefix is matched literally, not as a LIKE pattern
+		for _, prefix := range []string{"%", "auth_", "user_profile", `auth\`} {
+			if got := list(t, repository.AuditFilter{RequestID: requestID, Type: prefix}); len(got) != 0 {
+				t.Errorf("by type prefix %q returned %d events, want none", prefix, len(got))
+			}
+		}
+		if got, want := ids(list(t, repository.AuditFilter{UserID: user.ID})), []int64{logout.ID, changed.ID, login.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("by user = %v, want %v", got, want)
+		}
+		if got, want := ids(list(t, repository.AuditFilter{RequestID: requestID, BeforeID: logout.ID, Limit: 1})), []int64{changed.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("paged = %v, want %v", got, want)
+		}
+
+		hourAgo := time.Now().Add(-time.Hour)
+		if got := list(t, repository.AuditFilter{RequestID: requestID, Since: &hourAgo}); len(got) != 3 {
+			t.Errorf("since an hour ago returned %d events, want 3", len(got))
+		}
+		if got := list(t, repository.AuditFilter{RequestID: requestID, Until: &hourAgo}); len(got) != 0 {
+			t.Errorf("until an hour ago returned %d events, want none", len(got))
+		}
+	})
+
+	t.Run("List by subject leaves out actions on others", func(t *testing.T) {
+		actor := createUser(t, repo, "Actor")
+		other := createUser(t, repo, "Other")
+		own := &repository.AuditEvent{Type: "auth.login.succeeded", ActorUserID: &actor.ID, SubjectUserID: &actor.ID}
+		done := &repository.AuditEvent{Type: "user.role_changed", ActorUserID: &actor.ID, SubjectUserID: &other.ID}
+		for _, event := range []*repository.AuditEvent{own, done} {
+			if err := repo.Audit.Create(ctx, event); err != nil {
+				t.Fatalf("Create event: %v", err)
+			}
+		}
+
+		if got, want := ids(list(t, repository.AuditFilter{UserID: actor.ID})), []int64{done.ID, own.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("by actor or subject = %v, want %v", got, want)
+		}
+		if got, want := ids(list(t, repository.AuditFilter{SubjectUserID: actor.ID})), []int64{own.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("by subject = %v, want %v", got, want)
+		}
+		if got, want := ids(list(t, repository.AuditFilter{SubjectUserID: other.ID})), []int64{done.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("by the other subject = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("events round-trip", func(t *testing.T) {
+		events := list(t, repository.AuditFilter{RequestID: requestID, Type: "user.profile_updated"})
+		if len(events) != 1 {
+			t.Fatalf("got %d events, want 1", len(events))
+		}
+		event := events[0]
+		if event.SubjectUserID == nil || *event.SubjectUserID != user.ID || event.ActorUserID != nil {
+			t.Errorf("user IDs = %v, %v; want no actor and subject %d", event.ActorUserID, event.SubjectUserID, user.ID)
+		}
+		if event.Before != nil {
+			t.Errorf("Before = %s, want nil", event.Before)
+		}
+		var after map[string]string
+		if err := json.Unmarshal(event.After, &after); err != nil || after["first_name"] != "New" {
+			t.Errorf("After = %s, want the recorded snapshot", event.After)
+		}
+		if !reflect.DeepEqual(event.Metadata, map[string]string{"field": "name"}) {
+			t.Errorf("Metadata = %v", event.Metadata)
+		}
+		if event.CreatedAt.IsZero() {
+			t.Error("CreatedAt not set")
+		}
+
+		// Missing metadata comes back as an empty map
+		events = list(t, repository.AuditFilter{RequestID: requestID, Type: "auth.logout"})
+		if len(events) != 1 {
+			t.Fatalf("got %d logout events, want 1", len(events))
+		}
+		if events[0].Metadata == nil || len(events[0].Metadata) != 0 {
+			t.Errorf("Metadata without any recorded = %v, want an empty map", events[0].Metadata)
+		}
+	})
+
+	t.Run("RedactUser", func(t *testing.T) {
+		subject := createUser(t, repo, "Redact")
+		other := createUser(t, repo, "RedactOther")
+		create := func(t *testing.T, actorID, subjectID int) *repository.AuditEvent {
+			t.Helper()
+			event := &repository.AuditEvent{
+				Type:          "user.profile_updated",
+				ActorUserID:   &actorID,
+				SubjectUserID: &subjectID,
+				IPAddress:     "192.0.2.1",
+				UserAgent:     "test agent",
+				RequestID:     requestID,
+				Before:        json.RawMessage(`{"first_name":"Old"}`),
+				After:         json.RawMessage(`{"first_name":"New"}`),
+				Metadata:      map[string]string{"field": "name"},
+			}
+			if err := repo.Audit.Create(ctx, event); err != nil {
+				t.Fatalf("Create event: %v", err)
+			}
+			return event
+		}
+		about := create(t, subject.ID, subject.ID)
+		// Done by the user to someone else, so it is the other user's record
+		byThem := create(t, subject.ID, other.ID)
+		unrelated := create(t, other.ID, other.ID)
+
+		n, err := repo.Audit.RedactUser(ctx, subject.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 {
+			t.Errorf("RedactUser = %d, want 2", n)
+		}
+
+		events := list(t, repository.AuditFilter{UserID: subject.ID})
+		byID := make(map[int64]*repository.AuditEvent)
+		for _, event := range events {
+			byID[event.ID] = event
+		}
+		redacted := byID[about.ID]
+		if redacted == nil {
+			t.Fatal("redacted event is gone")
+		}
+		if redacted.IPAddress != "" || redacted.UserAgent != "" || redacted.Before != nil || redacted.After != nil || len(redacted.Metadata) != 0 {
+			t.Errorf("redacted event = %+v, want personal data blanked", redacted)
+		}
+		if redacted.Type != about.Type || redacted.RequestID != requestID || redacted.SubjectUserID == nil || *redacted.SubjectUserID != subject.ID {
+			t.Errorf("redacted event = %+v, want the rest kept", redacted)
+		}
+		// The user's IP address and user agent go, but the snapshots of
+		// the other user's account stay
+		kept := byID[byThem.ID]
+		if kept == nil {
+			t.Fatal("event about another user is gone")
+		}
+		if kept.IPAddress != "" || kept.UserAgent != "" {
+			t.Errorf("event by the user = %+v, want their IP address and user agent blanked", kept)
+		}
+		if kept.Before == nil || kept.After == nil || kept.Metadata["field"] != "name" {
+			t.Errorf("event about another user = %+v, want its snapshots and metadata kept", kept)
+		}
+		for _, event := range list(t, repository.AuditFilter{UserID: other.ID}) {
+			if event.ID == unrelated.ID && (event.IPAddress != "192.0.2.1" || event.After == nil) {
+				t.Errorf("event neither about nor by the user = %+v, want it untouched", event)
+			}
+		}
+	})
+}
+
+func testOutbox(t *testing.T, repo *repository.Repository) {
+	ctx := context.Background()
+
+	enqueue := func(t *testing.T, recipient string) *repository.OutboxMessage {
+		t.Helper()
+		msg := &repository.OutboxMessage{
+			Kind:      "test",
+			Recipient: recipient,
+			Subject:   "Subject",
+			HTMLBody:  "<p>Body</p>",
+			TextBody:  "Body",
+		}
+		if err := repo.Outbox.Enqueue(ctx, msg); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+		return msg
+	}
+	find := func(t *testing.T, msg *repository.OutboxMessage) *repository.OutboxMessage {
+		t.Helper()
+		messages, err := repo.Outbox.List(ctx, repository.OutboxFilter{Recipient: msg.Recipient, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range messages {
+			if m.ID == msg.ID {
+				return m
+			}
+		}
+		t.Fatalf("message %d not listed", msg.ID)
+		return nil
+	}
+	// claim claims every due message, as other tests' messages may be due
+	// too, and returns the one asked for or nil
+	claim := func(t *testing.T, msg *repository.OutboxMessage, leaseUntil time.Time) *repository.OutboxMessage {
+		t.Helper()
+		claimed, err := repo.Outbox.ClaimDue(ctx, 1000, leaseUntil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range claimed {
+			if m.ID == msg.ID {
+				return m
+			}
+		}
+		return nil
+	}
+	recipient := func(t *testing.T) string {
+		return fmt.Sprintf("outbox-%s@example.com", randomHex(t, 8))
+	}
+
+	t.Run("Enqueue", func(t *testing.T) {
+		msg := enqueue(t, recipient(t))
+		if msg.ID == 0 || msg.Status != repository.OutboxPending || msg.Attempts != 0 || msg.NextAttemptAt.IsZero() || msg.CreatedAt.IsZero() {
+			t.Errorf("generated fields not set: %+v", msg)
+		}
+		found := find(t, msg)
+		if found.Kind != "test" || found.Subject != "Subject" || found.HTMLBody != "<p>Body</p>" || found.TextBody != "Body" {
+			t.Errorf("List = %+v, want %+v", found, msg)
+		}
+	})
+
+	t.Run("ClaimDue leases messages", func(t *testing.T) {
+		msg := enqueue(t, recipient(t))
+		lease := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+
+		claimed := claim(t, msg, lease)
+		if claimed == nil {
+			t.Fatal("due message not claimed")
+		}
+		if claimed.Attempts != 1 || !claimed.NextAttemptAt.Equal(lease) || claimed.TextBody != "Body" {
+			t.Errorf("claimed = %+v, want one attempt, leased until %v", claimed, lease)
+		}
+		if claim(t, msg, lease) != nil {
+			t.Error("a leased message was claimed again")
+		}
+	})
+
+	t.Run("MarkFailed reschedules", func(t *testing.T) {
+		msg := enqueue(t, recipient(t))
+		claim(t, msg, time.Now().Add(time.Hour))
+
+		if err := repo.Outbox.MarkFailed(ctx, msg.ID, "throttled", time.Now().Add(-time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		found := find(t, msg)
+		if found.Status != repository.OutboxPending || found.LastError != "throttled" {
+			t.Errorf("after MarkFailed: %+v", found)
+		}
+		claimed := claim(t, msg, time.Now().Add(time.Hour))
+		if claimed == nil || claimed.Attempts != 2 {
+			t.Errorf("rescheduled message claimed as %+v, want a second attempt", claimed)
+		}
+	})
+
+	t.Run("MarkSent clears the body", func(t *testing.T) {
+		msg := enqueue(t, recipient(t))
+		sentAt := time.Now().Truncate(time.Microsecond)
+		if err := repo.Outbox.MarkSent(ctx, msg.ID, sentAt); err != nil {
+			t.Fatal(err)
+		}
+		found := find(t, msg)
+		if found.Status != repository.OutboxSent || found.SentAt == nil || !found.SentAt.Equal(sentAt) {
+			t.Errorf("after MarkSent: %+v", found)
+		}
+		if found.HTMLBody != "" || found.TextBody != "" {
+			t.Error("body kept after sending")
+		}
+		if claim(t, msg, time.Now().Add(time.Hour)) != nil {
+			t.Error("a sent message was claimed")
+		}
+	})
+
+	t.Run("MarkDead", func(t *testing.T) {
+		msg := enqueue(t, recipient(t))
+		if err := repo.Outbox.MarkDead(ctx, msg.ID, "bounced"); err != nil {
+			t.Fatal(err)
+		}
+		found := find(t, msg)
+		if found.Status != repository.OutboxDead || found.LastError != "bounced" || found.TextBody != "" {
+			t.Errorf("after MarkDead: %+v", found)
+		}
+		if claim(t, msg, time.Now().Add(time.Hour)) != nil {
+			t.Error("a dead message was claimed")
+		}
+	})
+
+	t.Run("updates to a missing message return sql.ErrNoRows", func(t *testing.T) {
+		wantNoRows(t, "MarkSent", repo.Outbox.MarkSent(ctx, missingUserID, time.Now()))
+		wantNoRows(t, "MarkFailed", repo.Outbox.MarkFailed(ctx, missingUserID, "", time.Now()))
+		wantNoRows(t, "MarkDead", repo.Outbox.MarkDead(ctx, missingUserID, ""))
+	})
+
+	t.Run("List filters and pages", func(t *testing.T) {
+		to := recipient(t)
+		first := enqueue(t, to)
+		second := enqueue(t, to)
+		third := enqueue(t, to)
+		if err := repo.Outbox.MarkDead(ctx, second.ID, "bounced"); err != nil {
+			t.Fatal(err)
+		}
+
+		list := func(filter repository.OutboxFilter) []int64 {
+			t.Helper()
+			messages, err := repo.Outbox.List(ctx, filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int64
+			for _, msg := range messages {
+				ids = append(ids, msg.ID)
+			}
+			return ids
+		}
+
+		// The recipient matches regardless of case
+		if got, want := list(repository.OutboxFilter{Recipient: strings.ToUpper(to), Limit: 10}), []int64{third.ID, second.ID, first.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("by recipient = %v, want %v", got, want)
+		}
+		if got, want := list(repository.OutboxFilter{Recipient: to, Status: repository.OutboxDead, Limit: 10}), []int64{second.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("by status = %v, want %v", got, want)
+		}
+		if got, want := list(repository.OutboxFilter{Recipient: to, BeforeID: third.ID, Limit: 1}), []int64{second.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("paged = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("by user", func(t *testing.T) {
+		user := createUser(t, repo, "OutboxUser")
+		// An email to an address the user has since changed from is still
+		// theirs
+		var mine []*repository.OutboxMessage
+		for _, to := range []string{recipient(t), user.Email} {
+			msg := &repository.OutboxMessage{Kind: "test", UserID: &user.ID, Recipient: to, Subject: "Subject"}
+			if err := repo.Outbox.Enqueue(ctx, msg); err != nil {
+				t.Fatalf("Enqueue: %v", err)
+			}
+			mine = append(mine, msg)
+		}
+		kept := enqueue(t, user.Email)
+
+		if found := find(t, mine[0]); found.UserID == nil || *found.UserID != user.ID {
+			t.Errorf("UserID = %v, want %d", found.UserID, user.ID)
+		}
+		if found := find(t, kept); found.UserID != nil {
+			t.Errorf("UserID = %d, want nil", *found.UserID)
+		}
+		messages, err := repo.Outbox.List(ctx, repository.OutboxFilter{UserID: user.ID, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != 2 || messages[0].ID != mine[1].ID || messages[1].ID != mine[0].ID {
+			t.Errorf("by user = %d messages, want %d and %d", len(messages), mine[1].ID, mine[0].ID)
+		}
+
+		n, err := repo.Outbox.DeleteByUserID(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 {
+			t.Errorf("DeleteByUserID = %d, want 2", n)
+		}
+		messages, err = repo.Outbox.List(ctx, repository.OutboxFilter{UserID: user.ID, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != 0 {
+			t.Errorf("%d messages left for the user", len(messages))
+		}
+		find(t, kept)
+	})
+
+	t.Run("enqueued in a rolled back transaction", func(t *testing.T) {
+		to := recipient(t)
+		repo.Tx.WithinTx(ctx, func(ctx context.Context, tx *repository.Repository) error {
+			if err := tx.Outbox.Enqueue(ctx, &repository.OutboxMessage{Kind: "test", Recipient: to, Subject: "Subject"}); err != nil {
+				return err
+			}
+			return errors.New("abort")
+		})
+		messages, err := repo.Outbox.List(ctx, repository.OutboxFilter{Recipient: to, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != 0 {
+			t.Error("message from a rolled back transaction was kept")
+		}
+	})
+}
+
+func testSuppressions(t *testing.T, repo *repository.Repository) {
+	ctx := context.Background()
+
+	address := func(t *testing.T) string {
+		return fmt.Sprintf("Suppressed-%s@Example.com", randomHex(t, 8))
+	}
+
+	t.Run("Add and Find", func(t *testing.T) {
+		email := address(t)
+		suppression := &repository.EmailSuppression{Email: email, Reason: repository.SuppressionBounce, Detail: "550 5.1.1 user unknown"}
+		if err := repo.Suppression.Add(ctx, suppression); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		if suppression.Email != strings.ToLower(email) || suppression.CreatedAt.IsZero() || suppression.UpdatedAt.IsZero() {
+			t.Errorf("generated fields not set: %+v", suppression)
+		}
+
+		// Addresses match regardless of case
+		found, err := repo.Suppression.Find(ctx, strings.ToUpper(email))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found == nil {
+			t.Fatal("suppression not found")
+		}
+		if found.Email != strings.ToLower(email) || found.Reason != repository.SuppressionBounce || found.Detail != suppression.Detail {
+			t.Errorf("found %+v", found)
+		}
+		if !found.CreatedAt.Equal(suppression.CreatedAt) {
+			t.Errorf("created_at = %v, want %v", found.CreatedAt, suppression.CreatedAt)
+		}
+	})
+
+	t.Run("Add again replaces the reason", func(t *testing.T) {
+		email := address(t)
+		first := &repository.EmailSuppression{Email: email, Reason: repository.SuppressionBounce}
+		if err := repo.Suppression.Add(ctx, first); err != nil {
+			t.Fatal(err)
+		}
+		second := &repository.EmailSuppression{Email: strings.ToLower(email), Reason: repository.SuppressionComplaint, Detail: "abuse"}
+		if err := repo.Suppression.Add(ctx, second); err != nil {
+			t.Fatalf("Add existing: %v", err)
+		}
+
+		found, err := repo.Suppression.Find(ctx, email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.Reason != repository.SuppressionComplaint || found.Detail != "abuse" {
+			t.Errorf("found %+v, want the complaint", found)
+		}
+		if !found.CreatedAt.Equal(first.CreatedAt) {
+			t.Errorf("created_at changed from %v to %v", first.CreatedAt, found.CreatedAt)
+		}
+	})
+
+	t.Run("unknown reason", func(t *testing.T) {
+		if err := repo.Suppression.Add(ctx, &repository.EmailSuppression{Email: address(t), Reason: "unsubscribed"}); err == nil {
+			t.Error("Add accepted an unknown reason")
+		}
+	})
+
+	t.Run("Find missing", func(t *testing.T) {
+		found, err := repo.Suppression.Find(ctx, address(t))
+		if err != nil || found != nil {
+			t.Errorf("Find = %+v, %v; want nil, nil", found, err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		email := address(t)
+		if err := repo.Suppression.Add(ctx, &repository.EmailSuppression{Email: email, Reason: repository.SuppressionBounce}); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Suppression.Delete(ctx, strings.ToUpper(email)); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if found, err := repo.Suppression.Find(ctx, email); err != nil || found != nil {
+			t.Errorf("Find after Delete = %+v, %v", found, err)
+		}
+		wantNoRows(t, "Delete missing", repo.Suppression.Delete(ctx, email))
+	})
+}
+
+func testTx(t *testing.T, repo *repository.Repository) {
+	ctx := context.Background()
+	errAbort := errors.New("abort")
+
+	newUser := func(t *testing.T) *repository.User {
+		return &repository.User{
+			Email:        fmt.Sprintf("tx-%s@example.com", randomHex(t, 8)),
+			PasswordHash: "hash",
+		}
+	}
+	exists := func(t *testing.T, user *repository.User) bool {
+		t.Helper()
+		found, err := repo.User.FindByEmail(ctx, user.Email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return found != nil
+	}
+
+	t.Run("commits", func(t *testing.T) {
+		user := newUser(t)
+		err := repo.Tx.WithinTx(ctx, func(ctx context.Context, tx *repository.Repository) error {
+			if err := tx.User.Create(ctx, user); err != nil {
+				return err
+			}
+			return tx.User.SetVerificationToken(ctx, user.ID, randomHex(t, 32), time.Now().Add(time.Hour))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found := findUser(t, repo, user.ID); found.VerificationToken == nil {
+			t.Error("the second write in the transaction was lost")
+		}
+	})
+
+	t.Run("rolls back on error", func(t *testing.T) {
+		user := newUser(t)
+		err := repo.Tx.WithinTx(ctx, func(ctx context.Context, tx *repository.Repository) error {
+			if err := tx.User.Create(ctx, user); err != nil {
+				return err
+			}
+			return errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			t.Fatalf("WithinTx returned %v, want fn's error", err)
+		}
+		if exists(t, user) {
+			t.Error("user created in a rolled back transaction exists")
+		}
+	})
+
+	t.Run("rolls back on panic", func(t *testing.T) {
+		user := newUser(t)
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("the panic was swallowed")
+				}
+			}()
+			repo.Tx.WithinTx(ctx, func(ctx context.Context, tx *repository.Repository) error {
+				if err := tx.User.Create(ctx, user); err != nil {
+					return err
+				}
+				panic("boom")
+			})
+		}()
+		if exists(t, user) {
+			t.Error("user created in a panicking transaction exists")
+		}
+	})
+
+	t.Run("nested calls join the outer transaction", func(t *testing.T) {
+		outer, inner := newUser(t), newUser(t)
+		err := repo.Tx.WithinTx(ctx, func(ctx context.Context, tx *repository.Repository) error {
+			if err := tx.User.Create(ctx, outer); err != nil {
+				return err
+			}
+			err := tx.Tx.WithinTx(ctx, func(ctx context.Context, tx *repository.Repository) error {
+				// The outer transaction's writes are visible
+				found, err := tx.User.FindByEmail(ctx, outer.Email)
+				if err != nil {
+					return err
+				}
+				if found == nil {
+					return errors.New("outer write not visible in the nested call")
+				}
+				return tx.User.Create(ctx, inner)
+			})
+			if err != nil {
+				return err
+			}
+			// Rolling back the outer transaction undoes the nested writes
+			return errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			t.Fatalf("WithinTx returned %v, want fn's error", err)
+		}
+		if exists(t, outer) || exists(t, inner) {
+			t.Error("writes survived the outer transaction rolling back")
+		}
+	})
+}
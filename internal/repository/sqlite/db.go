@@ -0,0 +1,101 @@
+// internal/repository/sqlite/db.go
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"option-manager/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// dbtx is the subset of *sql.DB the repositories use
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// tracedDB wraps a dbtx so that every statement gets its own span, and
+// converts time arguments to UTC. SQLite compares timestamps as text, which
+// only orders correctly when they all have the same offset.
+type tracedDB struct {
+	db dbtx
+}
+
+func traced(db dbtx) *tracedDB {
+	return &tracedDB{db: db}
+}
+
+func (t *tracedDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	defer span.End()
+
+	result, err := t.db.ExecContext(ctx, query, utcArgs(args)...)
+	tracing.RecordError(span, err)
+	return result, err
+}
+
+func (t *tracedDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	defer span.End()
+
+	rows, err := t.db.QueryContext(ctx, query, utcArgs(args)...)
+	tracing.RecordError(span, err)
+	return rows, err
+}
+
+func (t *tracedDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, span := startQuerySpan(ctx, query)
+	defer span.End()
+
+	row := t.db.QueryRowContext(ctx, query, utcArgs(args)...)
+	tracing.RecordError(span, row.Err())
+	return row
+}
+
+func utcArgs(args []any) []any {
+	converted := make([]any, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case time.Time:
+			converted[i] = v.UTC()
+		case *time.Time:
+			if v != nil {
+				converted[i] = v.UTC()
+			} else {
+				converted[i] = nil
+			}
+		default:
+			converted[i] = arg
+		}
+	}
+	return converted
+}
+
+// now is the current time at the precision the repositories store, since
+// SQLite has no NOW() to match Postgres's
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// startQuerySpan names the span after the statement's verb, e.g. "SELECT",
+// and records the statement itself. Arguments are never recorded.
+func startQuerySpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	statement := strings.Join(strings.Fields(query), " ")
+	operation, _, _ := strings.Cut(statement, " ")
+
+	return tracing.Start(ctx, "sqlite "+strings.ToUpper(operation),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemSqlite,
+			semconv.DBOperationName(strings.ToUpper(operation)),
+			attribute.String("db.query.text", statement),
+		),
+	)
+}
@@ -0,0 +1,214 @@
+// internal/repository/sqlite/outbox.go
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"option-manager/internal/repository"
+	"time"
+)
+
+const outboxColumns = `
+            id, kind, user_id, recipient, subject, html_body, text_body, status,
+            attempts, last_error, next_attempt_at, sent_at, created_at,
+            updated_at`
+
+func scanOutboxMessage(row rowScanner) (*repository.OutboxMessage, error) {
+	msg := &repository.OutboxMessage{}
+	err := row.Scan(
+		&msg.ID,
+		&msg.Kind,
+		&msg.UserID,
+		&msg.Recipient,
+		&msg.Subject,
+		&msg.HTMLBody,
+		&msg.TextBody,
+		&msg.Status,
+		&msg.Attempts,
+		&msg.LastError,
+		&msg.NextAttemptAt,
+		&msg.SentAt,
+		&msg.CreatedAt,
+		&msg.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+type OutboxRepo struct {
+	db dbtx
+}
+
+func NewOutboxRepo(db *sql.DB) *OutboxRepo {
+	return &OutboxRepo{db: traced(db)}
+}
+
+func (r *OutboxRepo) Enqueue(ctx context.Context, msg *repository.OutboxMessage) error {
+	query := `
+        INSERT INTO email_outbox (
+            kind, user_id, recipient, subject, html_body, text_body,
+            next_attempt_at, created_at, updated_at
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $7, $7)
+        RETURNING id, status, attempts, next_attempt_at, created_at, updated_at`
+
+	return r.db.QueryRowContext(
+		ctx,
+		query,
+		msg.Kind,
+		msg.UserID,
+		msg.Recipient,
+		msg.Subject,
+		msg.HTMLBody,
+		msg.TextBody,
+		now(),
+	).Scan(&msg.ID, &msg.Status, &msg.Attempts, &msg.NextAttemptAt, &msg.CreatedAt, &msg.UpdatedAt)
+}
+
+func (r *OutboxRepo) ClaimDue(ctx context.Context, limit int, leaseUntil time.Time) ([]*repository.OutboxMessage, error) {
+	// SQLite runs one write at a time, so the update claims the messages
+	// without any locking clause
+	query := `
+        UPDATE email_outbox
+        SET attempts = attempts + 1,
+            next_attempt_at = $2,
+            updated_at = $3
+        WHERE id IN (
+            SELECT id
+            FROM email_outbox
+            WHERE status = 'pending' AND next_attempt_at <= $3
+            ORDER BY next_attempt_at, id
+            LIMIT $1
+        )
+        RETURNING` + outboxColumns
+
+	rows, err := r.db.QueryContext(ctx, query, limit, leaseUntil, now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*repository.OutboxMessage
+	for rows.Next() {
+		msg, err := scanOutboxMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+func (r *OutboxRepo) MarkSent(ctx context.Context, id int64, sentAt time.Time) error {
+	query := `
+        UPDATE email_outbox
+        SET status = 'sent',
+            sent_at = $2,
+            last_error = '',
+            html_body = '',
+            text_body = '',
+            updated_at = $3
+        WHERE id = $1`
+
+	result, err := r.db.ExecContext(ctx, query, id, sentAt, now())
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *OutboxRepo) MarkFailed(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error {
+	query := `
+        UPDATE email_outbox
+        SET last_error = $2,
+            next_attempt_at = $3,
+            updated_at = $4
+        WHERE id = $1`
+
+	result, err := r.db.ExecContext(ctx, query, id, lastError, nextAttemptAt, now())
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *OutboxRepo) MarkDead(ctx context.Context, id int64, lastError string) error {
+	query := `
+        UPDATE email_outbox
+        SET status = 'dead',
+            last_error = $2,
+            html_body = '',
+            text_body = '',
+            updated_at = $3
+        WHERE id = $1`
+
+	result, err := r.db.ExecContext(ctx, query, id, lastError, now())
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (r *OutboxRepo) List(ctx context.Context, filter repository.OutboxFilter) ([]*repository.OutboxMessage, error) {
+	query := `
+        SELECT` + outboxColumns + `
+        FROM email_outbox
+        WHERE ($1 = '' OR status = $1)
+          AND ($2 = '' OR recipient = $2 COLLATE NOCASE)
+          AND ($5 = 0 OR user_id = $5)
+          AND ($3 = 0 OR id < $3)
+        ORDER BY id DESC
+        LIMIT $4`
+
+	rows, err := r.db.QueryContext(ctx, query, filter.Status, filter.Recipient, filter.BeforeID, filter.Limit, filter.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*repository.OutboxMessage
+	for rows.Next() {
+		msg, err := scanOutboxMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+func (r *OutboxRepo) DeleteByUserID(ctx context.Context, userID int) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM email_outbox WHERE user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,31 @@
+// internal/repository/sqlite/repository.go
+
+// Package sqlite implements the repository interfaces on SQLite, for
+// single-user and self-hosted installs that don't want to run Postgres. It
+// uses the pure-Go modernc.org/sqlite driver, so builds don't need cgo.
+package sqlite
+
+import (
+	"database/sql"
+	"option-manager/internal/repository"
+)
+
+func NewRepository(db *sql.DB) *repository.Repository {
+	return newRepository(db, NewTxManager(db))
+}
+
+// newRepository builds the repositories on db, which is either the pool or
+// a transaction
+func newRepository(db dbtx, tx repository.TxManager) *repository.Repository {
+	t := traced(db)
+	return &repository.Repository{
+		User:        &UserRepo{db: t},
+		Session:     &SessionRepo{db: t},
+		Role:        &RoleRepo{db: t},
+		APIToken:    &APITokenRepo{db: t},
+		Audit:       &AuditRepo{db: t},
+		Outbox:      &OutboxRepo{db: t},
+		Suppression: &SuppressionRepo{db: t},
+		Tx:          tx,
+	}
+}
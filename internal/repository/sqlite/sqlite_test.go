@@ -0,0 +1,41 @@
+package sqlite_test
+
+import (
+	"database/sql"
+	"option-manager/internal/config"
+	"option-manager/internal/database"
+	"option-manager/internal/repository"
+	"option-manager/internal/repository/repotest"
+	"option-manager/internal/repository/sqlite"
+	"path/filepath"
+	"testing"
+)
+
+// TestContract runs the repository contract tests against a fresh database
+// migrated from the embedded SQLite migrations
+func TestContract(t *testing.T) {
+	dsn := config.DatabaseConfig{
+		Driver: config.DriverSQLite,
+		Path:   filepath.Join(t.TempDir(), "test.db"),
+	}.DSN()
+
+	migrator, err := database.NewMigrator(config.DriverSQLite, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = migrator.Up()
+	migrator.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open(config.DriverSQLite, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repotest.Run(t, func(t *testing.T) *repository.Repository {
+		return sqlite.NewRepository(db)
+	})
+}
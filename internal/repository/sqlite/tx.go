@@ -0,0 +1,95 @@
+// internal/repository/sqlite/tx.go
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"option-manager/internal/repository"
+	"option-manager/internal/tracing"
+	"time"
+
+	sqlitedriver "modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// maxTxAttempts bounds how often a transaction that couldn't get the
+// database lock is retried
+const maxTxAttempts = 3
+
+// TxManager runs transactions on SQLite. Only one can write at a time, and
+// the DSN makes each take the write lock when it begins, so inside fn every
+// statement must go through the repositories fn is given: a write on another
+// connection would wait for the transaction's own lock.
+type TxManager struct {
+	db *sql.DB
+}
+
+func NewTxManager(db *sql.DB) *TxManager {
+	return &TxManager{db: db}
+}
+
+// txKey marks a context as inside one of m's transactions. It holds the
+// manager so that a transaction on another database isn't joined by mistake.
+type txKey struct {
+	m *TxManager
+}
+
+func (m *TxManager) WithinTx(ctx context.Context, fn func(ctx context.Context, tx *repository.Repository) error) error {
+	if tx, ok := ctx.Value(txKey{m}).(*repository.Repository); ok {
+		return fn(ctx, tx)
+	}
+
+	ctx, span := tracing.Start(ctx, "sqlite transaction")
+	defer span.End()
+
+	var err error
+	for attempt := 1; attempt <= maxTxAttempts; attempt++ {
+		err = m.run(ctx, fn)
+		if !retryable(err) || attempt == maxTxAttempts {
+			break
+		}
+
+		slog.WarnContext(ctx, "retrying transaction", "attempt", attempt, "error", err)
+		select {
+		case <-time.After(time.Duration(attempt) * 10 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (m *TxManager) run(ctx context.Context, fn func(ctx context.Context, tx *repository.Repository) error) error {
+	sqlTx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			sqlTx.Rollback()
+			panic(p)
+		}
+	}()
+
+	tx := newRepository(sqlTx, m)
+	if err := fn(context.WithValue(ctx, txKey{m}, tx), tx); err != nil {
+		sqlTx.Rollback()
+		return err
+	}
+	return sqlTx.Commit()
+}
+
+// retryable reports whether err means the database was locked by another
+// connection for longer than the busy timeout
+func retryable(err error) bool {
+	var sqliteErr *sqlitedriver.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code() & 0xff // the primary code, without the extended bits
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
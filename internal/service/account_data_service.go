@@ -0,0 +1,515 @@
+// internal/service/account_data_service.go
+package service
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"option-manager/internal/logging"
+	"option-manager/internal/password"
+	"option-manager/internal/repository"
+	"option-manager/internal/tracing"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// deletionGracePeriod is how long an account lives on after its owner asks
+// for it to be deleted, during which they can change their mind
+const deletionGracePeriod = 14 * 24 * time.Hour
+
+// deletionBatchSize is how many due accounts one purge pass loads
+const deletionBatchSize = 50
+
+// deletionPurgeInterval is how often a running server looks for accounts
+// whose grace period has ended
+const deletionPurgeInterval = time.Hour
+
+// exportTimeFormat is how times are written in exports
+const exportTimeFormat = time.RFC3339
+
+// AccountDataService exports what we hold about a user and deletes it when
+// they ask
+type AccountDataService struct {
+	userRepo     repository.UserRepository
+	sessionRepo  repository.SessionRepository
+	tokenRepo    repository.APITokenRepository
+	outbox       repository.OutboxRepository
+	tx           repository.TxManager
+	emailService *EmailService
+	hasher       password.Hasher
+	audit        *AuditService
+
+	purgeInterval time.Duration
+}
+
+func NewAccountDataService(userRepo repository.UserRepository, sessionRepo repository.SessionRepository, tokenRepo repository.APITokenRepository, outbox repository.OutboxRepository, tx repository.TxManager, emailService *EmailService, hasher password.Hasher, audit *AuditService) (*AccountDataService, error) {
+	if userRepo == nil {
+		return nil, fmt.Errorf("user repository is required")
+	}
+	if sessionRepo == nil {
+		return nil, fmt.Errorf("session repository is required")
+	}
+	if tokenRepo == nil {
+		return nil, fmt.Errorf("API token repository is required")
+	}
+	if outbox == nil {
+		return nil, fmt.Errorf("outbox repository is required")
+	}
+	if tx == nil {
+		return nil, fmt.Errorf("transaction manager is required")
+	}
+	if emailService == nil {
+		return nil, fmt.Errorf("email service is required")
+	}
+	if hasher == nil {
+		return nil, fmt.Errorf("password hasher is required")
+	}
+	if audit == nil {
+		return nil, fmt.Errorf("audit service is required")
+	}
+	return &AccountDataService{
+		userRepo:      userRepo,
+		sessionRepo:   sessionRepo,
+		tokenRepo:     tokenRepo,
+		outbox:        outbox,
+		tx:            tx,
+		emailService:  emailService,
+		hasher:        hasher,
+		audit:         audit,
+		purgeInterval: deletionPurgeInterval,
+	}, nil
+}
+
+// exportReadme explains the files in an export
+const exportReadme = `This archive holds the data Options Manager keeps about your account.
+
+profile.json       your name, email address and account settings
+sessions.csv       the devices you are signed in on
+api_tokens.csv     your API tokens; the tokens themselves are never stored
+audit_events.json  the security history of your account
+emails.csv         the emails we have sent you; their contents aren't kept
+
+Times are in UTC.
+`
+
+type profileExport struct {
+	Email               string     `json:"email"`
+	FirstName           string     `json:"first_name"`
+	LastName            string     `json:"last_name"`
+	EmailVerified       bool       `json:"email_verified"`
+	PendingEmail        *string    `json:"pending_email,omitempty"`
+	Role                string     `json:"role"`
+	DisabledAt          *time.Time `json:"disabled_at,omitempty"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+// auditEventExport is an audit event as exported. Who acted is "you",
+// "someone else" (such as an administrator) or "system"; other users' IDs
+// aren't given out.
+type auditEventExport struct {
+	Type      string            `json:"type"`
+	Actor     string            `json:"actor"`
+	IPAddress string            `json:"ip_address,omitempty"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	Before    json.RawMessage   `json:"before,omitempty"`
+	After     json.RawMessage   `json:"after,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// ExportUserData writes a ZIP of everything held about the user to w
+func (s *AccountDataService) ExportUserData(ctx context.Context, userID int, w io.Writer) error {
+	ctx, span := tracing.Start(ctx, "AccountDataService.ExportUserData")
+	defer span.End()
+
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("error finding user: %w", err)
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{"README.txt", func(w io.Writer) error {
+			_, err := io.WriteString(w, exportReadme)
+			return err
+		}},
+		{"profile.json", func(w io.Writer) error { return s.exportProfile(w, user) }},
+		{"sessions.csv", func(w io.Writer) error { return s.exportSessions(ctx, w, userID) }},
+		{"api_tokens.csv", func(w io.Writer) error { return s.exportAPITokens(ctx, w, userID) }},
+		{"audit_events.json", func(w io.Writer) error { return s.exportAuditEvents(ctx, w, userID) }},
+		{"emails.csv", func(w io.Writer) error { return s.exportEmails(ctx, w, userID) }},
+	}
+	for _, file := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: time.Now(),
+		})
+		if err != nil {
+			return fmt.Errorf("error writing export: %w", err)
+		}
+		if err := file.write(fw); err != nil {
+			return fmt.Errorf("error exporting %s: %w", file.name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("error writing export: %w", err)
+	}
+
+	s.audit.Record(ctx, AuditEntry{
+		Type:          AuditDataExported,
+		ActorUserID:   userID,
+		SubjectUserID: userID,
+	})
+	return nil
+}
+
+func (s *AccountDataService) exportProfile(w io.Writer, user *repository.User) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(profileExport{
+		Email:               user.Email,
+		FirstName:           user.FirstName,
+		LastName:            user.LastName,
+		EmailVerified:       user.EmailVerified,
+		PendingEmail:        user.PendingEmail,
+		Role:                user.Role,
+		DisabledAt:          utcPtr(user.DisabledAt),
+		DeletionScheduledAt: utcPtr(user.DeletionScheduledAt),
+		CreatedAt:           user.CreatedAt.UTC(),
+		UpdatedAt:           user.UpdatedAt.UTC(),
+	})
+}
+
+// exportSessions leaves out session IDs, which mean nothing outside the app
+func (s *AccountDataService) exportSessions(ctx context.Context, w io.Writer, userID int) error {
+	sessions, err := s.sessionRepo.ListByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"ip_address", "user_agent", "remember_me", "created_at", "last_seen_at", "expires_at"})
+	for _, session := range sessions {
+		cw.Write([]string{
+			session.IPAddress,
+			session.UserAgent,
+			strconv.FormatBool(session.RememberMe),
+			formatExportTime(&session.CreatedAt),
+			formatExportTime(&session.LastSeenAt),
+			formatExportTime(&session.ExpiresAt),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (s *AccountDataService) exportAPITokens(ctx context.Context, w io.Writer, userID int) error {
+	tokens, err := s.tokenRepo.ListByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"name", "scopes", "created_at", "last_used_at", "expires_at"})
+	for _, token := range tokens {
+		cw.Write([]string{
+			token.Name,
+			strings.Join(token.Scopes, " "),
+			formatExportTime(&token.CreatedAt),
+			formatExportTime(token.LastUsedAt),
+			formatExportTime(token.ExpiresAt),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportAuditEvents writes the events about the user, oldest first. Events
+// they caused on other users' accounts, such as an administrator's actions,
+// are about those users and are left out.
+func (s *AccountDataService) exportAuditEvents(ctx context.Context, w io.Writer, userID int) error {
+	var events []auditEventExport
+	var beforeID int64
+	for {
+		page, err := s.audit.Search(ctx, repository.AuditFilter{
+			SubjectUserID: userID,
+			BeforeID:      beforeID,
+			Limit:         maxAuditPageSize,
+		})
+		if err != nil {
+			return err
+		}
+		for _, event := range page {
+			export := auditEventExport{
+				Type:      event.Type,
+				Actor:     "system",
+				Before:    event.Before,
+				After:     event.After,
+				Metadata:  event.Metadata,
+				CreatedAt: event.CreatedAt.UTC(),
+			}
+			if event.ActorUserID != nil {
+				export.Actor = "someone else"
+				// The IP address and device are only the user's own when
+				// they did it; otherwise they're staff's, or an attacker's
+				if *event.ActorUserID == userID {
+					export.Actor = "you"
+					export.IPAddress = event.IPAddress
+					export.UserAgent = event.UserAgent
+				}
+			}
+			events = append(events, export)
+		}
+		if len(page) < maxAuditPageSize {
+			break
+		}
+		beforeID = page[len(page)-1].ID
+	}
+
+	// Pages come newest first
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	if events == nil {
+		events = []auditEventExport{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(events)
+}
+
+// exportEmails lists the emails sent or queued for the user, including to
+// addresses they have since changed from. Bodies are left out: sent ones are
+// cleared anyway, and queued ones hold single-use links.
+func (s *AccountDataService) exportEmails(ctx context.Context, w io.Writer, userID int) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"recipient", "kind", "subject", "status", "created_at", "sent_at"})
+	var beforeID int64
+	for {
+		page, err := s.outbox.List(ctx, repository.OutboxFilter{
+			UserID:   userID,
+			BeforeID: beforeID,
+			Limit:    DefaultOutboxPageSize,
+		})
+		if err != nil {
+			return err
+		}
+		for _, msg := range page {
+			cw.Write([]string{
+				msg.Recipient,
+				msg.Kind,
+				msg.Subject,
+				msg.Status,
+				formatExportTime(&msg.CreatedAt),
+				formatExportTime(msg.SentAt),
+			})
+		}
+		if len(page) < DefaultOutboxPageSize {
+			break
+		}
+		beforeID = page[len(page)-1].ID
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatExportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(exportTimeFormat)
+}
+
+func utcPtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
+// RequestDeletion schedules the user's account for deletion after the grace
+// period, and emails them about it. It returns when the account will be
+// deleted; asking again keeps the original date.
+func (s *AccountDataService) RequestDeletion(ctx context.Context, userID int, currentPassword string) (time.Time, error) {
+	ctx, span := tracing.Start(ctx, "AccountDataService.RequestDeletion")
+	defer span.End()
+
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error finding user: %w", err)
+	}
+	if user == nil {
+		return time.Time{}, ErrUserNotFound
+	}
+
+	ok, err := s.hasher.Verify(currentPassword, user.PasswordHash)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error verifying password: %w", err)
+	}
+	if !ok {
+		return time.Time{}, fmt.Errorf("%w: current password is incorrect", ErrInvalidInput)
+	}
+	if user.DeletionScheduledAt != nil {
+		return *user.DeletionScheduledAt, nil
+	}
+
+	// Whole seconds, so the date returned is the one stored
+	at := time.Now().Add(deletionGracePeriod).Truncate(time.Second)
+	err = s.tx.WithinTx(ctx, func(ctx context.Context, tx *repository.Repository) error {
+		if err := tx.User.ScheduleDeletion(ctx, userID, &at); err != nil {
+			return fmt.Errorf("error scheduling deletion: %w", err)
+		}
+		return s.emailService.QueueAccountDeletionEmail(ctx, user, at)
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	s.audit.Record(ctx, AuditEntry{
+		Type:          AuditDeletionRequested,
+		ActorUserID:   userID,
+		SubjectUserID: userID,
+		Metadata:      map[string]string{"deletion_scheduled_at": at.UTC().Format(time.RFC3339)},
+	})
+	return at, nil
+}
+
+// CancelDeletion keeps the user's account if its deletion is still to come
+func (s *AccountDataService) CancelDeletion(ctx context.Context, userID int) error {
+	ctx, span := tracing.Start(ctx, "AccountDataService.CancelDeletion")
+	defer span.End()
+
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("error finding user: %w", err)
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if user.DeletionScheduledAt == nil {
+		return nil
+	}
+
+	err = s.userRepo.ScheduleDeletion(ctx, userID, nil)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Deleted since we looked
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error cancelling deletion: %w", err)
+	}
+
+	s.audit.Record(ctx, AuditEntry{
+		Type:          AuditDeletionCancelled,
+		ActorUserID:   userID,
+		SubjectUserID: userID,
+	})
+	return nil
+}
+
+// RunPurge deletes accounts as their grace period ends, until ctx is
+// cancelled. Any number of servers can run it against the same database.
+func (s *AccountDataService) RunPurge(ctx context.Context) {
+	ticker := time.NewTicker(s.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := s.PurgeDeletedAccounts(ctx); err != nil {
+			logging.FromContext(ctx).Error("failed to purge deleted accounts", "error", err)
+		} else if n > 0 {
+			logging.FromContext(ctx).Info("purged deleted accounts", "count", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeDeletedAccounts deletes every account whose grace period has ended
+// and returns how many it deleted. Each account goes in its own
+// transaction, with its sessions and API tokens, the emails queued or sent
+// for it, and the personal data in the audit events about it; the events
+// themselves are kept.
+func (s *AccountDataService) PurgeDeletedAccounts(ctx context.Context) (int, error) {
+	ctx, span := tracing.Start(ctx, "AccountDataService.PurgeDeletedAccounts")
+	defer span.End()
+
+	deleted := 0
+	for {
+		users, err := s.userRepo.ListDueForDeletion(ctx, time.Now(), deletionBatchSize)
+		if err != nil {
+			return deleted, fmt.Errorf("error listing accounts due for deletion: %w", err)
+		}
+		for _, user := range users {
+			ok, err := s.purgeUser(ctx, user.ID)
+			if err != nil {
+				return deleted, err
+			}
+			if ok {
+				deleted++
+			}
+		}
+		if len(users) < deletionBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// purgeUser deletes the user if they are still due for deletion, and
+// reports whether they were
+func (s *AccountDataService) purgeUser(ctx context.Context, userID int) (bool, error) {
+	var due bool
+	var redacted int64
+	err := s.tx.WithinTx(ctx, func(ctx context.Context, tx *repository.Repository) error {
+		// The user may have cancelled since the accounts were listed
+		user, err := tx.User.FindByID(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("error finding user: %w", err)
+		}
+		if user == nil || user.DeletionScheduledAt == nil || user.DeletionScheduledAt.After(time.Now()) {
+			return nil
+		}
+		due = true
+
+		if redacted, err = tx.Audit.RedactUser(ctx, userID); err != nil {
+			return fmt.Errorf("error redacting audit events: %w", err)
+		}
+		if _, err := tx.Outbox.DeleteByUserID(ctx, userID); err != nil {
+			return fmt.Errorf("error deleting emails: %w", err)
+		}
+		if err := tx.User.Delete(ctx, userID); err != nil {
+			return fmt.Errorf("error deleting user %d: %w", userID, err)
+		}
+		return nil
+	})
+	if err != nil || !due {
+		return false, err
+	}
+
+	logging.FromContext(ctx).Info("deleted account", "user_id", userID, "audit_events_redacted", redacted)
+	s.audit.Record(ctx, AuditEntry{
+		Type:          AuditUserDeleted,
+		SubjectUserID: userID,
+	})
+	return true, nil
+}
@@ -0,0 +1,358 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"option-manager/internal/email"
+	"option-manager/internal/repository"
+	"option-manager/internal/repository/memory"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPassword = "correct horse battery staple 9"
+
+type discardTransport struct{}
+
+func (discardTransport) Send(context.Context, *email.EmailContent) error { return nil }
+
+func newTestServices(t *testing.T) (*Services, *repository.Repository) {
+	t.Helper()
+	repo := memory.NewRepository()
+	services, err := NewServices(repo, discardTransport{}, Options{BaseURL: "https://example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return services, repo
+}
+
+func registerTestUser(t *testing.T, services *Services, email string) *repository.User {
+	t.Helper()
+	user, err := services.User.RegisterUser(context.Background(), RegistrationInput{
+		Email:     email,
+		Password:  testPassword,
+		FirstName: "Test",
+		LastName:  "User",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// makeDue moves the user's deletion date into the past
+func makeDue(t *testing.T, repo *repository.Repository, userID int) {
+	t.Helper()
+	past := time.Now().Add(-time.Minute)
+	if err := repo.User.ScheduleDeletion(context.Background(), userID, &past); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequestDeletionKeepsOriginalDate(t *testing.T) {
+	ctx := context.Background()
+	services, repo := newTestServices(t)
+	user := registerTestUser(t, services, "keep@example.com")
+
+	if _, err := services.AccountData.RequestDeletion(ctx, user.ID, "wrong password"); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("RequestDeletion with the wrong password: got %v, want ErrInvalidInput", err)
+	}
+
+	first, err := services.AccountData.RequestDeletion(ctx, user.ID, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Now().Add(deletionGracePeriod); first.Before(want.Add(-time.Minute)) || first.After(want) {
+		t.Errorf("deletion date = %v, want about %v", first, want)
+	}
+	second, err := services.AccountData.RequestDeletion(ctx, user.ID, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.Equal(first) {
+		t.Errorf("asking again moved the date from %v to %v", first, second)
+	}
+
+	messages, err := repo.Outbox.List(ctx, repository.OutboxFilter{UserID: user.ID, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var notices int
+	for _, msg := range messages {
+		if msg.Kind == emailDeletion {
+			notices++
+		}
+	}
+	if notices != 1 {
+		t.Errorf("queued %d deletion emails, want 1", notices)
+	}
+
+	if err := services.AccountData.CancelDeletion(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := repo.User.FindByID(ctx, user.ID); found.DeletionScheduledAt != nil {
+		t.Errorf("deletion still scheduled for %v after cancelling", found.DeletionScheduledAt)
+	}
+}
+
+func TestPurgeDeletedAccounts(t *testing.T) {
+	ctx := WithRequestInfo(context.Background(), RequestInfo{IPAddress: "192.0.2.1", UserAgent: "test agent"})
+	services, repo := newTestServices(t)
+	user := registerTestUser(t, services, "old@example.com")
+	other := registerTestUser(t, services, "other@example.com")
+
+	if _, err := services.Auth.CreateSession(ctx, user.ID, false, ClientInfo{IPAddress: "192.0.2.1", UserAgent: "test agent"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := services.APIToken.CreateToken(ctx, user.ID, CreateAPITokenInput{Name: "script", Scopes: []string{ScopeRead}}); err != nil {
+		t.Fatal(err)
+	}
+	// Leave emails behind at an address the user no longer has
+	if err := services.User.RequestEmailChange(ctx, user.ID, testPassword, "new@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := repo.User.FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.User.ConfirmPendingEmail(ctx, user.ID, *pending.EmailChangeToken); err != nil {
+		t.Fatal(err)
+	}
+	// Something the user did to another account
+	services.Audit.Record(ctx, AuditEntry{Type: AuditRoleChanged, ActorUserID: user.ID, SubjectUserID: other.ID, After: map[string]string{"role": "support"}})
+
+	if _, err := services.AccountData.RequestDeletion(ctx, user.ID, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := services.AccountData.PurgeDeletedAccounts(ctx); err != nil || n != 0 {
+		t.Fatalf("PurgeDeletedAccounts in the grace period = %d, %v; want 0, nil", n, err)
+	}
+	makeDue(t, repo, user.ID)
+	if n, err := services.AccountData.PurgeDeletedAccounts(ctx); err != nil || n != 1 {
+		t.Fatalf("PurgeDeletedAccounts = %d, %v; want 1, nil", n, err)
+	}
+
+	if found, _ := repo.User.FindByID(ctx, user.ID); found != nil {
+		t.Fatal("user still exists")
+	}
+	if sessions, _ := repo.Session.ListByUserID(ctx, user.ID); len(sessions) != 0 {
+		t.Errorf("%d sessions left", len(sessions))
+	}
+	if tokens, _ := repo.APIToken.ListByUserID(ctx, user.ID); len(tokens) != 0 {
+		t.Errorf("%d API tokens left", len(tokens))
+	}
+	for _, address := range []string{"old@example.com", "new@example.com"} {
+		if messages, _ := repo.Outbox.List(ctx, repository.OutboxFilter{Recipient: address, Limit: 10}); len(messages) != 0 {
+			t.Errorf("%d emails to %s left", len(messages), address)
+		}
+	}
+	if messages, _ := repo.Outbox.List(ctx, repository.OutboxFilter{UserID: other.ID, Limit: 10}); len(messages) == 0 {
+		t.Error("the other user's emails were deleted")
+	}
+
+	events, err := repo.Audit.List(ctx, repository.AuditFilter{UserID: user.ID, Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deleted bool
+	for _, event := range events {
+		about := event.SubjectUserID != nil && *event.SubjectUserID == user.ID
+		if event.Type == AuditUserDeleted && about {
+			deleted = true
+			continue
+		}
+		if event.IPAddress != "" || event.UserAgent != "" {
+			t.Errorf("%s event kept the user's IP address or user agent", event.Type)
+		}
+		if about && (event.Before != nil || event.After != nil || len(event.Metadata) != 0) {
+			t.Errorf("%s event about the user kept %+v", event.Type, event)
+		}
+		if !about && event.After == nil {
+			t.Errorf("%s event about another user lost its snapshot", event.Type)
+		}
+	}
+	if !deleted {
+		t.Error("the deletion wasn't audited")
+	}
+}
+
+func TestRunPurgeDeletesDueAccounts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	services, repo := newTestServices(t)
+	user := registerTestUser(t, services, "due@example.com")
+	if _, err := services.AccountData.RequestDeletion(ctx, user.ID, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	services.AccountData.purgeInterval = 10 * time.Millisecond
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		services.AccountData.RunPurge(ctx)
+	}()
+
+	// Not deleted during the grace period, then deleted once it ends
+	time.Sleep(50 * time.Millisecond)
+	if found, _ := repo.User.FindByID(ctx, user.ID); found == nil {
+		t.Fatal("user was deleted during the grace period")
+	}
+	makeDue(t, repo, user.ID)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		found, err := repo.User.FindByID(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("user wasn't deleted after their grace period ended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunPurge didn't stop when cancelled")
+	}
+}
+
+// cancellingUsers cancels each user's deletion right after listing them as
+// due, as if they had kept their account while the purge was running
+type cancellingUsers struct {
+	repository.UserRepository
+}
+
+func (r cancellingUsers) ListDueForDeletion(ctx context.Context, before time.Time, limit int) ([]*repository.User, error) {
+	users, err := r.UserRepository.ListDueForDeletion(ctx, before, limit)
+	for _, user := range users {
+		if err := r.ScheduleDeletion(ctx, user.ID, nil); err != nil {
+			return nil, err
+		}
+	}
+	return users, err
+}
+
+func TestPurgeSkipsCancelledDeletion(t *testing.T) {
+	ctx := context.Background()
+	services, repo := newTestServices(t)
+	user := registerTestUser(t, services, "changed-mind@example.com")
+	if _, err := services.AccountData.RequestDeletion(ctx, user.ID, testPassword); err != nil {
+		t.Fatal(err)
+	}
+	makeDue(t, repo, user.ID)
+
+	purger, err := NewAccountDataService(cancellingUsers{repo.User}, repo.Session, repo.APIToken, repo.Outbox, repo.Tx, services.Email, services.User.hasher, services.Audit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := purger.PurgeDeletedAccounts(ctx); err != nil || n != 0 {
+		t.Fatalf("PurgeDeletedAccounts = %d, %v; want 0, nil", n, err)
+	}
+	if found, _ := repo.User.FindByID(ctx, user.ID); found == nil {
+		t.Fatal("user was deleted after cancelling")
+	}
+	if messages, _ := repo.Outbox.List(ctx, repository.OutboxFilter{UserID: user.ID, Limit: 10}); len(messages) == 0 {
+		t.Error("user's emails were deleted after cancelling")
+	}
+}
+
+func TestExportUserData(t *testing.T) {
+	services, _ := newTestServices(t)
+	user := registerTestUser(t, services, "export@example.com")
+	ctx := WithActor(WithRequestInfo(context.Background(), RequestInfo{IPAddress: "192.0.2.1", UserAgent: "test agent"}), user.ID)
+
+	session, err := services.Auth.CreateSession(ctx, user.ID, true, ClientInfo{IPAddress: "192.0.2.1", UserAgent: "test agent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, rawToken, err := services.APIToken.CreateToken(ctx, user.ID, CreateAPITokenInput{Name: "script", Scopes: []string{ScopeRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Staff acting on the account from their own address and device
+	admin := registerTestUser(t, services, "admin@example.com")
+	adminCtx := WithActor(WithRequestInfo(context.Background(), RequestInfo{IPAddress: "198.51.100.7", UserAgent: "staff browser"}), admin.ID)
+	if err := services.Admin.ResendVerification(adminCtx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := services.AccountData.ExportUserData(ctx, user.ID, &buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string]string)
+	var names []string
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(body)
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	want := []string{"README.txt", "api_tokens.csv", "audit_events.json", "emails.csv", "profile.json", "sessions.csv"}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("files = %v, want %v", names, want)
+	}
+
+	for name, body := range files {
+		for secret, what := range map[string]string{
+			session.ID:      "session ID",
+			session.Token:   "session token",
+			token.TokenHash: "API token hash",
+			rawToken:        "API token",
+		} {
+			if secret != "" && strings.Contains(body, secret) {
+				t.Errorf("%s contains the %s", name, what)
+			}
+		}
+	}
+	if !strings.Contains(files["profile.json"], "export@example.com") {
+		t.Errorf("profile.json = %s, want the user's email", files["profile.json"])
+	}
+	if !strings.Contains(files["sessions.csv"], "test agent") {
+		t.Errorf("sessions.csv = %s, want the session", files["sessions.csv"])
+	}
+	if !strings.Contains(files["api_tokens.csv"], "script") {
+		t.Errorf("api_tokens.csv = %s, want the token", files["api_tokens.csv"])
+	}
+	if !strings.Contains(files["audit_events.json"], AuditUserRegistered) {
+		t.Errorf("audit_events.json = %s, want the registration", files["audit_events.json"])
+	}
+	if !strings.Contains(files["audit_events.json"], AuditVerificationResent) {
+		t.Errorf("audit_events.json = %s, want the admin's action", files["audit_events.json"])
+	}
+	if !strings.Contains(files["audit_events.json"], "192.0.2.1") {
+		t.Errorf("audit_events.json = %s, want the user's own IP address", files["audit_events.json"])
+	}
+	for _, staff := range []string{"198.51.100.7", "staff browser"} {
+		if strings.Contains(files["audit_events.json"], staff) {
+			t.Errorf("audit_events.json contains the admin's %q", staff)
+		}
+	}
+	if !strings.Contains(files["emails.csv"], emailVerification) {
+		t.Errorf("emails.csv = %s, want the verification email", files["emails.csv"])
+	}
+}
@@ -0,0 +1,213 @@
+// internal/service/api_token_service.go
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"option-manager/internal/repository"
+	"option-manager/internal/tracing"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scopes an API token can be granted
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// apiTokenPrefix makes tokens easy to recognise in scripts and secret scanners
+const apiTokenPrefix = "om_"
+
+// lastUsedUpdateInterval throttles how often a token's last-used time is
+// written back to the database
+const lastUsedUpdateInterval = time.Minute
+
+// ValidScopes lists every scope a token can be granted
+var ValidScopes = []string{ScopeRead, ScopeWrite}
+
+type APITokenService struct {
+	tokenRepo repository.APITokenRepository
+	userRepo  repository.UserRepository
+	audit     *AuditService
+}
+
+func NewAPITokenService(tokenRepo repository.APITokenRepository, userRepo repository.UserRepository, audit *AuditService) (*APITokenService, error) {
+	if tokenRepo == nil {
+		return nil, fmt.Errorf("API token repository is required")
+	}
+	if userRepo == nil {
+		return nil, fmt.Errorf("user repository is required")
+	}
+	if audit == nil {
+		return nil, fmt.Errorf("audit service is required")
+	}
+	return &APITokenService{
+		tokenRepo: tokenRepo,
+		userRepo:  userRepo,
+		audit:     audit,
+	}, nil
+}
+
+type CreateAPITokenInput struct {
+	Name      string
+	Scopes    []string
+	ExpiresIn time.Duration // zero means the token never expires
+}
+
+// CreateToken issues a new token and returns it along with the raw token
+// string. The raw string is not stored and can't be retrieved again.
+func (s *APITokenService) CreateToken(ctx context.Context, userID int, input CreateAPITokenInput) (*repository.APIToken, string, error) {
+	ctx, span := tracing.Start(ctx, "APITokenService.CreateToken")
+	defer span.End()
+
+	if userID <= 0 {
+		return nil, "", errors.New("invalid user ID")
+	}
+
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		return nil, "", errors.New("token name is required")
+	}
+	if len(name) > 100 {
+		return nil, "", errors.New("token name must be at most 100 characters")
+	}
+
+	if len(input.Scopes) == 0 {
+		return nil, "", errors.New("at least one scope is required")
+	}
+	for _, scope := range input.Scopes {
+		if !isValidScope(scope) {
+			return nil, "", fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+
+	raw, err := generateToken()
+	if err != nil {
+		return nil, "", err
+	}
+	raw = apiTokenPrefix + raw
+
+	token := &repository.APIToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashToken(raw),
+		Scopes:    input.Scopes,
+	}
+	if input.ExpiresIn > 0 {
+		expiresAt := time.Now().Add(input.ExpiresIn)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := s.tokenRepo.Create(ctx, token); err != nil {
+		return nil, "", fmt.Errorf("failed to create API token: %w", err)
+	}
+
+	metadata := map[string]string{
+		"token_id": strconv.Itoa(token.ID),
+		"name":     token.Name,
+		"scopes":   strings.Join(token.Scopes, " "),
+	}
+	if token.ExpiresAt != nil {
+		metadata["expires_at"] = token.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	s.audit.Record(ctx, AuditEntry{
+		Type:          AuditAPITokenCreated,
+		SubjectUserID: userID,
+		Metadata:      metadata,
+	})
+
+	return token, raw, nil
+}
+
+// ListTokens returns the user's tokens, newest first
+func (s *APITokenService) ListTokens(ctx context.Context, userID int) ([]*repository.APIToken, error) {
+	ctx, span := tracing.Start(ctx, "APITokenService.ListTokens")
+	defer span.End()
+
+	if userID <= 0 {
+		return nil, errors.New("invalid user ID")
+	}
+	return s.tokenRepo.ListByUserID(ctx, userID)
+}
+
+// RevokeToken deletes one of the user's tokens
+func (s *APITokenService) RevokeToken(ctx context.Context, userID int, tokenID int) error {
+	ctx, span := tracing.Start(ctx, "APITokenService.RevokeToken")
+	defer span.End()
+
+	if userID <= 0 {
+		return errors.New("invalid user ID")
+	}
+
+	err := s.tokenRepo.DeleteForUser(ctx, userID, tokenID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("token not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, AuditEntry{
+		Type:          AuditAPITokenRevoked,
+		SubjectUserID: userID,
+		Metadata:      map[string]string{"token_id": strconv.Itoa(tokenID)},
+	})
+	return nil
+}
+
+// Authenticate resolves a raw bearer token to its API token. It returns nil
+// if the token is unknown, expired or belongs to a disabled user.
+func (s *APITokenService) Authenticate(ctx context.Context, raw string) (*repository.APIToken, error) {
+	ctx, span := tracing.Start(ctx, "APITokenService.Authenticate")
+	defer span.End()
+
+	if !strings.HasPrefix(raw, apiTokenPrefix) {
+		return nil, nil
+	}
+
+	token, err := s.tokenRepo.FindByHash(ctx, hashToken(raw))
+	if err != nil || token == nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.FindByID(ctx, token.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("error finding user: %w", err)
+	}
+	if user == nil || user.DisabledAt != nil {
+		return nil, nil
+	}
+
+	now := time.Now()
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= lastUsedUpdateInterval {
+		if err := s.tokenRepo.UpdateLastUsed(ctx, token.ID, now); err != nil {
+			return nil, fmt.Errorf("failed to update token last used: %w", err)
+		}
+		token.LastUsedAt = &now
+	}
+
+	return token, nil
+}
+
+// HasScope reports whether the token was granted scope
+func HasScope(token *repository.APIToken, scope string) bool {
+	for _, s := range token.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func isValidScope(scope string) bool {
+	for _, s := range ValidScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+// internal/service/audit_service.go
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"option-manager/internal/logging"
+	"option-manager/internal/repository"
+	"option-manager/internal/tracing"
+)
+
+// Audit event types. Types are grouped by prefix so a search for "auth."
+// finds every sign-in related event.
+const (
+	AuditLoginSucceeded       = "auth.login.succeeded"
+	AuditLoginFailed          = "auth.login.failed"
+	AuditLogout               = "auth.logout"
+	AuditSessionRevoked       = "session.revoked"
+	AuditOtherSessionsRevoked = "session.revoked_others"
+	AuditAllSessionsRevoked   = "session.revoked_all"
+	AuditUserRegistered       = "user.registered"
+	AuditEmailVerified        = "user.email_verified"
+	AuditVerificationResent   = "user.verification_resent"
+	AuditPasswordChanged      = "user.password_changed"
+	AuditProfileUpdated       = "user.profile_updated"
+	AuditEmailChangeRequested = "user.email_change_requested"
+	AuditEmailChanged         = "user.email_changed"
+	AuditEmailChangeCancelled = "user.email_change_cancelled"
+	AuditDataExported         = "user.data_exported"
+	AuditDeletionRequested    = "user.deletion_requested"
+	AuditDeletionCancelled    = "user.deletion_cancelled"
+	AuditUserDeleted          = "user.deleted"
+	AuditUserDisabled         = "user.disabled"
+	AuditUserEnabled          = "user.enabled"
+	AuditRoleChanged          = "user.role_changed"
+	AuditAPITokenCreated      = "api_token.created"
+	AuditAPITokenRevoked      = "api_token.revoked"
+)
+
+// DefaultAuditPageSize is used when a search doesn't ask for a page size
+const DefaultAuditPageSize = 50
+
+// maxAuditPageSize caps how many events one search returns
+const maxAuditPageSize = 200
+
+// AuditEntry describes an event to record. ActorUserID defaults to the
+// signed-in user from the context; SubjectUserID is zero when the event isn't
+// about a particular user. Before and After are marshalled to JSON.
+type AuditEntry struct {
+	Type          string
+	ActorUserID   int
+	SubjectUserID int
+	Before        any
+	After         any
+	Metadata      map[string]string
+}
+
+// AuditService records security-relevant events to the audit log and reads
+// them back
+type AuditService struct {
+	auditRepo repository.AuditRepository
+}
+
+func NewAuditService(auditRepo repository.AuditRepository) (*AuditService, error) {
+	if auditRepo == nil {
+		return nil, fmt.Errorf("audit repository is required")
+	}
+	return &AuditService{
+		auditRepo: auditRepo,
+	}, nil
+}
+
+// Record appends an event to the audit log, tagged with the actor, IP and
+// request ID from ctx. Recording is best effort: a failure is logged rather
+// than failing the action being audited.
+func (s *AuditService) Record(ctx context.Context, entry AuditEntry) {
+	ctx, span := tracing.Start(ctx, "AuditService.Record")
+	defer span.End()
+
+	if err := s.record(ctx, entry); err != nil {
+		logging.FromContext(ctx).Error("failed to record audit event", "event_type", entry.Type, "error", err)
+	}
+}
+
+func (s *AuditService) record(ctx context.Context, entry AuditEntry) error {
+	info := RequestInfoFromContext(ctx)
+	event := &repository.AuditEvent{
+		Type:      entry.Type,
+		IPAddress: info.IPAddress,
+		UserAgent: info.UserAgent,
+		RequestID: info.RequestID,
+		Metadata:  entry.Metadata,
+	}
+
+	if entry.ActorUserID > 0 {
+		event.ActorUserID = &entry.ActorUserID
+	} else if actorID, ok := ActorFromContext(ctx); ok {
+		event.ActorUserID = &actorID
+	}
+	if entry.SubjectUserID > 0 {
+		event.SubjectUserID = &entry.SubjectUserID
+	}
+
+	var err error
+	if event.Before, err = marshalSnapshot(entry.Before); err != nil {
+		return err
+	}
+	if event.After, err = marshalSnapshot(entry.After); err != nil {
+		return err
+	}
+
+	return s.auditRepo.Create(ctx, event)
+}
+
+// ListForUser returns a page of events about the user's account, newest
+// first. Things the user did to other accounts aren't included.
+func (s *AuditService) ListForUser(ctx context.Context, userID int, beforeID int64) ([]*repository.AuditEvent, error) {
+	ctx, span := tracing.Start(ctx, "AuditService.ListForUser")
+	defer span.End()
+
+	if userID <= 0 {
+		return nil, errors.New("invalid user ID")
+	}
+	return s.Search(ctx, repository.AuditFilter{
+		SubjectUserID: userID,
+		BeforeID:      beforeID,
+	})
+}
+
+// Search returns a page of events matching filter, newest first
+func (s *AuditService) Search(ctx context.Context, filter repository.AuditFilter) ([]*repository.AuditEvent, error) {
+	ctx, span := tracing.Start(ctx, "AuditService.Search")
+	defer span.End()
+
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultAuditPageSize
+	}
+	if filter.Limit > maxAuditPageSize {
+		filter.Limit = maxAuditPageSize
+	}
+	return s.auditRepo.List(ctx, filter)
+}
+
+func marshalSnapshot(v any) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding audit snapshot: %w", err)
+	}
+	if string(data) == "null" {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// userSnapshot is the part of a user recorded in before/after snapshots.
+// It deliberately leaves out credentials and tokens.
+type userSnapshot struct {
+	Email         string `json:"email"`
+	FirstName     string `json:"first_name"`
+	LastName      string `json:"last_name"`
+	EmailVerified bool   `json:"email_verified"`
+	Role          string `json:"role"`
+	Disabled      bool   `json:"disabled"`
+}
+
+func snapshotUser(user *repository.User) *userSnapshot {
+	if user == nil {
+		return nil
+	}
+	return &userSnapshot{
+		Email:         user.Email,
+		FirstName:     user.FirstName,
+		LastName:      user.LastName,
+		EmailVerified: user.EmailVerified,
+		Role:          user.Role,
+		Disabled:      user.DisabledAt != nil,
+	}
+}
@@ -3,31 +3,85 @@ package service
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/base64"
+	"database/sql"
 	"errors"
 	"fmt"
+	"option-manager/internal/logging"
+	"option-manager/internal/metrics"
+	"option-manager/internal/password"
 	"option-manager/internal/repository"
+	"option-manager/internal/tracing"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
+// lastSeenUpdateInterval throttles how often a session's last-seen time is
+// written back to the database
+const lastSeenUpdateInterval = 5 * time.Minute
+
+// SessionPolicy controls how long sessions live. A session expires after
+// IdleTimeout without activity and can never outlive MaxLifetime, however
+// active it is. Remember-me sessions use the longer pair of limits.
+type SessionPolicy struct {
+	IdleTimeout           time.Duration
+	MaxLifetime           time.Duration
+	RememberMeIdleTimeout time.Duration
+	RememberMeMaxLifetime time.Duration
+}
+
+// DefaultSessionPolicy is used unless the caller configures its own
+var DefaultSessionPolicy = SessionPolicy{
+	IdleTimeout:           24 * time.Hour,
+	MaxLifetime:           7 * 24 * time.Hour,
+	RememberMeIdleTimeout: 30 * 24 * time.Hour,
+	RememberMeMaxLifetime: 90 * 24 * time.Hour,
+}
+
+func (p SessionPolicy) idleTimeout(rememberMe bool) time.Duration {
+	if rememberMe {
+		return p.RememberMeIdleTimeout
+	}
+	return p.IdleTimeout
+}
+
+func (p SessionPolicy) maxLifetime(rememberMe bool) time.Duration {
+	if rememberMe {
+		return p.RememberMeMaxLifetime
+	}
+	return p.MaxLifetime
+}
+
 type AuthService struct {
-	userRepo    repository.UserRepository
-	sessionRepo repository.SessionRepository
+	userRepo      repository.UserRepository
+	sessionRepo   repository.SessionRepository
+	roleRepo      repository.RoleRepository
+	hasher        password.Hasher
+	audit         *AuditService
+	sessionPolicy SessionPolicy
 }
 
-func NewAuthService(userRepo repository.UserRepository, sessionRepo repository.SessionRepository) (*AuthService, error) {
+func NewAuthService(userRepo repository.UserRepository, sessionRepo repository.SessionRepository, roleRepo repository.RoleRepository, hasher password.Hasher, audit *AuditService) (*AuthService, error) {
 	if userRepo == nil {
 		return nil, fmt.Errorf("user repository is required")
 	}
 	if sessionRepo == nil {
 		return nil, fmt.Errorf("session repository is required")
 	}
+	if roleRepo == nil {
+		return nil, fmt.Errorf("role repository is required")
+	}
+	if hasher == nil {
+		return nil, fmt.Errorf("password hasher is required")
+	}
+	if audit == nil {
+		return nil, fmt.Errorf("audit service is required")
+	}
 	return &AuthService{
-		userRepo:    userRepo,
-		sessionRepo: sessionRepo,
+		userRepo:      userRepo,
+		sessionRepo:   sessionRepo,
+		roleRepo:      roleRepo,
+		hasher:        hasher,
+		audit:         audit,
+		sessionPolicy: DefaultSessionPolicy,
 	}, nil
 }
 
@@ -36,8 +90,11 @@ type AuthenticateResponse struct {
 	Session *repository.Session
 }
 
-func (s *AuthService) Authenticate(ctx context.Context, email, password string) (*AuthenticateResponse, error) {
-	if email == "" || password == "" {
+func (s *AuthService) Authenticate(ctx context.Context, email, plaintext string) (*AuthenticateResponse, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.Authenticate")
+	defer span.End()
+
+	if email == "" || plaintext == "" {
 		return nil, errors.New("email and password are required")
 	}
 
@@ -47,44 +104,87 @@ func (s *AuthService) Authenticate(ctx context.Context, email, password string)
 		return nil, fmt.Errorf("error finding user: %w", err)
 	}
 	if user == nil {
+		s.recordLoginFailure(ctx, 0, email, "unknown_email")
 		return nil, errors.New("invalid email or password")
 	}
 
 	// Verify password
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+	ok, err := s.hasher.Verify(plaintext, user.PasswordHash)
 	if err != nil {
+		return nil, fmt.Errorf("error verifying password: %w", err)
+	}
+	if !ok {
+		s.recordLoginFailure(ctx, user.ID, email, "wrong_password")
 		return nil, errors.New("invalid email or password")
 	}
+	if user.DisabledAt != nil {
+		s.recordLoginFailure(ctx, user.ID, email, "disabled")
+		return nil, errors.New("this account has been disabled")
+	}
+
+	// Upgrade hashes made with an older algorithm or weaker parameters while
+	// we have the plaintext; failing to do so must not block the login
+	if s.hasher.NeedsRehash(user.PasswordHash) {
+		if hash, err := s.hasher.Hash(plaintext); err != nil {
+			logging.FromContext(ctx).Error("failed to rehash password", "user_id", user.ID, "error", err)
+		} else if err := s.userRepo.UpdatePasswordHash(ctx, user.ID, hash); err != nil {
+			logging.FromContext(ctx).Error("failed to store rehashed password", "user_id", user.ID, "error", err)
+		} else {
+			user.PasswordHash = hash
+		}
+	}
+
+	metrics.LoginSucceeded()
+	s.audit.Record(ctx, AuditEntry{
+		Type:          AuditLoginSucceeded,
+		ActorUserID:   user.ID,
+		SubjectUserID: user.ID,
+	})
 
 	return &AuthenticateResponse{
 		User: user,
 	}, nil
 }
 
-func (s *AuthService) CreateSession(ctx context.Context, userID int, rememberMe bool) (*repository.Session, error) {
+func (s *AuthService) recordLoginFailure(ctx context.Context, userID int, email, reason string) {
+	metrics.LoginFailed()
+	s.audit.Record(ctx, AuditEntry{
+		Type:          AuditLoginFailed,
+		SubjectUserID: userID,
+		Metadata:      map[string]string{"email": email, "reason": reason},
+	})
+}
+
+// ClientInfo describes the client a session was created from
+type ClientInfo struct {
+	IPAddress string
+	UserAgent string
+}
+
+func (s *AuthService) CreateSession(ctx context.Context, userID int, rememberMe bool, client ClientInfo) (*repository.Session, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.CreateSession")
+	defer span.End()
+
 	if userID <= 0 {
 		return nil, errors.New("invalid user ID")
 	}
 
-	// Generate random session ID
-	b := make([]byte, 32)
-	if _, err := rand.Read(b); err != nil {
+	token, err := generateToken()
+	if err != nil {
 		return nil, err
 	}
-	sessionID := base64.URLEncoding.EncodeToString(b)
 
 	// Set expiration based on remember-me
-	var expiresAt time.Time
-	if rememberMe {
-		expiresAt = time.Now().Add(30 * 24 * time.Hour) // 30 days
-	} else {
-		expiresAt = time.Now().Add(24 * time.Hour) // 24 hours
-	}
-
+	now := time.Now()
 	session := &repository.Session{
-		ID:        sessionID,
-		UserID:    userID,
-		ExpiresAt: expiresAt,
+		ID:                hashToken(token),
+		Token:             token,
+		UserID:            userID,
+		IPAddress:         client.IPAddress,
+		UserAgent:         client.UserAgent,
+		RememberMe:        rememberMe,
+		ExpiresAt:         now.Add(s.sessionPolicy.idleTimeout(rememberMe)),
+		AbsoluteExpiresAt: now.Add(s.sessionPolicy.maxLifetime(rememberMe)),
 	}
 
 	if err := s.sessionRepo.Create(ctx, session); err != nil {
@@ -94,16 +194,239 @@ func (s *AuthService) CreateSession(ctx context.Context, userID int, rememberMe
 	return session, nil
 }
 
-func (s *AuthService) GetSession(ctx context.Context, sessionID string) (*repository.Session, error) {
-	if sessionID == "" {
-		return nil, errors.New("session ID is required")
+// GetSession looks up a session by the raw token from the client's cookie
+func (s *AuthService) GetSession(ctx context.Context, token string) (*repository.Session, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.GetSession")
+	defer span.End()
+
+	if token == "" {
+		return nil, errors.New("session token is required")
+	}
+
+	session, err := s.sessionRepo.FindByID(ctx, hashToken(token))
+	if err != nil || session == nil {
+		return nil, err
+	}
+	session.Token = token
+	return session, nil
+}
+
+// DeleteSession deletes a session by the raw token from the client's cookie
+func (s *AuthService) DeleteSession(ctx context.Context, token string) error {
+	ctx, span := tracing.Start(ctx, "AuthService.DeleteSession")
+	defer span.End()
+
+	if token == "" {
+		return errors.New("session token is required")
 	}
-	return s.sessionRepo.FindByID(ctx, sessionID)
+	return s.sessionRepo.Delete(ctx, hashToken(token))
 }
 
-func (s *AuthService) DeleteSession(ctx context.Context, sessionID string) error {
+// Logout ends the session identified by the raw token from the client's
+// cookie and records the logout
+func (s *AuthService) Logout(ctx context.Context, token string) error {
+	ctx, span := tracing.Start(ctx, "AuthService.Logout")
+	defer span.End()
+
+	session, err := s.GetSession(ctx, token)
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		return nil
+	}
+
+	if err := s.sessionRepo.Delete(ctx, session.ID); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, AuditEntry{
+		Type:          AuditLogout,
+		ActorUserID:   session.UserID,
+		SubjectUserID: session.UserID,
+	})
+	return nil
+}
+
+// TouchSession records activity on a session and slides its idle expiry
+// forward, never past the absolute lifetime. Writes are throttled so that busy
+// sessions only hit the database once per lastSeenUpdateInterval. It reports
+// whether the session's expiry changed so the caller can re-issue the cookie.
+func (s *AuthService) TouchSession(ctx context.Context, session *repository.Session) (bool, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.TouchSession")
+	defer span.End()
+
+	if session == nil {
+		return false, errors.New("session is required")
+	}
+
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) < lastSeenUpdateInterval {
+		return false, nil
+	}
+
+	expiresAt := now.Add(s.sessionPolicy.idleTimeout(session.RememberMe))
+	if expiresAt.After(session.AbsoluteExpiresAt) {
+		expiresAt = session.AbsoluteExpiresAt
+	}
+
+	if err := s.sessionRepo.UpdateActivity(ctx, session.ID, now, expiresAt); err != nil {
+		return false, fmt.Errorf("failed to update session activity: %w", err)
+	}
+
+	extended := !expiresAt.Equal(session.ExpiresAt)
+	session.LastSeenAt = now
+	session.ExpiresAt = expiresAt
+	return extended, nil
+}
+
+// RotateSession replaces the session's ID while keeping its lifetime and
+// metadata. Call it on privilege changes to prevent session fixation.
+func (s *AuthService) RotateSession(ctx context.Context, session *repository.Session) (*repository.Session, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.RotateSession")
+	defer span.End()
+
+	if session == nil {
+		return nil, errors.New("session is required")
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	newID := hashToken(token)
+
+	if err := s.sessionRepo.Rotate(ctx, session.ID, newID); err != nil {
+		return nil, fmt.Errorf("failed to rotate session: %w", err)
+	}
+
+	rotated := *session
+	rotated.ID = newID
+	rotated.Token = token
+	return &rotated, nil
+}
+
+// ListSessions returns the user's active sessions, most recently used first
+func (s *AuthService) ListSessions(ctx context.Context, userID int) ([]*repository.Session, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.ListSessions")
+	defer span.End()
+
+	if userID <= 0 {
+		return nil, errors.New("invalid user ID")
+	}
+	return s.sessionRepo.ListByUserID(ctx, userID)
+}
+
+// RevokeSession deletes one of the user's sessions
+func (s *AuthService) RevokeSession(ctx context.Context, userID int, sessionID string) error {
+	ctx, span := tracing.Start(ctx, "AuthService.RevokeSession")
+	defer span.End()
+
+	if userID <= 0 {
+		return errors.New("invalid user ID")
+	}
 	if sessionID == "" {
 		return errors.New("session ID is required")
 	}
-	return s.sessionRepo.Delete(ctx, sessionID)
+
+	err := s.sessionRepo.DeleteForUser(ctx, userID, sessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("session not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, AuditEntry{
+		Type:          AuditSessionRevoked,
+		SubjectUserID: userID,
+	})
+	return nil
+}
+
+// RevokeOtherSessions signs the user out everywhere except the given session
+func (s *AuthService) RevokeOtherSessions(ctx context.Context, userID int, currentSessionID string) error {
+	ctx, span := tracing.Start(ctx, "AuthService.RevokeOtherSessions")
+	defer span.End()
+
+	if userID <= 0 {
+		return errors.New("invalid user ID")
+	}
+	if currentSessionID == "" {
+		return errors.New("session ID is required")
+	}
+
+	if err := s.sessionRepo.DeleteByUserID(ctx, userID, currentSessionID); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, AuditEntry{
+		Type:          AuditOtherSessionsRevoked,
+		SubjectUserID: userID,
+	})
+	return nil
+}
+
+// PurgeExpiredSessions deletes sessions past their expiry and returns how
+// many were removed. Expired sessions are already unusable; this only
+// reclaims the rows.
+func (s *AuthService) PurgeExpiredSessions(ctx context.Context) (int64, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.PurgeExpiredSessions")
+	defer span.End()
+
+	n, err := s.sessionRepo.DeleteExpired(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting expired sessions: %w", err)
+	}
+	return n, nil
+}
+
+// CountActiveSessions returns how many sessions haven't expired
+func (s *AuthService) CountActiveSessions(ctx context.Context) (int, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.CountActiveSessions")
+	defer span.End()
+
+	return s.sessionRepo.CountActive(ctx)
+}
+
+// Permissions returns the permissions granted by the user's role. Disabled
+// users have no permissions.
+func (s *AuthService) Permissions(ctx context.Context, userID int) ([]string, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.Permissions")
+	defer span.End()
+
+	if userID <= 0 {
+		return nil, errors.New("invalid user ID")
+	}
+
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error finding user: %w", err)
+	}
+	if user == nil || user.DisabledAt != nil {
+		return nil, nil
+	}
+
+	permissions, err := s.roleRepo.PermissionsForRole(ctx, user.Role)
+	if err != nil {
+		return nil, fmt.Errorf("error loading permissions: %w", err)
+	}
+	return permissions, nil
+}
+
+// HasPermission reports whether the user's role grants permission
+func (s *AuthService) HasPermission(ctx context.Context, userID int, permission string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "AuthService.HasPermission")
+	defer span.End()
+
+	permissions, err := s.Permissions(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range permissions {
+		if p == permission {
+			return true, nil
+		}
+	}
+	return false, nil
 }
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"option-manager/internal/password"
+	"option-manager/internal/repository"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuthenticateUpgradesLegacyHash(t *testing.T) {
+	ctx := context.Background()
+	services, repo := newTestServices(t)
+
+	// An account created before Argon2id, with a bcrypt hash
+	legacy, err := password.NewBcrypt(bcrypt.MinCost).Hash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &repository.User{Email: "legacy@example.com", PasswordHash: legacy, FirstName: "Legacy", LastName: "User"}
+	if err := repo.User.Create(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := services.Auth.Authenticate(ctx, user.Email, "wrong password"); err == nil {
+		t.Fatal("Authenticate with the wrong password succeeded")
+	}
+	if found, _ := repo.User.FindByID(ctx, user.ID); found.PasswordHash != legacy {
+		t.Error("a failed sign-in replaced the hash")
+	}
+
+	if _, err := services.Auth.Authenticate(ctx, user.Email, testPassword); err != nil {
+		t.Fatalf("Authenticate with a bcrypt hash: %v", err)
+	}
+	found, err := repo.User.FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(found.PasswordHash, "$argon2id$") {
+		t.Fatalf("hash after signing in = %q, want it upgraded to argon2id", found.PasswordHash)
+	}
+
+	// The upgraded hash works, and isn't rehashed again
+	if _, err := services.Auth.Authenticate(ctx, user.Email, testPassword); err != nil {
+		t.Fatalf("Authenticate with the upgraded hash: %v", err)
+	}
+	if again, _ := repo.User.FindByID(ctx, user.ID); again.PasswordHash != found.PasswordHash {
+		t.Error("a current hash was rehashed on sign-in")
+	}
+}
@@ -0,0 +1,227 @@
+// internal/service/email_change.go
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"option-manager/internal/repository"
+	"option-manager/internal/tracing"
+	"strings"
+	"time"
+)
+
+// emailChangeLifetime is how long the link confirming an email change works
+const emailChangeLifetime = 24 * time.Hour
+
+// RequestEmailChange starts changing the user's email to newEmail. The
+// user's email stays the same until they follow the link sent to the new
+// address; the old address is sent a link to cancel the change. Asking
+// again replaces a change in progress.
+func (s *UserService) RequestEmailChange(ctx context.Context, userID int, currentPassword, newEmail string) error {
+	ctx, span := tracing.Start(ctx, "UserService.RequestEmailChange")
+	defer span.End()
+
+	newEmail = strings.TrimSpace(newEmail)
+	if !strings.Contains(newEmail, "@") {
+		return fmt.Errorf("%w: invalid email address", ErrInvalidInput)
+	}
+
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(newEmail, user.Email) {
+		return fmt.Errorf("%w: that is already your email address", ErrInvalidInput)
+	}
+
+	ok, err := s.hasher.Verify(currentPassword, user.PasswordHash)
+	if err != nil {
+		return fmt.Errorf("error verifying password: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: current password is incorrect", ErrInvalidInput)
+	}
+
+	existing, err := s.userRepo.FindByEmail(ctx, newEmail)
+	if err != nil {
+		return fmt.Errorf("error checking existing user: %w", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("%w: email already registered", ErrInvalidInput)
+	}
+	// The confirmation would never arrive
+	if err := s.emailService.checkSuppressed(ctx, newEmail); errors.Is(err, ErrRecipientSuppressed) {
+		return fmt.Errorf("%w: we can't send email to %s", ErrInvalidInput, newEmail)
+	} else if err != nil {
+		return err
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return fmt.Errorf("error generating email change token: %w", err)
+	}
+	cancelToken, err := generateToken()
+	if err != nil {
+		return fmt.Errorf("error generating email change token: %w", err)
+	}
+
+	err = s.tx.WithinTx(ctx, func(ctx context.Context, tx *repository.Repository) error {
+		expiry := time.Now().Add(emailChangeLifetime)
+		if err := tx.User.SetPendingEmail(ctx, userID, newEmail, hashToken(token), hashToken(cancelToken), expiry); err != nil {
+			return fmt.Errorf("error saving email change: %w", err)
+		}
+		return s.emailService.QueueEmailChangeEmails(ctx, user, newEmail, token, cancelToken)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, AuditEntry{
+		Type:          AuditEmailChangeRequested,
+		SubjectUserID: userID,
+		Metadata:      map[string]string{"new_email": newEmail},
+	})
+	return nil
+}
+
+// FindEmailChange returns the user whose pending email change token
+// confirms, so the change can be shown before it is confirmed
+func (s *UserService) FindEmailChange(ctx context.Context, token string) (*repository.User, error) {
+	ctx, span := tracing.Start(ctx, "UserService.FindEmailChange")
+	defer span.End()
+
+	if token == "" {
+		return nil, fmt.Errorf("%w: invalid or expired link", ErrInvalidInput)
+	}
+	user, err := s.userRepo.FindByEmailChangeToken(ctx, hashToken(token))
+	if err != nil {
+		return nil, fmt.Errorf("error finding email change: %w", err)
+	}
+	if user == nil || user.PendingEmail == nil {
+		return nil, fmt.Errorf("%w: invalid or expired link", ErrInvalidInput)
+	}
+	if user.EmailChangeExpiry != nil && time.Now().After(*user.EmailChangeExpiry) {
+		return nil, fmt.Errorf("%w: this link has expired; ask for the change again", ErrInvalidInput)
+	}
+	return user, nil
+}
+
+// ConfirmEmailChange makes the pending email that token confirms the
+// user's email. If signOut is set, the user's sessions are signed out with
+// it, except current if it is one of theirs; current may be nil.
+func (s *UserService) ConfirmEmailChange(ctx context.Context, token string, signOut bool, current *repository.Session) (*repository.User, error) {
+	ctx, span := tracing.Start(ctx, "UserService.ConfirmEmailChange")
+	defer span.End()
+
+	before, err := s.FindEmailChange(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	// The link can be opened anywhere, including where someone else is
+	// signed in
+	keepSessionID := ""
+	if current != nil && current.UserID == before.ID {
+		keepSessionID = current.ID
+	}
+
+	err = s.tx.WithinTx(ctx, func(ctx context.Context, tx *repository.Repository) error {
+		err := tx.User.ConfirmPendingEmail(ctx, before.ID, hashToken(token))
+		if errors.Is(err, repository.ErrDuplicateEmail) {
+			return fmt.Errorf("%w: %s is now used by another account", ErrInvalidInput, *before.PendingEmail)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			// Cancelled, replaced by a newer change or expired since we
+			// looked
+			return fmt.Errorf("%w: invalid or expired link", ErrInvalidInput)
+		}
+		if err != nil {
+			return fmt.Errorf("error changing email: %w", err)
+		}
+
+		if signOut {
+			if err := tx.Session.DeleteByUserID(ctx, before.ID, keepSessionID); err != nil {
+				return fmt.Errorf("error revoking sessions: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := s.GetUser(ctx, before.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(ctx, AuditEntry{
+		Type:          AuditEmailChanged,
+		ActorUserID:   before.ID,
+		SubjectUserID: before.ID,
+		Before:        snapshotUser(before),
+		After:         snapshotUser(after),
+	})
+	if signOut {
+		revoked := AuditAllSessionsRevoked
+		if keepSessionID != "" {
+			revoked = AuditOtherSessionsRevoked
+		}
+		s.audit.Record(ctx, AuditEntry{
+			Type:          revoked,
+			ActorUserID:   before.ID,
+			SubjectUserID: before.ID,
+		})
+	}
+
+	return after, nil
+}
+
+// CancelEmailChange abandons the user's pending email change, if any
+func (s *UserService) CancelEmailChange(ctx context.Context, userID int) error {
+	ctx, span := tracing.Start(ctx, "UserService.CancelEmailChange")
+	defer span.End()
+
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return s.cancelEmailChange(ctx, user)
+}
+
+// CancelEmailChangeByToken abandons the email change that token, from the
+// notice sent to the old address, cancels
+func (s *UserService) CancelEmailChangeByToken(ctx context.Context, token string) error {
+	ctx, span := tracing.Start(ctx, "UserService.CancelEmailChangeByToken")
+	defer span.End()
+
+	if token == "" {
+		return fmt.Errorf("%w: invalid or expired link", ErrInvalidInput)
+	}
+	user, err := s.userRepo.FindByEmailCancelToken(ctx, hashToken(token))
+	if err != nil {
+		return fmt.Errorf("error finding email change: %w", err)
+	}
+	if user == nil {
+		return fmt.Errorf("%w: this email change was already confirmed or cancelled", ErrInvalidInput)
+	}
+	return s.cancelEmailChange(ctx, user)
+}
+
+func (s *UserService) cancelEmailChange(ctx context.Context, user *repository.User) error {
+	if user.PendingEmail == nil {
+		return nil
+	}
+	if err := s.userRepo.ClearPendingEmail(ctx, user.ID); err != nil {
+		return fmt.Errorf("error cancelling email change: %w", err)
+	}
+
+	s.audit.Record(ctx, AuditEntry{
+		Type:          AuditEmailChangeCancelled,
+		ActorUserID:   user.ID,
+		SubjectUserID: user.ID,
+		Metadata:      map[string]string{"new_email": *user.PendingEmail},
+	})
+	return nil
+}
@@ -0,0 +1,179 @@
+// internal/service/email_outbox.go
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"option-manager/internal/email"
+	"option-manager/internal/logging"
+	"option-manager/internal/metrics"
+	"option-manager/internal/repository"
+	"option-manager/internal/tracing"
+	"time"
+)
+
+// EmailDeliveryPolicy controls how queued emails are retried
+type EmailDeliveryPolicy struct {
+	// MaxAttempts is how many sends are tried before an email is given up on
+	MaxAttempts int
+	// PollInterval is how often the outbox is checked for due emails
+	PollInterval time.Duration
+}
+
+// DefaultEmailDeliveryPolicy retries for about an hour before giving up
+var DefaultEmailDeliveryPolicy = EmailDeliveryPolicy{
+	MaxAttempts:  8,
+	PollInterval: 5 * time.Second,
+}
+
+const (
+	// emailBatchSize is how many emails one delivery pass claims
+	emailBatchSize = 20
+	// emailSendTimeout bounds a single send
+	emailSendTimeout = 30 * time.Second
+	// emailLease is how long a claimed email is hidden from other workers.
+	// It must outlast sending a whole batch, one email at a time, or another
+	// worker could claim and send an email again while it is still queued
+	// here.
+	emailLease = emailBatchSize*emailSendTimeout + time.Minute
+	// The first retry waits emailRetryBase, doubling after every failure up
+	// to emailRetryMax
+	emailRetryBase = 30 * time.Second
+	emailRetryMax  = time.Hour
+)
+
+// DefaultOutboxPageSize is used when an outbox listing doesn't ask for a
+// page size
+const DefaultOutboxPageSize = 50
+
+// RunDelivery sends queued emails until ctx is cancelled. Any number of
+// servers can run it against the same database.
+func (s *EmailService) RunDelivery(ctx context.Context) {
+	ticker := time.NewTicker(s.delivery.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Keep going while there is a backlog rather than waiting a tick
+		// between batches
+		for {
+			n, err := s.DeliverQueued(ctx)
+			if err != nil {
+				logging.FromContext(ctx).Error("failed to deliver queued email", "error", err)
+			}
+			if err != nil || n < emailBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverQueued makes one attempt at each due email in a batch and returns
+// how many it claimed. Failed emails are retried with exponential backoff
+// until they run out of attempts.
+func (s *EmailService) DeliverQueued(ctx context.Context) (int, error) {
+	ctx, span := tracing.Start(ctx, "EmailService.DeliverQueued")
+	defer span.End()
+
+	if ctx.Err() != nil {
+		return 0, nil
+	}
+
+	messages, err := s.outbox.ClaimDue(ctx, emailBatchSize, time.Now().Add(emailLease))
+	if err != nil {
+		return 0, fmt.Errorf("error claiming queued email: %w", err)
+	}
+
+	for _, msg := range messages {
+		if err := s.deliver(ctx, msg); err != nil {
+			// The lease expires and the email is tried again
+			return len(messages), err
+		}
+	}
+	return len(messages), nil
+}
+
+// deliver sends msg and records the outcome. Only a failure to record it is
+// returned.
+func (s *EmailService) deliver(ctx context.Context, msg *repository.OutboxMessage) error {
+	// The address may have bounced since the email was queued
+	err := s.checkSuppressed(ctx, msg.Recipient)
+	if errors.Is(err, ErrRecipientSuppressed) {
+		logging.FromContext(ctx).Info("not sending email to suppressed address", "email_id", msg.ID, "kind", msg.Kind)
+		metrics.EmailDead(msg.Kind)
+		if err := s.outbox.MarkDead(ctx, msg.ID, err.Error()); err != nil {
+			return fmt.Errorf("error marking email %d dead: %w", msg.ID, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, emailSendTimeout)
+	sendErr := s.client.Send(sendCtx, &email.EmailContent{
+		To:       msg.Recipient,
+		Subject:  msg.Subject,
+		HTMLBody: msg.HTMLBody,
+		TextBody: msg.TextBody,
+	})
+	cancel()
+
+	// Record the outcome even if we're shutting down mid-send
+	ctx = context.WithoutCancel(ctx)
+
+	if sendErr == nil {
+		metrics.EmailSent(msg.Kind)
+		if err := s.outbox.MarkSent(ctx, msg.ID, time.Now()); err != nil {
+			return fmt.Errorf("error marking email %d sent: %w", msg.ID, err)
+		}
+		return nil
+	}
+
+	metrics.EmailFailed(msg.Kind)
+	log := logging.FromContext(ctx).With("email_id", msg.ID, "kind", msg.Kind, "attempts", msg.Attempts, "error", sendErr)
+
+	if msg.Attempts >= s.delivery.MaxAttempts {
+		log.Error("giving up on email")
+		metrics.EmailDead(msg.Kind)
+		if err := s.outbox.MarkDead(ctx, msg.ID, sendErr.Error()); err != nil {
+			return fmt.Errorf("error marking email %d dead: %w", msg.ID, err)
+		}
+		return nil
+	}
+
+	log.Warn("failed to send email; will retry")
+	if err := s.outbox.MarkFailed(ctx, msg.ID, sendErr.Error(), time.Now().Add(emailRetryDelay(msg.Attempts))); err != nil {
+		return fmt.Errorf("error marking email %d failed: %w", msg.ID, err)
+	}
+	return nil
+}
+
+// emailRetryDelay is how long to wait after the given number of failed
+// attempts
+func emailRetryDelay(attempts int) time.Duration {
+	delay := emailRetryBase
+	for i := 1; i < attempts && delay < emailRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, emailRetryMax)
+}
+
+// ListOutbox returns queued and delivered emails, newest first
+func (s *EmailService) ListOutbox(ctx context.Context, filter repository.OutboxFilter) ([]*repository.OutboxMessage, error) {
+	ctx, span := tracing.Start(ctx, "EmailService.ListOutbox")
+	defer span.End()
+
+	filter.Limit = DefaultOutboxPageSize
+	messages, err := s.outbox.List(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("error listing outbox: %w", err)
+	}
+	return messages, nil
+}
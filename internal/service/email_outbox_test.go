@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"option-manager/internal/email"
+	"option-manager/internal/repository"
+	"option-manager/internal/repository/memory"
+	"testing"
+	"time"
+)
+
+// failingTransport fails every send
+type failingTransport struct {
+	sends int
+}
+
+func (t *failingTransport) Send(context.Context, *email.EmailContent) error {
+	t.sends++
+	return errors.New("connection refused")
+}
+
+func TestEmailRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, emailRetryBase},
+		{1, emailRetryBase},
+		{2, 2 * emailRetryBase},
+		{3, 4 * emailRetryBase},
+		{7, 64 * emailRetryBase},
+		{8, emailRetryMax},
+		{100, emailRetryMax},
+	}
+	for _, tt := range tests {
+		if got := emailRetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("emailRetryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestEmailLeaseOutlastsBatch(t *testing.T) {
+	if emailLease <= emailBatchSize*emailSendTimeout {
+		t.Errorf("lease %v doesn't outlast a batch of %d sends of up to %v", emailLease, emailBatchSize, emailSendTimeout)
+	}
+}
+
+func TestDeliverQueuedRetriesThenGivesUp(t *testing.T) {
+	ctx := context.Background()
+	repo := memory.NewRepository()
+	templates, err := ParseEmailTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &failingTransport{}
+	s, err := NewEmailService(transport, templates, repo.Outbox, repo.Suppression, repo.Tx, "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.delivery.MaxAttempts = 3
+
+	msg := &repository.OutboxMessage{Kind: emailTest, Recipient: "a@example.com", Subject: "Hi", TextBody: "Hello"}
+	if err := repo.Outbox.Enqueue(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	load := func() *repository.OutboxMessage {
+		t.Helper()
+		messages, err := repo.Outbox.List(ctx, repository.OutboxFilter{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != 1 {
+			t.Fatalf("got %d messages, want 1", len(messages))
+		}
+		return messages[0]
+	}
+
+	for attempt := 1; attempt < s.delivery.MaxAttempts; attempt++ {
+		before := time.Now()
+		if n, err := s.DeliverQueued(ctx); err != nil || n != 1 {
+			t.Fatalf("attempt %d: DeliverQueued = %d, %v; want 1, nil", attempt, n, err)
+		}
+		got := load()
+		if got.Status != repository.OutboxPending {
+			t.Fatalf("attempt %d: status = %q, want %q", attempt, got.Status, repository.OutboxPending)
+		}
+		if got.LastError == "" {
+			t.Errorf("attempt %d: last error wasn't recorded", attempt)
+		}
+		wantNext := before.Add(emailRetryDelay(attempt))
+		if got.NextAttemptAt.Before(wantNext) || got.NextAttemptAt.After(wantNext.Add(time.Minute)) {
+			t.Errorf("attempt %d: next attempt at %v, want about %v", attempt, got.NextAttemptAt, wantNext)
+		}
+
+		// Not due again until the backoff has passed
+		if n, err := s.DeliverQueued(ctx); err != nil || n != 0 {
+			t.Fatalf("attempt %d: DeliverQueued before backoff = %d, %v; want 0, nil", attempt, n, err)
+		}
+		if err := repo.Outbox.MarkFailed(ctx, got.ID, got.LastError, time.Now().Add(-time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, err := s.DeliverQueued(ctx); err != nil || n != 1 {
+		t.Fatalf("last attempt: DeliverQueued = %d, %v; want 1, nil", n, err)
+	}
+	got := load()
+	if got.Status != repository.OutboxDead {
+		t.Fatalf("status = %q, want %q", got.Status, repository.OutboxDead)
+	}
+	if got.TextBody != "" || got.HTMLBody != "" {
+		t.Error("bodies were kept after giving up")
+	}
+	if transport.sends != s.delivery.MaxAttempts {
+		t.Errorf("sent %d times, want %d", transport.sends, s.delivery.MaxAttempts)
+	}
+
+	// Dead emails are never claimed again
+	if err := repo.Outbox.MarkFailed(ctx, got.ID, got.LastError, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.DeliverQueued(ctx); err != nil || n != 0 {
+		t.Fatalf("DeliverQueued after giving up = %d, %v; want 0, nil", n, err)
+	}
+}
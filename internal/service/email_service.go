@@ -2,31 +2,81 @@
 package service
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"html/template"
+	"option-manager/emails"
 	"option-manager/internal/email"
+	"option-manager/internal/metrics"
+	"option-manager/internal/repository"
+	"option-manager/internal/tracing"
+	"time"
 )
 
-// EmailService handles all email-related operations
+// Messages the app sends, named after their templates in the emails package
+const (
+	emailVerification = "verification"
+	emailChange       = "email-change"
+	emailChangeNotice = "email-change-notice"
+	emailDeletion     = "account-deletion"
+	emailTest         = "test"
+)
+
+// emailMessages maps each message to the data its template is rendered
+// with. ParseEmailTemplates checks every template against it.
+var emailMessages = map[string]any{
+	emailVerification: VerificationEmailData{},
+	emailChange:       EmailChangeData{},
+	emailChangeNotice: EmailChangeNoticeData{},
+	emailDeletion:     AccountDeletionData{},
+	emailTest:         TestEmailData{},
+}
+
+// ParseEmailTemplates parses the embedded email templates
+func ParseEmailTemplates() (*email.Templates, error) {
+	return email.ParseTemplates(emails.FS, emailMessages)
+}
+
+// EmailService handles all email-related operations. Emails sent on behalf
+// of users go through the outbox and are delivered by RunDelivery.
 type EmailService struct {
-	baseURL string
-	client  *email.Client
+	baseURL      string
+	client       email.Transport
+	templates    *email.Templates
+	outbox       repository.OutboxRepository
+	suppressions repository.SuppressionRepository
+	tx           repository.TxManager
+	delivery     EmailDeliveryPolicy
 }
 
 // NewEmailService creates a new EmailService
-func NewEmailService(emailClient *email.Client, baseURL string) (*EmailService, error) {
+func NewEmailService(emailClient email.Transport, templates *email.Templates, outbox repository.OutboxRepository, suppressions repository.SuppressionRepository, tx repository.TxManager, baseURL string) (*EmailService, error) {
 	if emailClient == nil {
 		return nil, fmt.Errorf("email client is required")
 	}
+	if templates == nil {
+		return nil, fmt.Errorf("email templates are required")
+	}
+	if outbox == nil {
+		return nil, fmt.Errorf("outbox repository is required")
+	}
+	if suppressions == nil {
+		return nil, fmt.Errorf("suppression repository is required")
+	}
+	if tx == nil {
+		return nil, fmt.Errorf("transaction manager is required")
+	}
 	if baseURL == "" {
 		return nil, fmt.Errorf("base URL is required")
 	}
 
 	return &EmailService{
-		client:  emailClient,
-		baseURL: baseURL,
+		client:       emailClient,
+		templates:    templates,
+		outbox:       outbox,
+		suppressions: suppressions,
+		tx:           tx,
+		baseURL:      baseURL,
+		delivery:     DefaultEmailDeliveryPolicy,
 	}, nil
 }
 
@@ -36,78 +86,174 @@ type VerificationEmailData struct {
 	VerificationLink string
 }
 
-// SendVerificationEmail sends an email verification link to the user
-func (s *EmailService) SendVerificationEmail(recipient, firstName, verificationToken string) error {
+// EmailChangeData holds data for the email sent to a new address to confirm
+// an email change
+type EmailChangeData struct {
+	FirstName   string
+	NewEmail    string
+	ConfirmLink string
+}
+
+// EmailChangeNoticeData holds data for the email warning the old address of
+// an email change
+type EmailChangeNoticeData struct {
+	FirstName  string
+	NewEmail   string
+	CancelLink string
+}
+
+// AccountDeletionData holds data for the email confirming that an account
+// will be deleted
+type AccountDeletionData struct {
+	FirstName    string
+	DeletionDate string
+	AccountLink  string
+}
+
+// TestEmailData holds data for the test email template
+type TestEmailData struct {
+	BaseURL string
+}
+
+// QueueVerificationEmail queues an email verification link for the user.
+// Called inside WithinTx, the email is only sent if the transaction commits.
+func (s *EmailService) QueueVerificationEmail(ctx context.Context, user *repository.User, verificationToken string) error {
+	ctx, span := tracing.Start(ctx, "EmailService.QueueVerificationEmail")
+	defer span.End()
+
 	data := VerificationEmailData{
-		FirstName:        firstName,
+		FirstName:        user.FirstName,
 		VerificationLink: fmt.Sprintf("%s/verify?token=%s", s.baseURL, verificationToken),
 	}
 
-	// Parse and execute template
-	htmlContent, err := s.executeTemplate(verificationEmailTemplate, data)
+	content, err := s.render(emailVerification, data)
 	if err != nil {
-		return fmt.Errorf("failed to generate email content: %v", err)
+		return err
 	}
 
-	// Generate plain text version
-	textContent := fmt.Sprintf("Please verify your email by visiting: %s", data.VerificationLink)
+	return s.enqueue(ctx, &repository.OutboxMessage{
+		Kind:      emailVerification,
+		UserID:    &user.ID,
+		Recipient: user.Email,
+		Subject:   content.Subject,
+		HTMLBody:  content.HTMLBody,
+		TextBody:  content.TextBody,
+	})
+}
+
+// QueueEmailChangeEmails queues a confirmation link to newEmail and a notice
+// with a cancel link to the user's current address. Called inside WithinTx,
+// neither is sent unless the transaction commits.
+func (s *EmailService) QueueEmailChangeEmails(ctx context.Context, user *repository.User, newEmail, confirmToken, cancelToken string) error {
+	ctx, span := tracing.Start(ctx, "EmailService.QueueEmailChangeEmails")
+	defer span.End()
+
+	confirm, err := s.render(emailChange, EmailChangeData{
+		FirstName:   user.FirstName,
+		NewEmail:    newEmail,
+		ConfirmLink: fmt.Sprintf("%s/account/email/confirm?token=%s", s.baseURL, confirmToken),
+	})
+	if err != nil {
+		return err
+	}
+	notice, err := s.render(emailChangeNotice, EmailChangeNoticeData{
+		FirstName:  user.FirstName,
+		NewEmail:   newEmail,
+		CancelLink: fmt.Sprintf("%s/account/email/cancel?token=%s", s.baseURL, cancelToken),
+	})
+	if err != nil {
+		return err
+	}
 
-	// Send email using the client
-	content := &email.EmailContent{
-		To:       recipient,
-		Subject:  "Verify Your Email - Options Manager",
-		HTMLBody: htmlContent,
-		TextBody: textContent,
+	if err := s.enqueue(ctx, &repository.OutboxMessage{
+		Kind:      emailChange,
+		UserID:    &user.ID,
+		Recipient: newEmail,
+		Subject:   confirm.Subject,
+		HTMLBody:  confirm.HTMLBody,
+		TextBody:  confirm.TextBody,
+	}); err != nil {
+		return err
 	}
+	return s.enqueue(ctx, &repository.OutboxMessage{
+		Kind:      emailChangeNotice,
+		UserID:    &user.ID,
+		Recipient: user.Email,
+		Subject:   notice.Subject,
+		HTMLBody:  notice.HTMLBody,
+		TextBody:  notice.TextBody,
+	})
+}
+
+// QueueAccountDeletionEmail queues a notice that the user's account will be
+// deleted at the given time, with a link to keep it. Called inside
+// WithinTx, the email is only sent if the transaction commits.
+func (s *EmailService) QueueAccountDeletionEmail(ctx context.Context, user *repository.User, at time.Time) error {
+	ctx, span := tracing.Start(ctx, "EmailService.QueueAccountDeletionEmail")
+	defer span.End()
 
-	if err := s.client.Send(context.Background(), content); err != nil {
-		return fmt.Errorf("failed to send verification email: %w", err)
+	content, err := s.render(emailDeletion, AccountDeletionData{
+		FirstName:    user.FirstName,
+		DeletionDate: at.UTC().Format("2 January 2006"),
+		AccountLink:  fmt.Sprintf("%s/account/data", s.baseURL),
+	})
+	if err != nil {
+		return err
 	}
 
+	return s.enqueue(ctx, &repository.OutboxMessage{
+		Kind:      emailDeletion,
+		UserID:    &user.ID,
+		Recipient: user.Email,
+		Subject:   content.Subject,
+		HTMLBody:  content.HTMLBody,
+		TextBody:  content.TextBody,
+	})
+}
+
+// enqueue adds msg to the outbox, joining the caller's transaction if there
+// is one
+func (s *EmailService) enqueue(ctx context.Context, msg *repository.OutboxMessage) error {
+	err := s.tx.WithinTx(ctx, func(ctx context.Context, tx *repository.Repository) error {
+		return tx.Outbox.Enqueue(ctx, msg)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to queue %s email: %w", msg.Kind, err)
+	}
 	return nil
 }
 
-// executeTemplate is a helper function to execute HTML templates
-func (s *EmailService) executeTemplate(tmpl string, data interface{}) (string, error) {
-	t, err := template.New("email").Parse(tmpl)
+// SendTestEmail sends a short message to check that email delivery works.
+// It skips the outbox so delivery problems are reported at once.
+func (s *EmailService) SendTestEmail(ctx context.Context, recipient string) error {
+	ctx, span := tracing.Start(ctx, "EmailService.SendTestEmail")
+	defer span.End()
+
+	content, err := s.render(emailTest, TestEmailData{BaseURL: s.baseURL})
 	if err != nil {
-		return "", fmt.Errorf("failed to parse template: %w", err)
+		return err
+	}
+	content.To = recipient
+
+	if err := s.checkSuppressed(ctx, recipient); err != nil {
+		return err
 	}
 
-	var buf bytes.Buffer
-	if err := t.Execute(&buf, data); err != nil {
-		return "", err
+	if err := s.client.Send(ctx, content); err != nil {
+		metrics.EmailFailed(emailTest)
+		return fmt.Errorf("failed to send test email: %w", err)
 	}
 
-	return buf.String(), nil
+	metrics.EmailSent(emailTest)
+	return nil
 }
 
-// Email templates
-const verificationEmailTemplate = `
-<!DOCTYPE html>
-<html>
-<head>
-    <meta charset="UTF-8">
-    <title>Verify Your Email</title>
-</head>
-<body style="font-family: Arial, sans-serif; line-height: 1.6; color: #333;">
-    <div style="max-width: 600px; margin: 0 auto; padding: 20px;">
-        <h2>Welcome to Options Manager!</h2>
-        <p>Hello {{.FirstName}},</p>
-        <p>Thank you for registering. Please verify your email address by clicking the button below:</p>
-        <p style="text-align: center;">
-            <a href="{{.VerificationLink}}" 
-               style="display: inline-block; padding: 12px 24px; background-color: #3b82f6; color: white; 
-                      text-decoration: none; border-radius: 4px; font-weight: bold;">
-                Verify Email Address
-            </a>
-        </p>
-        <p>If the button doesn't work, you can copy and paste this link into your browser:</p>
-        <p>{{.VerificationLink}}</p>
-        <p>This link will expire in 24 hours.</p>
-        <p>If you didn't create an account, you can safely ignore this email.</p>
-        <br>
-        <p>Best regards,<br>The Options Manager Team</p>
-    </div>
-</body>
-</html>`
+// render renders a message. Users have no language preference yet, so
+// every email is in the default locale.
+func (s *EmailService) render(name string, data any) (*email.EmailContent, error) {
+	content, err := s.templates.Render(name, email.DefaultLocale, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render %s email: %w", name, err)
+	}
+	return content, nil
+}
@@ -0,0 +1,78 @@
+// internal/service/email_suppression.go
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"option-manager/internal/logging"
+	"option-manager/internal/repository"
+	"option-manager/internal/tracing"
+	"strings"
+)
+
+// ErrRecipientSuppressed is returned when sending to an address that
+// bounced or complained
+var ErrRecipientSuppressed = errors.New("recipient is suppressed")
+
+// SuppressBounce stops email to an address that bounced permanently
+func (s *EmailService) SuppressBounce(ctx context.Context, address, detail string) error {
+	ctx, span := tracing.Start(ctx, "EmailService.SuppressBounce")
+	defer span.End()
+
+	return s.suppress(ctx, address, repository.SuppressionBounce, detail)
+}
+
+// SuppressComplaint stops email to an address whose owner reported our
+// email as spam
+func (s *EmailService) SuppressComplaint(ctx context.Context, address, detail string) error {
+	ctx, span := tracing.Start(ctx, "EmailService.SuppressComplaint")
+	defer span.End()
+
+	return s.suppress(ctx, address, repository.SuppressionComplaint, detail)
+}
+
+func (s *EmailService) suppress(ctx context.Context, address, reason, detail string) error {
+	address = strings.TrimSpace(address)
+	if !strings.Contains(address, "@") {
+		return fmt.Errorf("%w: invalid email address %q", ErrInvalidInput, address)
+	}
+
+	suppression := &repository.EmailSuppression{Email: address, Reason: reason, Detail: detail}
+	if err := s.suppressions.Add(ctx, suppression); err != nil {
+		return fmt.Errorf("error suppressing email address: %w", err)
+	}
+
+	logging.FromContext(ctx).Info("suppressed email address", "reason", reason, "detail", detail)
+	return nil
+}
+
+// Unsuppress lets email go to an address again, such as after its owner
+// fixed their mailbox. It returns ErrInvalidInput if it isn't suppressed.
+func (s *EmailService) Unsuppress(ctx context.Context, address string) error {
+	ctx, span := tracing.Start(ctx, "EmailService.Unsuppress")
+	defer span.End()
+
+	err := s.suppressions.Delete(ctx, strings.TrimSpace(address))
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %s is not suppressed", ErrInvalidInput, address)
+	}
+	if err != nil {
+		return fmt.Errorf("error lifting suppression: %w", err)
+	}
+	return nil
+}
+
+// checkSuppressed returns an error wrapping ErrRecipientSuppressed if
+// address mustn't be sent to
+func (s *EmailService) checkSuppressed(ctx context.Context, address string) error {
+	suppression, err := s.suppressions.Find(ctx, address)
+	if err != nil {
+		return fmt.Errorf("error checking suppressions: %w", err)
+	}
+	if suppression != nil {
+		return fmt.Errorf("%w after a %s", ErrRecipientSuppressed, suppression.Reason)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+// internal/service/permissions.go
+package service
+
+// Permissions granted to roles in the role_permissions table
+const (
+	PermissionAdminAccess             = "admin.access"
+	PermissionUsersRead               = "users.read"
+	PermissionUsersResendVerification = "users.resend_verification"
+	PermissionUsersDisable            = "users.disable"
+	PermissionUsersRevokeSessions     = "users.sessions.revoke"
+	PermissionAuditRead               = "audit.read"
+	PermissionEmailRead               = "email.read"
+)
+
+// Roles seeded by the migrations
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
@@ -0,0 +1,41 @@
+// internal/service/request_info.go
+package service
+
+import "context"
+
+// RequestInfo describes the request a service call is made on behalf of. It
+// is attached to the context by middleware and read back when recording
+// audit events.
+type RequestInfo struct {
+	RequestID string
+	IPAddress string
+	UserAgent string
+}
+
+type requestInfoKey struct{}
+
+type actorKey struct{}
+
+// WithRequestInfo returns a copy of ctx carrying info
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// RequestInfoFromContext returns the request info attached to ctx, or the
+// zero value if there is none
+func RequestInfoFromContext(ctx context.Context) RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info
+}
+
+// WithActor returns a copy of ctx recording userID as the user making the
+// request
+func WithActor(ctx context.Context, userID int) context.Context {
+	return context.WithValue(ctx, actorKey{}, userID)
+}
+
+// ActorFromContext returns the user making the request, if one is signed in
+func ActorFromContext(ctx context.Context) (int, bool) {
+	userID, ok := ctx.Value(actorKey{}).(int)
+	return userID, ok
+}
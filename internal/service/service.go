@@ -4,43 +4,104 @@ package service
 import (
 	"fmt"
 	"option-manager/internal/email"
+	"option-manager/internal/password"
 	"option-manager/internal/repository"
 )
 
 type Services struct {
-	Auth  *AuthService
-	User  *UserService
-	Email *EmailService
+	Auth        *AuthService
+	User        *UserService
+	Email       *EmailService
+	Admin       *AdminService
+	APIToken    *APITokenService
+	Audit       *AuditService
+	AccountData *AccountDataService
 }
 
-func NewServices(repo *repository.Repository, emailClient *email.Client, baseURL string) (*Services, error) {
+// Options holds the settings services are built with. Zero-valued policies
+// fall back to the defaults.
+type Options struct {
+	BaseURL        string
+	SessionPolicy  SessionPolicy
+	PasswordPolicy *password.Policy
+	EmailDelivery  EmailDeliveryPolicy
+}
+
+func NewServices(repo *repository.Repository, emailClient email.Transport, opts Options) (*Services, error) {
 	if repo == nil {
 		return nil, fmt.Errorf("repository is required")
 	}
-	if baseURL == "" {
+	if opts.BaseURL == "" {
 		return nil, fmt.Errorf("base URL is required")
 	}
+	if opts.SessionPolicy == (SessionPolicy{}) {
+		opts.SessionPolicy = DefaultSessionPolicy
+	}
+	if opts.PasswordPolicy == nil {
+		opts.PasswordPolicy = password.DefaultPolicy()
+	}
+	if opts.EmailDelivery == (EmailDeliveryPolicy{}) {
+		opts.EmailDelivery = DefaultEmailDeliveryPolicy
+	}
+
+	// Templates are parsed and checked once, so a broken one stops startup
+	emailTemplates, err := ParseEmailTemplates()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load email templates: %w", err)
+	}
 
 	// Create EmailService first since other services depend on it
-	emailService, err := NewEmailService(emailClient, baseURL)
+	emailService, err := NewEmailService(emailClient, emailTemplates, repo.Outbox, repo.Suppression, repo.Tx, opts.BaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create email service: %w", err)
 	}
+	emailService.delivery = opts.EmailDelivery
+
+	hasher := password.Default()
+
+	// Create AuditService next; every service below records to it
+	auditService, err := NewAuditService(repo.Audit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create audit service: %w", err)
+	}
 
 	// Create AuthService
-	authService, err := NewAuthService(repo.User, repo.Session)
+	authService, err := NewAuthService(repo.User, repo.Session, repo.Role, hasher, auditService)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create auth service: %w", err)
 	}
+	authService.sessionPolicy = opts.SessionPolicy
 
 	// Create UserService
-	userService, err := NewUserService(repo.User, emailService)
+	userService, err := NewUserService(repo.User, repo.Tx, emailService, hasher, opts.PasswordPolicy, auditService)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create user service: %w", err)
 	}
+
+	// Create AdminService
+	adminService, err := NewAdminService(repo.User, repo.Session, userService, auditService)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create admin service: %w", err)
+	}
+
+	// Create APITokenService
+	apiTokenService, err := NewAPITokenService(repo.APIToken, repo.User, auditService)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create API token service: %w", err)
+	}
+
+	// Create AccountDataService
+	accountDataService, err := NewAccountDataService(repo.User, repo.Session, repo.APIToken, repo.Outbox, repo.Tx, emailService, hasher, auditService)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create account data service: %w", err)
+	}
 	return &Services{
-		Auth:  authService,
-		User:  userService,
-		Email: emailService,
+		Auth:        authService,
+		User:        userService,
+		Email:       emailService,
+		Admin:       adminService,
+		APIToken:    apiTokenService,
+		Audit:       auditService,
+		AccountData: accountDataService,
 	}, nil
 }
@@ -3,32 +3,66 @@ package service
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/base64"
+	"database/sql"
 	"errors"
 	"fmt"
+	"option-manager/internal/password"
 	"option-manager/internal/repository"
+	"option-manager/internal/tracing"
 	"strings"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrUserNotFound is returned when a user ID doesn't match any user
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrInvalidInput wraps errors caused by bad caller input rather than a
+// failure on our side
+var ErrInvalidInput = errors.New("invalid input")
+
+// ErrUserModified is returned when an update was based on a stale copy of
+// the user
+var ErrUserModified = errors.New("user was modified by another request")
+
+// ErrEmailAlreadyVerified is returned when verifying, or sending a
+// verification link to, a user whose email is already verified
+var ErrEmailAlreadyVerified = errors.New("email is already verified")
+
 type UserService struct {
-	userRepo     repository.UserRepository
-	emailService *EmailService
+	userRepo       repository.UserRepository
+	tx             repository.TxManager
+	emailService   *EmailService
+	hasher         password.Hasher
+	passwordPolicy *password.Policy
+	audit          *AuditService
 }
 
-func NewUserService(userRepo repository.UserRepository, emailService *EmailService) (*UserService, error) {
+func NewUserService(userRepo repository.UserRepository, tx repository.TxManager, emailService *EmailService, hasher password.Hasher, passwordPolicy *password.Policy, audit *AuditService) (*UserService, error) {
 	if userRepo == nil {
 		return nil, fmt.Errorf("user repository is required")
 	}
+	if tx == nil {
+		return nil, fmt.Errorf("transaction manager is required")
+	}
 	if emailService == nil {
 		return nil, fmt.Errorf("email service is required")
 	}
+	if hasher == nil {
+		return nil, fmt.Errorf("password hasher is required")
+	}
+	if passwordPolicy == nil {
+		return nil, fmt.Errorf("password policy is required")
+	}
+	if audit == nil {
+		return nil, fmt.Errorf("audit service is required")
+	}
 	return &UserService{
-		userRepo:     userRepo,
-		emailService: emailService,
+		userRepo:       userRepo,
+		tx:             tx,
+		emailService:   emailService,
+		hasher:         hasher,
+		passwordPolicy: passwordPolicy,
+		audit:          audit,
 	}, nil
 }
 
@@ -37,9 +71,16 @@ type RegistrationInput struct {
 	Password  string
 	FirstName string
 	LastName  string
+	// Verified creates the user with their email already verified, and sends
+	// no verification email
+	Verified bool
 }
 
+// RegisterUser creates a user and queues their verification email
 func (s *UserService) RegisterUser(ctx context.Context, input RegistrationInput) (*repository.User, error) {
+	ctx, span := tracing.Start(ctx, "UserService.RegisterUser")
+	defer span.End()
+
 	if err := s.ValidateRegistration(input); err != nil {
 		return nil, err
 	}
@@ -54,7 +95,10 @@ func (s *UserService) RegisterUser(ctx context.Context, input RegistrationInput)
 	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	hashedPassword, err := s.hasher.Hash(input.Password)
+	if errors.Is(err, password.ErrTooLong) {
+		return nil, errors.New("password is too long")
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error hashing password: %w", err)
 	}
@@ -62,63 +106,226 @@ func (s *UserService) RegisterUser(ctx context.Context, input RegistrationInput)
 	// Create user
 	user := &repository.User{
 		Email:        input.Email,
-		PasswordHash: string(hashedPassword),
+		PasswordHash: hashedPassword,
 		FirstName:    input.FirstName,
 		LastName:     input.LastName,
 	}
 
-	if err := s.userRepo.Create(ctx, user); err != nil {
-		return nil, fmt.Errorf("error creating user: %w", err)
+	// The user, their token and the email are saved together, so a failure
+	// can't leave an account that has no way to be verified
+	err = s.tx.WithinTx(ctx, func(ctx context.Context, tx *repository.Repository) error {
+		// Two registrations can race past the check above; the repository
+		// catches the loser
+		if err := tx.User.Create(ctx, user); errors.Is(err, repository.ErrDuplicateEmail) {
+			return errors.New("email already registered")
+		} else if err != nil {
+			return fmt.Errorf("error creating user: %w", err)
+		}
+
+		if input.Verified {
+			if err := tx.User.UpdateVerificationStatus(ctx, user.ID, true); err != nil {
+				return fmt.Errorf("error verifying user: %w", err)
+			}
+			user.EmailVerified = true
+			return nil
+		}
+		return s.queueVerificationEmail(ctx, tx.User, user)
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	s.audit.Record(ctx, AuditEntry{
+		Type:          AuditUserRegistered,
+		ActorUserID:   user.ID,
+		SubjectUserID: user.ID,
+		After:         snapshotUser(user),
+	})
+
 	return user, nil
 }
 
-func (s *UserService) GenerateVerificationToken(ctx context.Context, userID int) (string, error) {
-	if userID <= 0 {
-		return "", errors.New("invalid user ID")
-	}
-
+// queueVerificationEmail gives user a new verification token through users
+// and queues the email carrying it. Both must be bound to the same
+// transaction.
+func (s *UserService) queueVerificationEmail(ctx context.Context, users repository.UserRepository, user *repository.User) error {
 	// Generate random token
-	b := make([]byte, 32)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
+	token, err := generateToken()
+	if err != nil {
+		return fmt.Errorf("error generating verification token: %w", err)
 	}
-	token := base64.URLEncoding.EncodeToString(b)
 
 	// Set expiration
 	expiry := time.Now().Add(24 * time.Hour)
 
-	// Save token
-	if err := s.userRepo.SetVerificationToken(ctx, userID, token, expiry); err != nil {
-		return "", err
+	// Save only the token's hash; the raw token goes out by email
+	tokenHash := hashToken(token)
+	if err := users.SetVerificationToken(ctx, user.ID, tokenHash, expiry); err != nil {
+		return fmt.Errorf("error saving verification token: %w", err)
+	}
+
+	user.VerificationToken = &tokenHash
+	user.VerificationExpiry = &expiry
+	return s.emailService.QueueVerificationEmail(ctx, user, token)
+}
+
+// GetUser returns the user with the given ID, or ErrUserNotFound
+func (s *UserService) GetUser(ctx context.Context, userID int) (*repository.User, error) {
+	ctx, span := tracing.Start(ctx, "UserService.GetUser")
+	defer span.End()
+
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error finding user: %w", err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// GetUserByEmail returns the user with the given email, or ErrUserNotFound
+func (s *UserService) GetUserByEmail(ctx context.Context, email string) (*repository.User, error) {
+	ctx, span := tracing.Start(ctx, "UserService.GetUserByEmail")
+	defer span.End()
+
+	user, err := s.userRepo.FindByEmail(ctx, strings.TrimSpace(email))
+	if err != nil {
+		return nil, fmt.Errorf("error finding user: %w", err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
 	}
+	return user, nil
+}
 
-	return token, nil
+type ProfileInput struct {
+	FirstName string
+	LastName  string
+}
+
+// UpdateProfile changes the user's name. The update only applies if the user
+// hasn't changed since expectedUpdatedAt; otherwise it returns ErrUserModified.
+func (s *UserService) UpdateProfile(ctx context.Context, userID int, input ProfileInput, expectedUpdatedAt time.Time) (*repository.User, error) {
+	ctx, span := tracing.Start(ctx, "UserService.UpdateProfile")
+	defer span.End()
+
+	firstName := strings.TrimSpace(input.FirstName)
+	lastName := strings.TrimSpace(input.LastName)
+	if firstName == "" {
+		return nil, fmt.Errorf("%w: first name is required", ErrInvalidInput)
+	}
+	if lastName == "" {
+		return nil, fmt.Errorf("%w: last name is required", ErrInvalidInput)
+	}
+
+	before, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.userRepo.UpdateProfile(ctx, userID, firstName, lastName, expectedUpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Either the user is gone or someone else got there first
+		if _, err := s.GetUser(ctx, userID); err != nil {
+			return nil, err
+		}
+		return nil, ErrUserModified
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error updating profile: %w", err)
+	}
+
+	after, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(ctx, AuditEntry{
+		Type:          AuditProfileUpdated,
+		SubjectUserID: userID,
+		Before:        snapshotUser(before),
+		After:         snapshotUser(after),
+	})
+
+	return after, nil
 }
 
 func (s *UserService) ValidateRegistration(input RegistrationInput) error {
 	if !strings.Contains(input.Email, "@") {
 		return errors.New("invalid email address")
 	}
-	if len(input.Password) < 8 {
-		return errors.New("password must be at least 8 characters long")
-	}
 	if strings.TrimSpace(input.FirstName) == "" {
 		return errors.New("first name is required")
 	}
 	if strings.TrimSpace(input.LastName) == "" {
 		return errors.New("last name is required")
 	}
+	return s.passwordPolicy.Check(input.Password, input.Email, input.FirstName, input.LastName)
+}
+
+// ChangePassword replaces the user's password after checking the current one.
+// The new password must satisfy the password policy; a rejection is returned
+// as a *password.PolicyError.
+func (s *UserService) ChangePassword(ctx context.Context, userID int, currentPassword, newPassword string) error {
+	ctx, span := tracing.Start(ctx, "UserService.ChangePassword")
+	defer span.End()
+
+	if userID <= 0 {
+		return errors.New("invalid user ID")
+	}
+	if currentPassword == "" || newPassword == "" {
+		return errors.New("current and new passwords are required")
+	}
+
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("error finding user: %w", err)
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+
+	ok, err := s.hasher.Verify(currentPassword, user.PasswordHash)
+	if err != nil {
+		return fmt.Errorf("error verifying password: %w", err)
+	}
+	if !ok {
+		return errors.New("current password is incorrect")
+	}
+
+	if err := s.passwordPolicy.Check(newPassword, user.Email, user.FirstName, user.LastName); err != nil {
+		return err
+	}
+
+	hashedPassword, err := s.hasher.Hash(newPassword)
+	if errors.Is(err, password.ErrTooLong) {
+		return errors.New("password is too long")
+	}
+	if err != nil {
+		return fmt.Errorf("error hashing password: %w", err)
+	}
+
+	if err := s.userRepo.UpdatePasswordHash(ctx, userID, hashedPassword); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, AuditEntry{
+		Type:          AuditPasswordChanged,
+		SubjectUserID: userID,
+	})
 	return nil
 }
 
 func (s *UserService) VerifyEmail(ctx context.Context, token string) error {
+	ctx, span := tracing.Start(ctx, "UserService.VerifyEmail")
+	defer span.End()
+
 	if token == "" {
 		return errors.New("verification token is required")
 	}
 	// Find user by verification token
-	user, err := s.userRepo.FindByVerificationToken(ctx, token)
+	user, err := s.userRepo.FindByVerificationToken(ctx, hashToken(token))
 	if err != nil {
 		return err
 	}
@@ -132,5 +339,36 @@ func (s *UserService) VerifyEmail(ctx context.Context, token string) error {
 	}
 
 	// Update verification status
-	return s.userRepo.UpdateVerificationStatus(ctx, user.ID, true)
+	if err := s.userRepo.UpdateVerificationStatus(ctx, user.ID, true); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, AuditEntry{
+		Type:          AuditEmailVerified,
+		ActorUserID:   user.ID,
+		SubjectUserID: user.ID,
+	})
+	return nil
+}
+
+// ResendVerification issues a fresh verification token and queues an email
+// with it. Links sent before stop working.
+func (s *UserService) ResendVerification(ctx context.Context, userID int) error {
+	ctx, span := tracing.Start(ctx, "UserService.ResendVerification")
+	defer span.End()
+
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("error finding user: %w", err)
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+
+	return s.tx.WithinTx(ctx, func(ctx context.Context, tx *repository.Repository) error {
+		return s.queueVerificationEmail(ctx, tx.User, user)
+	})
 }
@@ -0,0 +1,294 @@
+// internal/sns/sns.go
+
+// Package sns checks messages that Amazon SNS delivers to an HTTP(S)
+// subscription, and confirms those subscriptions.
+package sns
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message types
+const (
+	TypeNotification             = "Notification"
+	TypeSubscriptionConfirmation = "SubscriptionConfirmation"
+	TypeUnsubscribeConfirmation  = "UnsubscribeConfirmation"
+)
+
+// ErrInvalidSignature is returned for messages that SNS didn't sign, or
+// that it signed too long ago to accept
+var ErrInvalidSignature = errors.New("invalid SNS message signature")
+
+const (
+	// maxMessageAge is how old a message's Timestamp may be. SNS retries
+	// failed deliveries for about an hour by default; anything older is
+	// more likely a captured message being replayed.
+	maxMessageAge = time.Hour
+	// maxClockSkew allows for SNS's clock running ahead of ours
+	maxClockSkew = 5 * time.Minute
+	// maxCachedCerts caps the certificate cache. SNS signs with one
+	// certificate per region at a time, so a few cover rotation.
+	maxCachedCerts = 16
+)
+
+// Message is the JSON body SNS posts to a subscribed endpoint
+type Message struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token,omitempty"`
+	TopicARN         string `json:"TopicArn"`
+	Subject          string `json:"Subject,omitempty"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+	SubscribeURL     string `json:"SubscribeURL,omitempty"`
+	UnsubscribeURL   string `json:"UnsubscribeURL,omitempty"`
+}
+
+// Parse decodes a message body. It doesn't check the signature.
+func Parse(body []byte) (*Message, error) {
+	var msg Message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, fmt.Errorf("invalid SNS message: %w", err)
+	}
+	if msg.Type == "" || msg.TopicARN == "" {
+		return nil, errors.New("invalid SNS message: missing Type or TopicArn")
+	}
+	return &msg, nil
+}
+
+// StringToSign returns the text SNS signs for the message: selected fields
+// as name and value lines, in a fixed order
+func (m *Message) StringToSign() (string, error) {
+	var fields [][2]string
+	switch m.Type {
+	case TypeNotification:
+		fields = append(fields, [2]string{"Message", m.Message}, [2]string{"MessageId", m.MessageID})
+		// Subject is only signed when the message has one
+		if m.Subject != "" {
+			fields = append(fields, [2]string{"Subject", m.Subject})
+		}
+		fields = append(fields,
+			[2]string{"Timestamp", m.Timestamp},
+			[2]string{"TopicArn", m.TopicARN},
+			[2]string{"Type", m.Type},
+		)
+	case TypeSubscriptionConfirmation, TypeUnsubscribeConfirmation:
+		fields = [][2]string{
+			{"Message", m.Message},
+			{"MessageId", m.MessageID},
+			{"SubscribeURL", m.SubscribeURL},
+			{"Timestamp", m.Timestamp},
+			{"Token", m.Token},
+			{"TopicArn", m.TopicARN},
+			{"Type", m.Type},
+		}
+	default:
+		return "", fmt.Errorf("unknown SNS message type %q", m.Type)
+	}
+
+	var b strings.Builder
+	for _, field := range fields {
+		b.WriteString(field[0])
+		b.WriteByte('\n')
+		b.WriteString(field[1])
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// snsHost matches the hosts SNS serves signing certificates and
+// subscription links from
+var snsHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// checkURL makes sure raw points at SNS over HTTPS, so a forged message
+// can't make us fetch from, or trust a certificate on, any other host
+func checkURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" || !snsHost.MatchString(u.Host) {
+		return nil, fmt.Errorf("%q is not an SNS URL", raw)
+	}
+	return u, nil
+}
+
+// Verifier checks message signatures against the signing certificates SNS
+// publishes, which it fetches once and caches, up to a limit
+type Verifier struct {
+	client *http.Client
+
+	mu    sync.Mutex
+	certs map[string]*x509.Certificate
+}
+
+// NewVerifier returns a Verifier that fetches certificates and confirms
+// subscriptions with client, or a default client if it is nil
+func NewVerifier(client *http.Client) *Verifier {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Verifier{
+		client: client,
+		certs:  make(map[string]*x509.Certificate),
+	}
+}
+
+// Verify returns nil if SNS signed msg within the last hour. Messages that
+// fail the check return an error wrapping ErrInvalidSignature; other errors,
+// such as failing to fetch the certificate, may be temporary.
+func (v *Verifier) Verify(ctx context.Context, msg *Message) error {
+	var hash crypto.Hash
+	switch msg.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("%w: unsupported signature version %q", ErrInvalidSignature, msg.SignatureVersion)
+	}
+
+	certURL, err := checkURL(msg.SigningCertURL)
+	if err != nil {
+		return fmt.Errorf("%w: untrusted signing certificate: %v", ErrInvalidSignature, err)
+	}
+	if !strings.HasSuffix(certURL.Path, ".pem") {
+		return fmt.Errorf("%w: signing certificate %q is not a .pem file", ErrInvalidSignature, msg.SigningCertURL)
+	}
+
+	// The timestamp is signed, so once the signature checks out it can't
+	// have been moved forward to get an old message past this
+	sent, err := time.Parse(time.RFC3339, msg.Timestamp)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp %q", ErrInvalidSignature, msg.Timestamp)
+	}
+	if age := time.Since(sent); age > maxMessageAge || age < -maxClockSkew {
+		return fmt.Errorf("%w: message sent at %s is outside the accepted window", ErrInvalidSignature, msg.Timestamp)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: signature is not base64", ErrInvalidSignature)
+	}
+	signed, err := msg.StringToSign()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	cert, err := v.certificate(ctx, msg.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return fmt.Errorf("%w: signing certificate is not valid now", ErrInvalidSignature)
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: signing certificate has no RSA key", ErrInvalidSignature)
+	}
+
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum([]byte(signed))
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(signed))
+		digest = sum[:]
+	}
+	if err := rsa.VerifyPKCS1v15(key, hash, digest, signature); err != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (v *Verifier) certificate(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	v.mu.Lock()
+	cert, ok := v.certs[certURL]
+	v.mu.Unlock()
+	if ok {
+		return cert, nil
+	}
+
+	body, err := v.get(ctx, certURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signing certificate: %w", err)
+	}
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, fmt.Errorf("signing certificate %q is not PEM", certURL)
+	}
+	cert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing certificate: %w", err)
+	}
+
+	v.cache(certURL, cert)
+	return cert, nil
+}
+
+// cache stores cert, first dropping an arbitrary certificate if the cache is
+// full. A dropped certificate that is still in use is fetched again.
+func (v *Verifier) cache(certURL string, cert *x509.Certificate) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.certs[certURL]; !ok && len(v.certs) >= maxCachedCerts {
+		for cached := range v.certs {
+			delete(v.certs, cached)
+			break
+		}
+	}
+	v.certs[certURL] = cert
+}
+
+// ConfirmSubscription confirms the subscription a SubscriptionConfirmation
+// message asks about. Check the message's signature and topic first.
+func (v *Verifier) ConfirmSubscription(ctx context.Context, msg *Message) error {
+	if msg.Type != TypeSubscriptionConfirmation {
+		return fmt.Errorf("can't confirm a %s message", msg.Type)
+	}
+	if _, err := checkURL(msg.SubscribeURL); err != nil {
+		return fmt.Errorf("untrusted subscribe URL: %w", err)
+	}
+	if _, err := v.get(ctx, msg.SubscribeURL); err != nil {
+		return fmt.Errorf("failed to confirm subscription: %w", err)
+	}
+	return nil
+}
+
+func (v *Verifier) get(ctx context.Context, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+}
@@ -0,0 +1,123 @@
+package sns_test
+
+import (
+	"context"
+	"errors"
+	"option-manager/internal/sns"
+	"option-manager/internal/sns/snstest"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	signer := snstest.NewSigner(t)
+	verifier := sns.NewVerifier(signer.Client())
+
+	for _, name := range []string{"subscription-confirmation.json", "bounce-permanent.json", "complaint.json"} {
+		for _, version := range []string{"1", "2"} {
+			t.Run(name+" v"+version, func(t *testing.T) {
+				msg := snstest.Payload(t, name)
+				signer.Sign(t, msg, version)
+
+				// Round-trip through JSON, as the message arrives over HTTP
+				parsed, err := sns.Parse(snstest.Body(t, msg))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := verifier.Verify(ctx, parsed); err != nil {
+					t.Errorf("Verify: %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	ctx := context.Background()
+	signer := snstest.NewSigner(t)
+	verifier := sns.NewVerifier(signer.Client())
+
+	tests := []struct {
+		name   string
+		modify func(msg *sns.Message)
+	}{
+		{"recorded signature", nil},
+		{"changed message", func(msg *sns.Message) { msg.Message = `{"notificationType":"Bounce"}` }},
+		{"changed topic", func(msg *sns.Message) { msg.TopicARN = "arn:aws:sns:us-east-1:999999999999:other" }},
+		{"added subject", func(msg *sns.Message) { msg.Subject = "Hello" }},
+		{"changed timestamp", func(msg *sns.Message) {
+			msg.Timestamp = time.Now().Add(-time.Second).UTC().Format(time.RFC3339)
+		}},
+		{"unknown version", func(msg *sns.Message) { msg.SignatureVersion = "3" }},
+		{"certificate off SNS", func(msg *sns.Message) { msg.SigningCertURL = "https://attacker.example/cert.pem" }},
+		{"certificate over HTTP", func(msg *sns.Message) {
+			msg.SigningCertURL = "http://sns.us-east-1.amazonaws.com/SimpleNotificationService-x.pem"
+		}},
+		{"lookalike host", func(msg *sns.Message) {
+			msg.SigningCertURL = "https://sns.us-east-1.amazonaws.com.attacker.example/x.pem"
+		}},
+		{"certificate not .pem", func(msg *sns.Message) {
+			msg.SigningCertURL = "https://sns.us-east-1.amazonaws.com/?Action=Cert&x=.pem"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := snstest.Payload(t, "bounce-permanent.json")
+			if tt.modify != nil {
+				signer.Sign(t, msg, "2")
+				tt.modify(msg)
+			}
+			if err := verifier.Verify(ctx, msg); !errors.Is(err, sns.ErrInvalidSignature) {
+				t.Errorf("Verify = %v, want ErrInvalidSignature", err)
+			}
+		})
+	}
+
+	for name, at := range map[string]time.Time{
+		"replayed":    time.Now().Add(-2 * time.Hour),
+		"from future": time.Now().Add(time.Hour),
+	} {
+		t.Run(name, func(t *testing.T) {
+			msg := snstest.Payload(t, "bounce-permanent.json")
+			signer.SignAt(t, msg, "2", at)
+			if err := verifier.Verify(ctx, msg); !errors.Is(err, sns.ErrInvalidSignature) {
+				t.Errorf("Verify = %v, want ErrInvalidSignature", err)
+			}
+		})
+	}
+
+	t.Run("other key", func(t *testing.T) {
+		msg := snstest.Payload(t, "bounce-permanent.json")
+		snstest.NewSigner(t).Sign(t, msg, "2")
+		if err := verifier.Verify(ctx, msg); !errors.Is(err, sns.ErrInvalidSignature) {
+			t.Errorf("Verify = %v, want ErrInvalidSignature", err)
+		}
+	})
+}
+
+func TestConfirmSubscription(t *testing.T) {
+	ctx := context.Background()
+	signer := snstest.NewSigner(t)
+	verifier := sns.NewVerifier(signer.Client())
+
+	msg := snstest.Payload(t, "subscription-confirmation.json")
+	if err := verifier.ConfirmSubscription(ctx, msg); err != nil {
+		t.Fatalf("ConfirmSubscription: %v", err)
+	}
+	if fetched := signer.Fetched(); len(fetched) != 1 || fetched[0] != msg.SubscribeURL {
+		t.Errorf("fetched %q, want the subscribe URL", fetched)
+	}
+
+	msg.SubscribeURL = "https://attacker.example/?Action=ConfirmSubscription"
+	if err := verifier.ConfirmSubscription(ctx, msg); err == nil {
+		t.Error("confirmed a subscription off SNS")
+	}
+
+	if err := verifier.ConfirmSubscription(ctx, snstest.Payload(t, "bounce-permanent.json")); err == nil {
+		t.Error("confirmed a notification")
+	}
+	if n := len(signer.Fetched()); n != 1 {
+		t.Errorf("fetched %d URLs, want 1", n)
+	}
+}
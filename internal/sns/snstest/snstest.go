@@ -0,0 +1,157 @@
+// internal/sns/snstest/snstest.go
+
+// Package snstest provides recorded SNS payloads and a local stand-in for
+// SNS's signing key, for testing code that receives SNS messages.
+package snstest
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"option-manager/internal/sns"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//go:embed testdata/*.json
+var payloads embed.FS
+
+// TopicARN is the topic the recorded payloads were published to
+const TopicARN = "arn:aws:sns:us-east-1:123456789012:ses-notifications"
+
+// Payload returns a recorded message from testdata, as SNS posted it. The
+// signature in it is SNS's own and won't verify; use a Signer to re-sign it.
+func Payload(t testing.TB, name string) *sns.Message {
+	t.Helper()
+	body, err := payloads.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := sns.Parse(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// Signer signs messages the way SNS does, with a key and self-signed
+// certificate made for the test
+type Signer struct {
+	key     *rsa.PrivateKey
+	certPEM []byte
+
+	mu      sync.Mutex
+	fetched []string
+}
+
+func NewSigner(t testing.TB) *Signer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Signer{
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// Sign stamps msg with the current time, as SNS does when it sends, and
+// replaces its signature with one made with the signer's key, using SNS
+// signature version 1 (SHA1) or 2 (SHA256)
+func (s *Signer) Sign(t testing.TB, msg *sns.Message, version string) {
+	t.Helper()
+	s.SignAt(t, msg, version, time.Now())
+}
+
+// SignAt is like Sign, but stamps msg as sent at the given time
+func (s *Signer) SignAt(t testing.TB, msg *sns.Message, version string, at time.Time) {
+	t.Helper()
+	msg.Timestamp = at.UTC().Format("2006-01-02T15:04:05.000Z")
+	msg.SignatureVersion = version
+
+	signed, err := msg.StringToSign()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, digest := crypto.SHA256, sha256.Sum256([]byte(signed))
+	sum := digest[:]
+	if version == "1" {
+		digest := sha1.Sum([]byte(signed))
+		hash, sum = crypto.SHA1, digest[:]
+	}
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, hash, sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Signature = base64.StdEncoding.EncodeToString(signature)
+}
+
+// Body returns msg as the JSON SNS would post
+func Body(t testing.TB, msg *sns.Message) []byte {
+	t.Helper()
+	body, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// Client returns an HTTP client that never touches the network. It serves
+// the signer's certificate for every .pem URL and answers any other GET,
+// such as a subscription confirmation, with 200 OK after recording it.
+func (s *Signer) Client() *http.Client {
+	return &http.Client{Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+		body := []byte("<ConfirmSubscriptionResponse/>")
+		if strings.HasSuffix(req.URL.Path, ".pem") {
+			body = s.certPEM
+		} else {
+			s.mu.Lock()
+			s.fetched = append(s.fetched, req.URL.String())
+			s.mu.Unlock()
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Status:     "200 OK",
+			Body:       io.NopCloser(bytes.NewReader(body)),
+			Request:    req,
+		}, nil
+	})}
+}
+
+// Fetched returns the non-certificate URLs the client has fetched
+func (s *Signer) Fetched() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.fetched...)
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,24 @@
+package sns
+
+import (
+	"crypto/x509"
+	"fmt"
+	"testing"
+)
+
+func TestCertificateCacheIsCapped(t *testing.T) {
+	v := NewVerifier(nil)
+	for i := 0; i < 2*maxCachedCerts; i++ {
+		v.cache(fmt.Sprintf("https://sns.us-east-1.amazonaws.com/SimpleNotificationService-%d.pem", i), &x509.Certificate{})
+		if n := len(v.certs); n > maxCachedCerts {
+			t.Fatalf("cached %d certificates, want at most %d", n, maxCachedCerts)
+		}
+	}
+
+	// Caching a certificate again doesn't evict another
+	last := fmt.Sprintf("https://sns.us-east-1.amazonaws.com/SimpleNotificationService-%d.pem", 2*maxCachedCerts-1)
+	v.cache(last, &x509.Certificate{})
+	if n := len(v.certs); n != maxCachedCerts {
+		t.Errorf("cached %d certificates, want %d", n, maxCachedCerts)
+	}
+}
@@ -0,0 +1,4 @@
+ALTER TABLE sessions
+  ADD COLUMN ip_address TEXT NOT NULL DEFAULT '',
+  ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+  ADD COLUMN last_seen_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP;
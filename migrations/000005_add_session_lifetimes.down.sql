@@ -0,0 +1,3 @@
+ALTER TABLE sessions
+  DROP COLUMN remember_me,
+  DROP COLUMN absolute_expires_at;
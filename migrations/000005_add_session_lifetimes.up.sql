@@ -0,0 +1,7 @@
+ALTER TABLE sessions
+  ADD COLUMN remember_me BOOLEAN NOT NULL DEFAULT FALSE,
+  ADD COLUMN absolute_expires_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE sessions SET absolute_expires_at = expires_at;
+
+ALTER TABLE sessions ALTER COLUMN absolute_expires_at SET NOT NULL;
@@ -0,0 +1,7 @@
+-- Hashes can't be reversed, so invalidate everything that was stored hashed.
+DELETE FROM sessions;
+
+UPDATE users
+SET verification_token = NULL,
+    verification_expires_at = NULL
+WHERE verification_token IS NOT NULL;
@@ -0,0 +1,7 @@
+-- Session IDs and verification tokens are now stored as SHA-256 hex digests.
+-- Hash existing values in place so current sessions and links keep working.
+UPDATE sessions SET id = encode(digest(id, 'sha256'), 'hex');
+
+UPDATE users
+SET verification_token = encode(digest(verification_token, 'sha256'), 'hex')
+WHERE verification_token IS NOT NULL;
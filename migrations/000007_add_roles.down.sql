@@ -0,0 +1,7 @@
+ALTER TABLE users
+  DROP COLUMN role,
+  DROP COLUMN disabled_at;
+
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS roles;
@@ -0,0 +1,43 @@
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(50) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    name VARCHAR(100) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_name VARCHAR(50) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    permission_name VARCHAR(100) NOT NULL REFERENCES permissions(name) ON DELETE CASCADE,
+    PRIMARY KEY (role_name, permission_name)
+);
+
+INSERT INTO roles (name, description) VALUES
+    ('user', 'Regular user'),
+    ('support', 'Support staff who can look up users and help with verification'),
+    ('admin', 'Administrator with full access');
+
+INSERT INTO permissions (name, description) VALUES
+    ('admin.access', 'Open the admin area'),
+    ('users.read', 'List and search users'),
+    ('users.resend_verification', 'Resend verification emails'),
+    ('users.disable', 'Disable and re-enable accounts'),
+    ('users.sessions.revoke', 'Force-logout a user''s sessions');
+
+INSERT INTO role_permissions (role_name, permission_name) VALUES
+    ('support', 'admin.access'),
+    ('support', 'users.read'),
+    ('support', 'users.resend_verification'),
+    ('admin', 'admin.access'),
+    ('admin', 'users.read'),
+    ('admin', 'users.resend_verification'),
+    ('admin', 'users.disable'),
+    ('admin', 'users.sessions.revoke');
+
+ALTER TABLE users
+  ADD COLUMN role VARCHAR(50) NOT NULL DEFAULT 'user' REFERENCES roles(name),
+  ADD COLUMN disabled_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_users_role ON users(role);
@@ -0,0 +1,41 @@
+-- No foreign keys: events must outlive the users and sessions they mention
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR(100) NOT NULL,
+    actor_user_id INTEGER,
+    subject_user_id INTEGER,
+    ip_address TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    request_id VARCHAR(64) NOT NULL DEFAULT '',
+    before_state JSONB,
+    after_state JSONB,
+    metadata JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_audit_events_subject_user_id ON audit_events(subject_user_id, id DESC);
+CREATE INDEX idx_audit_events_actor_user_id ON audit_events(actor_user_id, id DESC);
+CREATE INDEX idx_audit_events_event_type ON audit_events(event_type, id DESC);
+CREATE INDEX idx_audit_events_request_id ON audit_events(request_id) WHERE request_id <> '';
+CREATE INDEX idx_audit_events_created_at ON audit_events(created_at);
+
+-- The log is append-only: reject every update, delete and truncate
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_no_modify
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+CREATE TRIGGER audit_events_no_truncate
+    BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+
+INSERT INTO permissions (name, description) VALUES
+    ('audit.read', 'Search the audit log');
+
+INSERT INTO role_permissions (role_name, permission_name) VALUES
+    ('admin', 'audit.read');
@@ -0,0 +1,28 @@
+-- Email is written here in the same transaction as the change that caused
+-- it, then delivered by a background worker
+CREATE TABLE IF NOT EXISTS email_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(50) NOT NULL,
+    recipient VARCHAR(255) NOT NULL,
+    subject TEXT NOT NULL,
+    html_body TEXT NOT NULL DEFAULT '',
+    text_body TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_email_outbox_due ON email_outbox(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_email_outbox_status ON email_outbox(status, id DESC);
+CREATE INDEX idx_email_outbox_recipient ON email_outbox(LOWER(recipient), id DESC);
+
+INSERT INTO permissions (name, description) VALUES
+    ('email.read', 'See outgoing email and its delivery status');
+
+INSERT INTO role_permissions (role_name, permission_name) VALUES
+    ('support', 'email.read'),
+    ('admin', 'email.read');
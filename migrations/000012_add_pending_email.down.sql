@@ -0,0 +1,5 @@
+ALTER TABLE users
+  DROP COLUMN pending_email,
+  DROP COLUMN email_change_token,
+  DROP COLUMN email_cancel_token,
+  DROP COLUMN email_change_expires_at;
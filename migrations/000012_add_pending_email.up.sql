@@ -0,0 +1,11 @@
+-- An email change is held here until the new address is confirmed. Both
+-- tokens are SHA-256 hex digests: one confirms the change from the new
+-- address, the other cancels it from the old one.
+ALTER TABLE users
+  ADD COLUMN pending_email VARCHAR(255),
+  ADD COLUMN email_change_token VARCHAR(64),
+  ADD COLUMN email_cancel_token VARCHAR(64),
+  ADD COLUMN email_change_expires_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_users_email_change_token ON users(email_change_token);
+CREATE INDEX idx_users_email_cancel_token ON users(email_cancel_token);
@@ -0,0 +1,9 @@
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE email_outbox DROP COLUMN user_id;
+
+ALTER TABLE users DROP COLUMN deletion_scheduled_at;
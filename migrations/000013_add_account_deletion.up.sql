@@ -0,0 +1,44 @@
+-- Accounts are deleted a grace period after the user asks
+ALTER TABLE users ADD COLUMN deletion_scheduled_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_users_deletion_scheduled_at ON users(deletion_scheduled_at)
+    WHERE deletion_scheduled_at IS NOT NULL;
+
+-- Emails are tied to the user they were sent for, since the address they
+-- went to may no longer be the user's. Emails already queued are matched
+-- on the addresses users have now, preferring their current one.
+ALTER TABLE email_outbox ADD COLUMN user_id INTEGER;
+
+UPDATE email_outbox o SET user_id = u.id
+FROM users u
+WHERE LOWER(o.recipient) = LOWER(u.pending_email);
+
+UPDATE email_outbox o SET user_id = u.id
+FROM users u
+WHERE LOWER(o.recipient) = LOWER(u.email);
+
+CREATE INDEX idx_email_outbox_user_id ON email_outbox(user_id, id DESC);
+
+-- Audit events outlive deleted users, but not their personal data. The one
+-- change the log allows is blanking an event's IP address and user agent,
+-- along with any of its snapshots and metadata; everything else, and
+-- deleting, is still rejected.
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+       AND NEW.id = OLD.id
+       AND NEW.event_type = OLD.event_type
+       AND NEW.actor_user_id IS NOT DISTINCT FROM OLD.actor_user_id
+       AND NEW.subject_user_id IS NOT DISTINCT FROM OLD.subject_user_id
+       AND NEW.request_id = OLD.request_id
+       AND NEW.created_at = OLD.created_at
+       AND NEW.ip_address = ''
+       AND NEW.user_agent = ''
+       AND (NEW.before_state IS NULL OR NEW.before_state IS NOT DISTINCT FROM OLD.before_state)
+       AND (NEW.after_state IS NULL OR NEW.after_state IS NOT DISTINCT FROM OLD.after_state)
+       AND (NEW.metadata = '{}'::jsonb OR NEW.metadata = OLD.metadata) THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
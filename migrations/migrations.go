@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL migrations so the binary can apply them
+// itself. Files follow golang-migrate's NNNNNN_name.up.sql / .down.sql naming.
+// The Postgres migrations are at the top level and SQLite's, which are
+// numbered separately, are in sqlite/. A schema change needs both.
+package migrations
+
+import "embed"
+
+//go:embed *.sql sqlite/*.sql
+var FS embed.FS
@@ -0,0 +1,122 @@
+-- The SQLite schema matches the Postgres one as of its migration 9.
+-- Timestamps are stored as UTC text, which the repositories write with
+-- microsecond precision so they sort and compare correctly.
+CREATE TABLE roles (
+    name TEXT PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE permissions (
+    name TEXT PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE role_permissions (
+    role_name TEXT NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    permission_name TEXT NOT NULL REFERENCES permissions(name) ON DELETE CASCADE,
+    PRIMARY KEY (role_name, permission_name)
+);
+
+INSERT INTO roles (name, description) VALUES
+    ('user', 'Regular user'),
+    ('support', 'Support staff who can look up users and help with verification'),
+    ('admin', 'Administrator with full access');
+
+INSERT INTO permissions (name, description) VALUES
+    ('admin.access', 'Open the admin area'),
+    ('users.read', 'List and search users'),
+    ('users.resend_verification', 'Resend verification emails'),
+    ('users.disable', 'Disable and re-enable accounts'),
+    ('users.sessions.revoke', 'Force-logout a user''s sessions'),
+    ('audit.read', 'Search the audit log');
+
+INSERT INTO role_permissions (role_name, permission_name) VALUES
+    ('support', 'admin.access'),
+    ('support', 'users.read'),
+    ('support', 'users.resend_verification'),
+    ('admin', 'admin.access'),
+    ('admin', 'users.read'),
+    ('admin', 'users.resend_verification'),
+    ('admin', 'users.disable'),
+    ('admin', 'users.sessions.revoke'),
+    ('admin', 'audit.read');
+
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    email TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    first_name TEXT,
+    last_name TEXT,
+    email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    verification_token TEXT,
+    verification_expires_at TIMESTAMP,
+    role TEXT NOT NULL DEFAULT 'user' REFERENCES roles(name),
+    disabled_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_users_verification_token ON users(verification_token);
+CREATE INDEX idx_users_role ON users(role);
+
+CREATE TABLE sessions (
+    id TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    ip_address TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    remember_me BOOLEAN NOT NULL DEFAULT FALSE,
+    expires_at TIMESTAMP NOT NULL,
+    absolute_expires_at TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX idx_sessions_expires_at ON sessions(expires_at);
+
+CREATE TABLE api_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    scopes TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_api_tokens_user_id ON api_tokens(user_id);
+
+-- No foreign keys: events must outlive the users and sessions they mention
+CREATE TABLE audit_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    event_type TEXT NOT NULL,
+    actor_user_id INTEGER,
+    subject_user_id INTEGER,
+    ip_address TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    before_state TEXT,
+    after_state TEXT,
+    metadata TEXT NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_audit_events_subject_user_id ON audit_events(subject_user_id, id DESC);
+CREATE INDEX idx_audit_events_actor_user_id ON audit_events(actor_user_id, id DESC);
+CREATE INDEX idx_audit_events_event_type ON audit_events(event_type, id DESC);
+CREATE INDEX idx_audit_events_request_id ON audit_events(request_id) WHERE request_id <> '';
+CREATE INDEX idx_audit_events_created_at ON audit_events(created_at);
+
+-- The log is append-only: reject every update and delete
+CREATE TRIGGER audit_events_no_update
+    BEFORE UPDATE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+CREATE TRIGGER audit_events_no_delete
+    BEFORE DELETE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
@@ -0,0 +1,28 @@
+-- Email is written here in the same transaction as the change that caused
+-- it, then delivered by a background worker
+CREATE TABLE email_outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL,
+    recipient TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    html_body TEXT NOT NULL DEFAULT '',
+    text_body TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP NOT NULL,
+    sent_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_email_outbox_due ON email_outbox(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_email_outbox_status ON email_outbox(status, id DESC);
+CREATE INDEX idx_email_outbox_recipient ON email_outbox(recipient COLLATE NOCASE, id DESC);
+
+INSERT INTO permissions (name, description) VALUES
+    ('email.read', 'See outgoing email and its delivery status');
+
+INSERT INTO role_permissions (role_name, permission_name) VALUES
+    ('support', 'email.read'),
+    ('admin', 'email.read');
@@ -0,0 +1,10 @@
+-- Addresses email must not be sent to, because mail to them bounced
+-- permanently or their owner reported it as spam. Addresses are stored in
+-- lower case.
+CREATE TABLE email_suppressions (
+    email TEXT PRIMARY KEY,
+    reason TEXT NOT NULL CHECK (reason IN ('bounce', 'complaint')),
+    detail TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
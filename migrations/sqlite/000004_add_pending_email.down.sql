@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_users_email_change_token;
+DROP INDEX IF EXISTS idx_users_email_cancel_token;
+
+ALTER TABLE users DROP COLUMN pending_email;
+ALTER TABLE users DROP COLUMN email_change_token;
+ALTER TABLE users DROP COLUMN email_cancel_token;
+ALTER TABLE users DROP COLUMN email_change_expires_at;
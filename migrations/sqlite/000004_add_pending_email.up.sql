@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN pending_email TEXT;
+ALTER TABLE users ADD COLUMN email_change_token TEXT;
+ALTER TABLE users ADD COLUMN email_cancel_token TEXT;
+ALTER TABLE users ADD COLUMN email_change_expires_at TIMESTAMP;
+
+CREATE INDEX idx_users_email_change_token ON users(email_change_token);
+CREATE INDEX idx_users_email_cancel_token ON users(email_cancel_token);
@@ -0,0 +1,15 @@
+DROP TRIGGER audit_events_no_update;
+
+CREATE TRIGGER audit_events_no_update
+    BEFORE UPDATE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+DROP INDEX IF EXISTS idx_email_outbox_user_id;
+
+ALTER TABLE email_outbox DROP COLUMN user_id;
+
+DROP INDEX IF EXISTS idx_users_deletion_scheduled_at;
+
+ALTER TABLE users DROP COLUMN deletion_scheduled_at;